@@ -62,6 +62,17 @@ function App() {
       cleanUp();
     });
 
+    socket.on("noMatch", (reason) => {
+      console.log("No match: ", reason);
+      toast("No song found.");
+      cleanUp();
+    });
+
+    socket.on("matchError", (msg) => {
+      toast.error(msg);
+      cleanUp();
+    });
+
     socket.on("downloadStatus", (msg) => {
       msg = JSON.parse(msg);
       const msgTypes = ["info", "success", "error"];
@@ -217,13 +228,11 @@ function App() {
             return
           }
 
-          const fingerprintMap = result.data.reduce((dict, item) => {
-            dict[item.address] = item.anchorTime;
-            return dict;
-          }, {});
-
           if (sendRecordingRef.current) {
-            socket.emit("newFingerprint", JSON.stringify({ fingerprint: fingerprintMap }));
+            socket.emit(
+              "newFingerprint",
+              JSON.stringify({ fingerprint: result.data, configVersion: result.configVersion })
+            );
           }
 
           if (uploadRecording) {
@@ -7,7 +7,7 @@ start_server() {
     export CERT_KEY="/etc/letsencrypt/live/localport.online/privkey.pem"
     export CERT_FILE="/etc/letsencrypt/live/localport.online/fullchain.pem"
 
-    go build -tags netgo -ldflags '-s -w' -o app
+    go build -tags "netgo sqlite_fts5" -ldflags '-s -w' -o app
     sudo setcap CAP_NET_BIND_SERVICE+ep app
     nohup ./app serve -proto https -p 4443 > backend.log 2>&1 &
 }
@@ -3,12 +3,15 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
 	"math"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"song-recognition/db"
@@ -18,6 +21,9 @@ import (
 	"song-recognition/wav"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
 
 	"github.com/fatih/color"
 	socketio "github.com/googollee/go-socket.io"
@@ -34,25 +40,36 @@ const (
 
 var yellow = color.New(color.FgYellow)
 
-func find(filePath string) {
+func find(filePath string, decisionOpts shazam.DecisionOptions) {
+	ctx := context.Background()
 	wavFilePath, err := wav.ConvertToWAV(filePath)
 	if err != nil {
 		yellow.Println("Error converting to WAV:", err)
 		return
 	}
 
-	fingerprint, err := shazam.FingerprintAudio(wavFilePath, utils.GenerateUniqueID())
+	cfg, err := shazam.ConfigFromEnv()
 	if err != nil {
-		yellow.Println("Error generating fingerprint for sample: ", err)
+		yellow.Println("Error:", err)
+		return
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		yellow.Println("Error connecting to DB:", err)
 		return
 	}
+	defer dbClient.Close()
 
-	sampleFingerprint := make(map[uint32]uint32)
-	for address, couple := range fingerprint {
-		sampleFingerprint[address] = couple.AnchorTimeMs
+	recognizer := shazam.NewRecognizer(dbClient, cfg, shazam.RecognizerOptions{Decision: decisionOpts, MaxMatches: 20})
+
+	fingerprint, err := shazam.FingerprintAudio(wavFilePath, utils.GenerateUniqueID(), cfg)
+	if err != nil {
+		yellow.Println("Error generating fingerprint for sample: ", err)
+		return
 	}
 
-	matches, searchDuration, err := shazam.FindMatchesFGP(sampleFingerprint)
+	matches, searchDuration, err := recognizer.FindMatchesFGP(ctx, fingerprint, cfg.Version())
 	if err != nil {
 		yellow.Println("Error finding matches:", err)
 		return
@@ -65,58 +82,830 @@ func find(filePath string) {
 	}
 
 	msg := "Matches:"
-	topMatches := matches
-	if len(matches) >= 20 {
+	if len(matches) == 20 {
 		msg = "Top 20 matches:"
-		topMatches = matches[:20]
 	}
 
 	fmt.Println(msg)
-	for _, match := range topMatches {
-		fmt.Printf("\t- %s by %s, score: %.2f\n",
-			match.SongTitle, match.SongArtist, match.Score)
+	for _, match := range matches {
+		fmt.Printf("\t- %s by %s, at %s, confidence: %.0f%% (%d aligned hashes, %.1f%% of sample)\n",
+			match.SongTitle, match.SongArtist, formatOffset(match.OffsetMs),
+			match.Confidence*100, match.AlignedHashes, match.MatchedFraction*100)
 	}
 
 	fmt.Printf("\nSearch took: %s\n", searchDuration)
-	topMatch := topMatches[0]
-	fmt.Printf("\nFinal prediction: %s by %s , score: %.2f\n",
-		topMatch.SongTitle, topMatch.SongArtist, topMatch.Score)
+
+	decision := recognizer.Decide(matches)
+	if decision.NoMatch {
+		fmt.Printf("\nNo match: %s\n", decision.Reason)
+		return
+	}
+
+	topMatch := decision.Match
+	fmt.Printf("\nFinal prediction: %s by %s, at %s, %.0f%% confident\n",
+		topMatch.SongTitle, topMatch.SongArtist, formatOffset(topMatch.OffsetMs), topMatch.Confidence*100)
+	if topMatch.Album != "" {
+		fmt.Printf("From %s", topMatch.Album)
+		if topMatch.Duration > 0 {
+			fmt.Printf(" (%s)", formatOffset(float64(topMatch.Duration)*1000))
+		}
+		fmt.Println()
+	}
+	if math.Abs(topMatch.SpeedFactor-1) > 1e-9 {
+		fmt.Printf("Sample plays at %.1f%% of the original speed\n", topMatch.SpeedFactor*100)
+	}
+}
+
+func tracklist(filePath, format string, opts shazam.TracklistOptions) {
+	ctx := context.Background()
+	wavFilePath, err := wav.ConvertToWAV(filePath)
+	if err != nil {
+		yellow.Println("Error converting to WAV:", err)
+		return
+	}
+
+	wavInfo, err := wav.ReadWavInfo(wavFilePath)
+	if err != nil {
+		yellow.Println("Error reading WAV file:", err)
+		return
+	}
+
+	cfg, err := shazam.ConfigFromEnv()
+	if err != nil {
+		yellow.Println("Error:", err)
+		return
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		yellow.Println("Error connecting to DB:", err)
+		return
+	}
+	defer dbClient.Close()
+
+	recognizer := shazam.NewRecognizer(dbClient, cfg, shazam.DefaultRecognizerOptions())
+	entries, err := shazam.Tracklist(ctx, recognizer, wavInfo.LeftChannelSamples, wavInfo.SampleRate, opts)
+	if err != nil {
+		yellow.Println("Error building tracklist:", err)
+		return
+	}
+
+	switch format {
+	case "json":
+		err = shazam.WriteTracklistJSON(os.Stdout, entries)
+	case "cue":
+		err = shazam.WriteTracklistCUE(os.Stdout, entries, filepath.Base(filePath))
+	default:
+		if len(entries) == 0 {
+			fmt.Println("No songs recognised.")
+			return
+		}
+		err = shazam.WriteTracklistText(os.Stdout, entries)
+	}
+	if err != nil {
+		yellow.Println("Error writing tracklist:", err)
+	}
+}
+
+// monitorSampleRate is the rate ffmpeg decodes monitored sources to.
+const monitorSampleRate = 44100
+
+// monitor recognises what plays on source and writes JSON lines events to
+// stdout. source is decoded with ffmpeg unless raw is set; "-" reads raw PCM
+// from stdin. With save, every ended segment is added to the plays table.
+func monitor(source string, raw bool, sampleRate, channels int, save bool, opts shazam.MonitorOptions) {
+	ctx := context.Background()
+	cfg, err := shazam.ConfigFromEnv()
+	if err != nil {
+		yellow.Println("Error:", err)
+		return
+	}
+
+	var input io.ReadCloser
+	switch {
+	case source == "-":
+		input = os.Stdin
+	case raw:
+		input, err = os.Open(source)
+	default:
+		sampleRate, channels = monitorSampleRate, 1
+		input, err = wav.DecodeStream(source, sampleRate, channels)
+	}
+	if err != nil {
+		yellow.Println("Error opening source:", err)
+		return
+	}
+	defer input.Close()
+
+	// Stop decoding on Ctrl+C so the current segment is still reported.
+	var interrupted atomic.Bool
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		<-interrupt
+		interrupted.Store(true)
+		input.Close()
+	}()
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		yellow.Println("Error connecting to DB:", err)
+		return
+	}
+	defer dbClient.Close()
+
+	recognizer := shazam.NewRecognizer(dbClient, cfg, shazam.DefaultRecognizerOptions())
+	encoder := json.NewEncoder(os.Stdout)
+	err = shazam.MonitorPCM(ctx, recognizer, input, sampleRate, channels, time.Now(), opts, func(event shazam.MonitorEvent) error {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+		if !save || event.Event != "ended" {
+			return nil
+		}
+
+		play := db.Play{
+			Source:    source,
+			Kind:      event.Kind,
+			SongID:    event.SongID,
+			StartedAt: event.Time.Add(-time.Duration(event.Duration * float64(time.Second))),
+			EndedAt:   event.Time,
+		}
+		if err := dbClient.StorePlay(ctx, play); err != nil {
+			yellow.Println("Error saving play:", err)
+		}
+		return nil
+	})
+	if err != nil && !interrupted.Load() {
+		yellow.Println("Error monitoring source:", err)
+	}
+}
+
+// visualize renders the spectrogram and peaks of filePath to outputPath as a
+// PNG or SVG image. With query, the recording is matched against the index
+// and the hashes it shares with songID (or the best match when 0) are drawn
+// with the offset histogram.
+func visualize(filePath, outputPath, format string, query bool, songID uint32) {
+	wavFilePath, err := wav.ConvertToWAV(filePath)
+	if err != nil {
+		yellow.Println("Error converting to WAV:", err)
+		return
+	}
+
+	wavInfo, err := wav.ReadWavInfo(wavFilePath)
+	if err != nil {
+		yellow.Println("Error reading WAV file:", err)
+		return
+	}
+
+	cfg, err := shazam.ConfigFromEnv()
+	if err != nil {
+		yellow.Println("Error:", err)
+		return
+	}
+
+	v, err := shazam.Visualize(wavInfo.LeftChannelSamples, wavInfo.SampleRate, cfg)
+	if err != nil {
+		yellow.Println("Error:", err)
+		return
+	}
+
+	if query {
+		ctx := context.Background()
+		dbClient, err := db.NewDBClient()
+		if err != nil {
+			yellow.Println("Error connecting to DB:", err)
+			return
+		}
+		defer dbClient.Close()
+
+		if err := v.TraceMatch(ctx, dbClient, songID); err != nil {
+			yellow.Println("Error tracing match:", err)
+			return
+		}
+	}
+
+	if outputPath == "" {
+		outputPath = strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath)) + "." + format
+	}
+	file, err := os.Create(outputPath)
+	if err != nil {
+		yellow.Println("Error creating image:", err)
+		return
+	}
+	defer file.Close()
+
+	if format == "svg" {
+		err = v.WriteSVG(file)
+	} else {
+		err = v.WritePNG(file)
+	}
+	if err != nil {
+		yellow.Println("Error writing image:", err)
+		return
+	}
+
+	fmt.Printf("Wrote %s (%d peaks)\n", outputPath, len(v.Peaks))
+	if t := v.Trace; t != nil {
+		fmt.Printf("%s by %s, at %s: %d aligned and %d misaligned hashes, %d offset votes\n",
+			t.Match.SongTitle, t.Match.SongArtist, formatOffset(t.Match.OffsetMs),
+			len(t.Aligned), len(t.Misaligned), len(t.Offsets))
+	}
+}
+
+// evaluationSampleRate is the rate audio is decoded to for evaluation.
+const evaluationSampleRate = 44100
+
+// evaluate measures recognition accuracy on the songs of libraryDir that are
+// in the database, and the false-positive rate on the recordings of
+// negativesDir, if given. The report is printed as a table and, with
+// jsonPath, also written as JSON.
+func evaluate(libraryDir, negativesDir, jsonPath string, opts shazam.EvaluationOptions) {
+	ctx := context.Background()
+	cfg, err := shazam.ConfigFromEnv()
+	if err != nil {
+		yellow.Println("Error:", err)
+		return
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		yellow.Println("Error connecting to DB:", err)
+		return
+	}
+	defer dbClient.Close()
+
+	recognizer := shazam.NewRecognizer(dbClient, cfg, shazam.RecognizerOptions{Decision: opts.Decision})
+	evaluator, err := shazam.NewEvaluator(recognizer, opts)
+	if err != nil {
+		yellow.Println("Error:", err)
+		return
+	}
+
+	libraryFiles, err := listFiles(libraryDir)
+	if err != nil {
+		yellow.Println("Error listing library:", err)
+		return
+	}
+	for _, filePath := range libraryFiles {
+		title, artist, err := songTags(filePath)
+		if err != nil {
+			fmt.Printf("Skipping %v: %v\n", filePath, err)
+			continue
+		}
+		_, indexed, err := dbClient.GetSongByKey(ctx, utils.GenerateSongKey(title, artist))
+		if err != nil || !indexed {
+			fmt.Printf("Skipping %v: not in the database\n", filePath)
+			continue
+		}
+
+		samples, err := wav.DecodeSamples(filePath, evaluationSampleRate)
+		if err != nil {
+			fmt.Printf("Skipping %v: %v\n", filePath, err)
+			continue
+		}
+		fmt.Printf("Evaluating '%s' by '%s'\n", title, artist)
+		if err := evaluator.AddSong(ctx, title, artist, samples, evaluationSampleRate); err != nil {
+			yellow.Println("Error evaluating song:", err)
+			return
+		}
+	}
+
+	if negativesDir != "" {
+		negativeFiles, err := listFiles(negativesDir)
+		if err != nil {
+			yellow.Println("Error listing non-library recordings:", err)
+			return
+		}
+		for _, filePath := range negativeFiles {
+			samples, err := wav.DecodeSamples(filePath, evaluationSampleRate)
+			if err != nil {
+				fmt.Printf("Skipping %v: %v\n", filePath, err)
+				continue
+			}
+			fmt.Printf("Evaluating non-library recording %v\n", filepath.Base(filePath))
+			if err := evaluator.AddNonLibrary(ctx, samples, evaluationSampleRate); err != nil {
+				yellow.Println("Error evaluating recording:", err)
+				return
+			}
+		}
+	}
+
+	report := evaluator.Report()
+	fmt.Println()
+	if err := shazam.WriteEvaluationTable(os.Stdout, report); err != nil {
+		yellow.Println("Error writing report:", err)
+		return
+	}
+
+	if jsonPath != "" {
+		file, err := os.Create(jsonPath)
+		if err != nil {
+			yellow.Println("Error creating report:", err)
+			return
+		}
+		defer file.Close()
+		if err := shazam.WriteEvaluationJSON(file, report); err != nil {
+			yellow.Println("Error writing report:", err)
+		}
+	}
+}
+
+// songTags returns the title and artist a song file was saved under: its
+// tags, or its file name when it has no title tag.
+func songTags(filePath string) (title, artist string, err error) {
+	metadata, err := wav.GetMetadata(filePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	tags := metadata.Format.Tags
+	title, artist = tags["title"], tags["artist"]
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	}
+	if artist == "" {
+		return "", "", fmt.Errorf("no artist found in metadata")
+	}
+	return title, artist, nil
+}
+
+// listFiles returns the files under dir, recursively.
+func listFiles(dir string) ([]string, error) {
+	var filePaths []string
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			filePaths = append(filePaths, filePath)
+		}
+		return nil
+	})
+	return filePaths, err
+}
+
+// dedupe lists clusters of indexed songs that are the same recording and,
+// with remove, deletes every song but the canonical one of each cluster.
+func dedupe(opts shazam.DuplicateOptions, remove, asJSON bool) {
+	ctx := context.Background()
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		yellow.Println("Error connecting to DB:", err)
+		return
+	}
+	defer dbClient.Close()
+
+	clusters, err := shazam.FindDuplicates(ctx, dbClient, opts)
+	if err != nil {
+		yellow.Println("Error finding duplicates:", err)
+		return
+	}
+
+	if asJSON {
+		if clusters == nil {
+			clusters = []shazam.DuplicateCluster{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(clusters); err != nil {
+			yellow.Println("Error writing clusters:", err)
+			return
+		}
+	} else if len(clusters) == 0 {
+		fmt.Println("No duplicates found.")
+	} else {
+		for _, cluster := range clusters {
+			song := cluster.Canonical
+			fmt.Printf("keep    [%d] '%s' by '%s' (%d fingerprints)\n", song.SongID, song.Title, song.Artist, song.Fingerprints)
+			for _, song := range cluster.Duplicates {
+				fmt.Printf("  dup   [%d] '%s' by '%s' (%d fingerprints, %.0f%% matched)\n",
+					song.SongID, song.Title, song.Artist, song.Fingerprints, song.MatchedFraction*100)
+			}
+		}
+	}
+
+	if !remove {
+		return
+	}
+	removed, err := shazam.RemoveDuplicates(ctx, dbClient, clusters)
+	if err != nil {
+		yellow.Println("Error removing duplicates:", err)
+	}
+	if !asJSON {
+		fmt.Printf("Removed %d duplicate songs.\n", removed)
+	}
+}
+
+// parseSongIDs parses a comma-separated list of song IDs.
+func parseSongIDs(list string) ([]uint32, error) {
+	var songIDs []uint32
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		songID, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid song ID %q", field)
+		}
+		songIDs = append(songIDs, uint32(songID))
+	}
+	return songIDs, nil
+}
+
+// songRef identifies a song by ID, by YouTube ID or by title and artist,
+// whichever is set first.
+type songRef struct {
+	ID        uint32 `json:"id"`
+	YouTubeID string `json:"ytID"`
+	Title     string `json:"title"`
+	Artist    string `json:"artist"`
+}
+
+func (ref songRef) String() string {
+	switch {
+	case ref.ID != 0:
+		return fmt.Sprintf("ID %d", ref.ID)
+	case ref.YouTubeID != "":
+		return fmt.Sprintf("YouTube ID '%s'", ref.YouTubeID)
+	default:
+		return fmt.Sprintf("'%s' by '%s'", ref.Title, ref.Artist)
+	}
+}
+
+func lookupSong(ctx context.Context, dbClient db.DBClient, ref songRef) (db.Song, bool, error) {
+	switch {
+	case ref.ID != 0:
+		return dbClient.GetSongByID(ctx, ref.ID)
+	case ref.YouTubeID != "":
+		return dbClient.GetSongByYTID(ctx, ref.YouTubeID)
+	case ref.Title != "" && ref.Artist != "":
+		return dbClient.GetSongByKey(ctx, utils.GenerateSongKey(ref.Title, ref.Artist))
+	}
+	return db.Song{}, false, fmt.Errorf("no song given: set an ID, a YouTube ID, or a title and artist")
+}
+
+// deleteSongFile deletes the WAV file of a removed song and returns its path,
+// or "" if there was none. Only files in the songs directory are deleted.
+func deleteSongFile(song db.Song) (string, error) {
+	filePath := shazam.SongFilePath(song, SONGS_DIR)
+	songsDir, err := filepath.Abs(SONGS_DIR)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	if filepath.Dir(absPath) != songsDir || strings.ToLower(filepath.Ext(absPath)) != ".wav" {
+		return "", fmt.Errorf("not deleting %s: not a WAV file in %s", filePath, SONGS_DIR)
+	}
+
+	if _, err := os.Stat(absPath); os.IsNotExist(err) {
+		return "", nil
+	}
+	if err := os.Remove(absPath); err != nil {
+		return "", fmt.Errorf("error deleting %s: %v", filePath, err)
+	}
+	return filePath, nil
+}
+
+func remove(refs []songRef, deleteFile bool) {
+	ctx := context.Background()
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		yellow.Println("Error connecting to DB:", err)
+		return
+	}
+	defer dbClient.Close()
+
+	for _, ref := range refs {
+		song, found, err := lookupSong(ctx, dbClient, ref)
+		if err != nil {
+			yellow.Printf("Error looking up song %s: %v\n", ref, err)
+			continue
+		}
+		if !found {
+			yellow.Printf("No song with %s.\n", ref)
+			continue
+		}
+
+		if err := dbClient.DeleteSongByID(ctx, song.ID); err != nil {
+			yellow.Printf("Error removing '%s' by '%s': %v\n", song.Title, song.Artist, err)
+			continue
+		}
+		fmt.Printf("Removed [%d] '%s' by '%s'.\n", song.ID, song.Title, song.Artist)
+
+		if !deleteFile {
+			continue
+		}
+		deletedFile, err := deleteSongFile(song)
+		if err != nil {
+			yellow.Println("Error:", err)
+		} else if deletedFile != "" {
+			fmt.Printf("Deleted %s.\n", deletedFile)
+		}
+	}
+}
+
+// fsck checks the database against itself and the songs directory and
+// repairs what opts selects.
+func fsck(opts shazam.RepairOptions) {
+	ctx := context.Background()
+	// A database that cannot be migrated, e.g. because of duplicate keys, is
+	// still checked so that it can be repaired.
+	dbClient, err := db.OpenDBClient()
+	if err != nil {
+		yellow.Println("Error connecting to DB:", err)
+		return
+	}
+	defer dbClient.Close()
+	if _, err := dbClient.Migrate(ctx); err != nil {
+		yellow.Println("Error migrating database:", err)
+	}
+
+	report, err := shazam.CheckIndex(ctx, dbClient, SONGS_DIR)
+	if err != nil {
+		yellow.Println("Error checking index:", err)
+		return
+	}
+
+	fmt.Printf("Checked %d songs.\n", report.Songs)
+	if len(report.OrphanFingerprints) > 0 {
+		total := 0
+		for _, count := range report.OrphanFingerprints {
+			total += count
+		}
+		fmt.Printf("%d orphan fingerprints of %d unregistered song IDs\n", total, len(report.OrphanFingerprints))
+	}
+	for _, song := range report.EmptySongs {
+		fmt.Printf("no fingerprints  [%d] '%s' by '%s'\n", song.ID, song.Title, song.Artist)
+	}
+	for _, group := range report.DuplicateSongs {
+		song := group[0]
+		fmt.Printf("duplicate        [%d] '%s' by '%s' (%d fingerprints)\n", song.ID, song.Title, song.Artist, song.FingerprintCount)
+		for _, song := range group[1:] {
+			fmt.Printf("  of             [%d] '%s' by '%s' (%d fingerprints)\n", song.ID, song.Title, song.Artist, song.FingerprintCount)
+		}
+	}
+	for _, song := range report.MissingFiles {
+		fmt.Printf("missing file     [%d] '%s' by '%s': %s\n", song.ID, song.Title, song.Artist, song.FilePath)
+	}
+	for _, filePath := range report.ExtraFiles {
+		fmt.Printf("extra file       %s\n", filePath)
+	}
+
+	if report.Clean() {
+		fmt.Println("No problems found.")
+		return
+	}
+	if !opts.Prune && !opts.Refingerprint && !opts.Register {
+		fmt.Println("Run with -prune, -refingerprint or -register to repair.")
+		return
+	}
+
+	result, err := shazam.RepairIndex(ctx, dbClient, report, opts)
+	if err != nil {
+		yellow.Println("Error repairing index:", err)
+		return
+	}
+	for _, err := range result.Errors {
+		yellow.Println("Error:", err)
+	}
+	fmt.Printf("Pruned %d songs and %d orphan fingerprints, re-fingerprinted %d songs, registered %d files.\n",
+		result.PrunedSongs, result.PrunedFingerprints, result.Refingerprinted, result.Registered)
+}
+
+// songPage is a page of songs, as printed by 'list -json' and 'search -json'
+// and sent on "songList".
+type songPage struct {
+	Songs  []db.Song `json:"songs"`
+	Total  int       `json:"total"`
+	Offset int       `json:"offset"`
+}
+
+// findSongs returns a page of the songs, or of those matching text if search
+// is set.
+func findSongs(ctx context.Context, dbClient db.DBClient, search bool, text string, mode db.SearchMode, query db.SongQuery) (songPage, error) {
+	var songs []db.Song
+	var total int
+	var err error
+	if search {
+		songs, total, err = dbClient.SearchSongs(ctx, text, mode, query)
+	} else {
+		songs, total, err = dbClient.ListSongs(ctx, query)
+	}
+	if songs == nil {
+		songs = []db.Song{}
+	}
+	return songPage{Songs: songs, Total: total, Offset: query.Offset}, err
+}
+
+// listSongs prints a page of the songs in the database, or of those matching
+// text if search is set, as a table or as JSON.
+func listSongs(search bool, text string, mode db.SearchMode, query db.SongQuery, asJSON bool) {
+	ctx := context.Background()
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		yellow.Println("Error connecting to DB:", err)
+		return
+	}
+	defer dbClient.Close()
+
+	page, err := findSongs(ctx, dbClient, search, text, mode, query)
+	if err != nil {
+		yellow.Println("Error listing songs:", err)
+		return
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(page); err != nil {
+			yellow.Println("Error writing songs:", err)
+		}
+		return
+	}
+	if page.Total == 0 {
+		fmt.Println("No songs found.")
+		return
+	}
+	if len(page.Songs) == 0 {
+		fmt.Printf("No songs at offset %d (%d in total).\n", page.Offset, page.Total)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tARTIST\tALBUM\tFINGERPRINTS\tADDED")
+	for _, song := range page.Songs {
+		added := ""
+		if !song.DateAdded.IsZero() {
+			added = song.DateAdded.Local().Format("2006-01-02")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%s\n", song.ID, song.Title, song.Artist, song.Album, song.FingerprintCount, added)
+	}
+	tw.Flush()
+	fmt.Printf("\nSongs %d-%d of %d.\n", page.Offset+1, page.Offset+len(page.Songs), page.Total)
+}
+
+// exportBundle writes the songs selected by opts to a bundle at bundlePath.
+func exportBundle(bundlePath string, opts shazam.ExportOptions) {
+	ctx := context.Background()
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		yellow.Println("Error connecting to DB:", err)
+		return
+	}
+	defer dbClient.Close()
+
+	file, err := os.Create(bundlePath)
+	if err != nil {
+		yellow.Println("Error creating bundle:", err)
+		return
+	}
+	exported, err := shazam.ExportBundle(ctx, dbClient, file, opts)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(bundlePath)
+		yellow.Println("Error exporting songs:", err)
+		return
+	}
+	fmt.Printf("Exported %d songs to %s.\n", exported, bundlePath)
+}
+
+// importBundle adds the songs of the bundles at bundlePaths to the database.
+func importBundle(bundlePaths []string) {
+	ctx := context.Background()
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		yellow.Println("Error connecting to DB:", err)
+		return
+	}
+	defer dbClient.Close()
+
+	for _, bundlePath := range bundlePaths {
+		file, err := os.Open(bundlePath)
+		if err != nil {
+			yellow.Println("Error opening bundle:", err)
+			continue
+		}
+		result, err := shazam.ImportBundle(ctx, dbClient, file)
+		file.Close()
+		for _, song := range result.Skipped {
+			fmt.Printf("exists  '%s' by '%s'\n", song.Title, song.Artist)
+		}
+		if err != nil {
+			yellow.Printf("Error importing %s: %v\n", bundlePath, err)
+			if result.Imported == 0 {
+				continue
+			}
+		}
+		fmt.Printf("Imported %d songs (%d fingerprints) from %s, skipped %d.\n",
+			result.Imported, result.Fingerprints, bundlePath, len(result.Skipped))
+	}
+}
+
+// formatOffset renders a song position in milliseconds as m:ss.
+func formatOffset(offsetMs float64) string {
+	sign := ""
+	if offsetMs < 0 {
+		sign = "-"
+		offsetMs = -offsetMs
+	}
+	seconds := int(math.Round(offsetMs / 1000))
+	return fmt.Sprintf("%s%d:%02d", sign, seconds/60, seconds%60)
 }
 
 func download(spotifyURL string) {
+	ctx := context.Background()
 	err := utils.CreateFolder(SONGS_DIR)
 	if err != nil {
 		err := xerrors.New(err)
 		logger := utils.GetLogger()
-		ctx := context.Background()
 		logMsg := fmt.Sprintf("failed to create directory %v", SONGS_DIR)
 		logger.ErrorContext(ctx, logMsg, slog.Any("error", err))
 	}
 
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		yellow.Println("Error connecting to DB:", err)
+		return
+	}
+	defer dbClient.Close()
+
 	if strings.Contains(spotifyURL, "album") {
-		_, err := spotify.DlAlbum(spotifyURL, SONGS_DIR)
+		_, err := spotify.DlAlbum(ctx, dbClient, spotifyURL, SONGS_DIR)
 		if err != nil {
 			yellow.Println("Error: ", err)
 		}
 	}
 
 	if strings.Contains(spotifyURL, "playlist") {
-		_, err := spotify.DlPlaylist(spotifyURL, SONGS_DIR)
+		_, err := spotify.DlPlaylist(ctx, dbClient, spotifyURL, SONGS_DIR)
 		if err != nil {
 			yellow.Println("Error: ", err)
 		}
 	}
 
 	if strings.Contains(spotifyURL, "track") {
-		_, err := spotify.DlSingleTrack(spotifyURL, SONGS_DIR)
+		_, err := spotify.DlSingleTrack(ctx, dbClient, spotifyURL, SONGS_DIR)
 		if err != nil {
 			yellow.Println("Error: ", err)
 		}
 	}
 }
 
+const (
+	// requestTimeout bounds the database work of a socket event.
+	requestTimeout = 30 * time.Second
+	// downloadTimeout bounds a download, which fingerprints every track of
+	// an album or playlist.
+	downloadTimeout = 30 * time.Minute
+)
+
+// connection is the state kept for each socket. Its context is canceled when
+// the socket disconnects.
+type connection struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// requestContext returns the context of an event on socket: the socket's
+// context with a deadline of timeout.
+func requestContext(socket socketio.Conn, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if conn, ok := socket.Context().(*connection); ok {
+		ctx = conn.ctx
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 func serve(protocol, port string) {
 	protocol = strings.ToLower(protocol)
+
+	// One client serves every connection for the lifetime of the server.
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		log.Fatalf("failed to connect to DB: %v", err)
+	}
+	defer dbClient.Close()
+	if sqliteClient, ok := dbClient.(*db.SQLiteClient); ok && !sqliteClient.FullTextIndexed() {
+		log.Println("SQLite was built without FTS5, so full-text search scans every song; build with -tags sqlite_fts5 to index it")
+	}
+
+	cfg, err := shazam.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("invalid fingerprint config: %v", err)
+	}
+	recognizer := shazam.NewRecognizer(dbClient, cfg, shazam.RecognizerOptions{
+		Decision:   shazam.DefaultDecisionOptions(),
+		MaxMatches: 10,
+	})
+
 	var allowOriginFunc = func(r *http.Request) bool {
 		return true
 	}
@@ -133,22 +922,54 @@ func serve(protocol, port string) {
 	})
 
 	server.OnConnect("/", func(socket socketio.Conn) error {
-		socket.SetContext("")
+		ctx, cancel := context.WithCancel(context.Background())
+		socket.SetContext(&connection{ctx: ctx, cancel: cancel})
 		log.Println("CONNECTED: ", socket.ID())
 
 		return nil
 	})
 
-	server.OnEvent("/", "totalSongs", handleTotalSongs)
-	server.OnEvent("/", "newDownload", handleSongDownload)
+	server.OnEvent("/", "totalSongs", func(socket socketio.Conn) {
+		ctx, cancel := requestContext(socket, requestTimeout)
+		defer cancel()
+		handleTotalSongs(ctx, socket, dbClient)
+	})
+	server.OnEvent("/", "newDownload", func(socket socketio.Conn, spotifyURL string) {
+		ctx, cancel := requestContext(socket, downloadTimeout)
+		defer cancel()
+		handleSongDownload(ctx, socket, dbClient, spotifyURL)
+	})
+	server.OnEvent("/", "removeSong", func(socket socketio.Conn, removeData string) {
+		ctx, cancel := requestContext(socket, requestTimeout)
+		defer cancel()
+		handleRemoveSong(ctx, socket, dbClient, removeData)
+	})
+	server.OnEvent("/", "listSongs", func(socket socketio.Conn, listData string) {
+		ctx, cancel := requestContext(socket, requestTimeout)
+		defer cancel()
+		handleListSongs(ctx, socket, dbClient, false, listData)
+	})
+	server.OnEvent("/", "searchSongs", func(socket socketio.Conn, searchData string) {
+		ctx, cancel := requestContext(socket, requestTimeout)
+		defer cancel()
+		handleListSongs(ctx, socket, dbClient, true, searchData)
+	})
 	server.OnEvent("/", "newRecording", handleNewRecording)
-	server.OnEvent("/", "newFingerprint", handleNewFingerprint)
+	server.OnEvent("/", "newFingerprint", func(socket socketio.Conn, fingerprintData string) {
+		ctx, cancel := requestContext(socket, requestTimeout)
+		defer cancel()
+		handleNewFingerprint(ctx, socket, recognizer, fingerprintData)
+	})
 
 	server.OnError("/", func(s socketio.Conn, e error) {
 		log.Println("meet error:", e)
 	})
 
 	server.OnDisconnect("/", func(s socketio.Conn, reason string) {
+		// Abandon the queries still running for the client.
+		if conn, ok := s.Context().(*connection); ok {
+			conn.cancel()
+		}
 		log.Println("closed", reason)
 	})
 
@@ -210,13 +1031,34 @@ func erase(songsDir string, dbOnly bool, all bool) {
 		logger.ErrorContext(ctx, msg, slog.Any("error", err))
 	}
 
-	err = dbClient.DeleteCollection("fingerprints")
+	err = dbClient.DeleteCollection(ctx, "fingerprints")
+	if err != nil {
+		msg := fmt.Sprintf("Error deleting collection: %v\n", err)
+		logger.ErrorContext(ctx, msg, slog.Any("error", err))
+	}
+
+	err = dbClient.DeleteCollection(ctx, "songs")
+	if err != nil {
+		msg := fmt.Sprintf("Error deleting collection: %v\n", err)
+		logger.ErrorContext(ctx, msg, slog.Any("error", err))
+	}
+
+	// Plays refer to the songs being erased.
+	err = dbClient.DeleteCollection(ctx, "plays")
+	if err != nil {
+		msg := fmt.Sprintf("Error deleting collection: %v\n", err)
+		logger.ErrorContext(ctx, msg, slog.Any("error", err))
+	}
+
+	// The fingerprint config is recorded again when the next song is saved.
+	err = dbClient.DeleteCollection(ctx, "meta")
 	if err != nil {
 		msg := fmt.Sprintf("Error deleting collection: %v\n", err)
 		logger.ErrorContext(ctx, msg, slog.Any("error", err))
 	}
 
-	err = dbClient.DeleteCollection("songs")
+	// Forget the applied migrations so the next client recreates the schema.
+	err = dbClient.DeleteCollection(ctx, "schema_migrations")
 	if err != nil {
 		msg := fmt.Sprintf("Error deleting collection: %v\n", err)
 		logger.ErrorContext(ctx, msg, slog.Any("error", err))
@@ -252,13 +1094,96 @@ func erase(songsDir string, dbOnly bool, all bool) {
 	fmt.Println("Erase complete")
 }
 
+// migrate prints the schema migrations of the database with "status",
+// applies the pending ones with "up", or records the current fingerprint
+// config in an index built without one with "adopt-config".
+func migrate(action string) {
+	ctx := context.Background()
+	dbClient, err := db.OpenDBClient()
+	if err != nil {
+		yellow.Println("Error connecting to DB:", err)
+		return
+	}
+	defer dbClient.Close()
+
+	if action == "adopt-config" {
+		adoptIndexConfig(ctx, dbClient)
+		return
+	}
+
+	if action == "up" {
+		migrated, err := dbClient.Migrate(ctx)
+		for _, migration := range migrated {
+			fmt.Printf("Applied %d: %s\n", migration.Version, migration.Description)
+		}
+		if err != nil {
+			yellow.Println("Error migrating database:", err)
+			return
+		}
+		if len(migrated) == 0 {
+			fmt.Println("Schema is up to date.")
+		}
+	}
+
+	statuses, err := dbClient.MigrationStatus(ctx)
+	if err != nil {
+		yellow.Println("Error reading schema migrations:", err)
+		return
+	}
+
+	if action == "status" {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tDESCRIPTION\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = status.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			if status.Unknown {
+				applied += " (unknown to this build)"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", status.Version, status.Description, applied)
+		}
+		tw.Flush()
+		fmt.Println()
+	}
+	fmt.Printf("Schema version: %d\n", db.SchemaVersion(statuses))
+}
+
+// adoptIndexConfig records the environment's fingerprint config in an index
+// whose songs were fingerprinted before configs were recorded.
+func adoptIndexConfig(ctx context.Context, dbClient db.DBClient) {
+	if _, err := dbClient.Migrate(ctx); err != nil {
+		yellow.Println("Error migrating database:", err)
+		return
+	}
+	cfg, err := shazam.ConfigFromEnv()
+	if err != nil {
+		yellow.Println("Error: invalid fingerprint config:", err)
+		return
+	}
+	if err := shazam.AdoptIndexConfig(ctx, dbClient, cfg); err != nil {
+		yellow.Println("Error adopting fingerprint config:", err)
+		return
+	}
+	fmt.Printf("Index fingerprint config: %s\n", cfg.Version())
+}
+
 func save(path string, force bool) {
+	ctx := context.Background()
 	fileInfo, err := os.Stat(path)
 	if err != nil {
 		fmt.Printf("Error stating path %v: %v\n", path, err)
 		return
 	}
 
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		fmt.Printf("Error connecting to DB: %v\n", err)
+		return
+	}
+	defer dbClient.Close()
+
 	if fileInfo.IsDir() {
 		var filePaths []string
 		err := filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
@@ -277,16 +1202,16 @@ func save(path string, force bool) {
 			return
 		}
 
-		processFilesConCurrently(filePaths, force)
+		processFilesConCurrently(ctx, dbClient, filePaths, force)
 	} else {
-		err := saveSong(path, force)
+		err := saveSong(ctx, dbClient, path, force)
 		if err != nil {
 			fmt.Printf("Error saving song (%v): %v\n", path, err)
 		}
 	}
 }
 
-func processFilesConCurrently(filePaths []string, force bool) {
+func processFilesConCurrently(ctx context.Context, dbClient db.DBClient, filePaths []string, force bool) {
 	maxWorkers := runtime.NumCPU() / 2
 	numFiles := len(filePaths)
 
@@ -304,7 +1229,7 @@ func processFilesConCurrently(filePaths []string, force bool) {
 	for w := 0; w < maxWorkers; w++ {
 		go func(workerID int) {
 			for filePath := range jobs {
-				err := saveSong(filePath, force)
+				err := saveSong(ctx, dbClient, filePath, force)
 				results <- err
 			}
 		}(w + 1)
@@ -330,7 +1255,7 @@ func processFilesConCurrently(filePaths []string, force bool) {
 	fmt.Printf("\n ->> Processed %d files: %d successful, %d failed\n", numFiles, successCount, errorCount)
 }
 
-func saveSong(filePath string, force bool) error {
+func saveSong(ctx context.Context, dbClient db.DBClient, filePath string, force bool) error {
 	metadata, err := wav.GetMetadata(filePath)
 	if err != nil {
 		return err
@@ -364,15 +1289,35 @@ func saveSong(filePath string, force bool) error {
 		return fmt.Errorf("no artist found in metadata")
 	}
 
-	err = spotify.ProcessAndSaveSong(filePath, track.Title, track.Artist, ytID)
+	// The song is fingerprinted from a WAV copy, which is moved to the songs
+	// directory afterwards.
+	wavFile := fileName + ".wav"
+	sourcePath := filepath.Join(filepath.Dir(filePath), wavFile)
+	newFilePath := filepath.Join(SONGS_DIR, wavFile)
+
+	song := db.Song{
+		Title:     track.Title,
+		Artist:    track.Artist,
+		YouTubeID: ytID,
+		Album:     track.Album,
+		Duration:  track.Duration,
+		ISRC:      tags["isrc"],
+		FilePath:  newFilePath,
+	}
+	if song.ISRC == "" {
+		song.ISRC = tags["tsrc"] // ID3 frame
+	}
+	if artists := tags["artists"]; artists != "" {
+		for _, artist := range strings.Split(artists, ";") {
+			song.Artists = append(song.Artists, strings.TrimSpace(artist))
+		}
+	}
+
+	err = spotify.ProcessAndSaveSong(ctx, dbClient, filePath, song)
 	if err != nil {
 		return fmt.Errorf("failed to process or save song: %v", err)
 	}
 
-	// Move song in wav format to songs directory
-	wavFile := fileName + ".wav"
-	sourcePath := filepath.Join(filepath.Dir(filePath), wavFile)
-	newFilePath := filepath.Join(SONGS_DIR, wavFile)
 	err = utils.MoveFile(sourcePath, newFilePath)
 	if err != nil {
 		return fmt.Errorf("failed to rename temporary file to output file: %v", err)
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SongSort is the order ListSongs and SearchSongs return songs in.
+type SongSort string
+
+const (
+	// SortByTitle orders songs by title, then artist, ignoring case.
+	SortByTitle SongSort = "title"
+	// SortByArtist orders songs by artist, then title, ignoring case.
+	SortByArtist SongSort = "artist"
+	// SortByAdded orders songs by the date they were added. Songs registered
+	// before dates were recorded come first.
+	SortByAdded SongSort = "added"
+)
+
+// SongQuery selects a page of songs. The zero value lists every song by
+// title.
+type SongQuery struct {
+	SortBy     SongSort // SortByTitle when empty
+	Descending bool
+	// Offset songs are skipped, and at most Limit are returned; 0 means no
+	// limit.
+	Offset int
+	Limit  int
+}
+
+// check fills in the default sort and rejects invalid queries.
+func (q *SongQuery) check() error {
+	switch q.SortBy {
+	case "":
+		q.SortBy = SortByTitle
+	case SortByTitle, SortByArtist, SortByAdded:
+	default:
+		return fmt.Errorf("invalid sort %q (expected %q, %q or %q)", q.SortBy, SortByTitle, SortByArtist, SortByAdded)
+	}
+	if q.Offset < 0 || q.Limit < 0 {
+		return fmt.Errorf("invalid page: offset %d, limit %d", q.Offset, q.Limit)
+	}
+	return nil
+}
+
+// page returns the songs of the query's page out of every matching song,
+// already sorted.
+func (q SongQuery) page(songs []Song) []Song {
+	if q.Offset >= len(songs) {
+		return nil
+	}
+	songs = songs[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(songs) {
+		songs = songs[:q.Limit]
+	}
+	return songs
+}
+
+// SearchMode is how SearchSongs matches the search text.
+type SearchMode string
+
+const (
+	// SearchSubstring matches the songs whose title or artist contains the
+	// text, ignoring case.
+	SearchSubstring SearchMode = "substring"
+	// SearchFullText matches the songs whose title and artist together
+	// contain every word of the text, ignoring case and word order. It uses
+	// the backend's full-text index where there is one.
+	SearchFullText SearchMode = "fulltext"
+)
+
+func checkSearchMode(mode SearchMode) error {
+	if mode != SearchSubstring && mode != SearchFullText {
+		return fmt.Errorf("invalid search mode %q (expected %q or %q)", mode, SearchSubstring, SearchFullText)
+	}
+	return nil
+}
+
+// searchWords splits text into lower-case words of letters and digits, as
+// full-text indexes do.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// matchesSearch reports whether song matches text in mode, for backends
+// that search without an index.
+func matchesSearch(song Song, text string, mode SearchMode) bool {
+	if mode == SearchSubstring {
+		text = strings.ToLower(text)
+		return strings.Contains(strings.ToLower(song.Title), text) || strings.Contains(strings.ToLower(song.Artist), text)
+	}
+
+	songWords := make(map[string]bool)
+	for _, word := range searchWords(song.Title + " " + song.Artist) {
+		songWords[word] = true
+	}
+	for _, word := range searchWords(text) {
+		if !songWords[word] {
+			return false
+		}
+	}
+	return true
+}
+
+// sortSongs sorts songs in the order of q.SortBy, with the ID breaking ties.
+func sortSongs(songs []Song, q SongQuery) {
+	less := func(a, b Song) bool {
+		switch q.SortBy {
+		case SortByArtist:
+			if c := compareFold(a.Artist, b.Artist); c != 0 {
+				return c < 0
+			}
+			if c := compareFold(a.Title, b.Title); c != 0 {
+				return c < 0
+			}
+		case SortByAdded:
+			if !a.DateAdded.Equal(b.DateAdded) {
+				return a.DateAdded.Before(b.DateAdded)
+			}
+		default:
+			if c := compareFold(a.Title, b.Title); c != 0 {
+				return c < 0
+			}
+			if c := compareFold(a.Artist, b.Artist); c != 0 {
+				return c < 0
+			}
+		}
+		return a.ID < b.ID
+	}
+	sort.Slice(songs, func(i, j int) bool {
+		if q.Descending {
+			return less(songs[j], songs[i])
+		}
+		return less(songs[i], songs[j])
+	})
+}
+
+func compareFold(a, b string) int {
+	return strings.Compare(sortKey(a), sortKey(b))
+}
+
+// sortKey is the form of a title or artist that songs are sorted by,
+// compared bytewise. Mongo stores it with each song, as its $toLower only
+// folds ASCII letters.
+func sortKey(s string) string {
+	return strings.ToLower(s)
+}
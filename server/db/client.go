@@ -1,34 +1,109 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"song-recognition/models"
 	"song-recognition/utils"
+	"time"
 )
 
+// DBClient is the song database. Implementations are safe for concurrent use
+// and pool their connections, so a single client should serve a whole server
+// or command. Every method but Close gives up when its context is done.
 type DBClient interface {
 	Close() error
-	StoreFingerprints(fingerprints map[uint32]models.Couple) error
-	GetCouples(addresses []uint32) (map[uint32][]models.Couple, error)
-	TotalSongs() (int, error)
-	RegisterSong(songTitle, songArtist, ytID string) (uint32, error)
-	GetSong(filterKey string, value interface{}) (Song, bool, error)
-	GetSongByID(songID uint32) (Song, bool, error)
-	GetSongByYTID(ytID string) (Song, bool, error)
-	GetSongByKey(key string) (Song, bool, error)
-	DeleteSongByID(songID uint32) error
-	DeleteCollection(collectionName string) error
+	StoreFingerprints(ctx context.Context, fingerprints []models.Fingerprint) error
+	GetCouples(ctx context.Context, addresses []uint32) (map[uint32][]models.Couple, error)
+	TotalSongs(ctx context.Context) (int, error)
+	RegisterSong(ctx context.Context, song Song) (uint32, error)
+	GetSong(ctx context.Context, filterKey string, value interface{}) (Song, bool, error)
+	GetSongByID(ctx context.Context, songID uint32) (Song, bool, error)
+	GetSongByYTID(ctx context.Context, ytID string) (Song, bool, error)
+	GetSongByKey(ctx context.Context, key string) (Song, bool, error)
+	SongIDs(ctx context.Context) ([]uint32, error)
+	// ListSongs returns a page of the songs and the number of songs.
+	ListSongs(ctx context.Context, query SongQuery) ([]Song, int, error)
+	// SearchSongs returns a page of the songs matching text and the number of
+	// songs matching it.
+	SearchSongs(ctx context.Context, text string, mode SearchMode, query SongQuery) ([]Song, int, error)
+	GetSongFingerprints(ctx context.Context, songID uint32) ([]models.Fingerprint, error)
+	FingerprintCounts(ctx context.Context) (map[uint32]int, error)
+	DeleteSongByID(ctx context.Context, songID uint32) error
+	DeleteSongFingerprints(ctx context.Context, songID uint32) error
+	DeleteCollection(ctx context.Context, collectionName string) error
+	GetMeta(ctx context.Context, key string) (string, bool, error)
+	SetMeta(ctx context.Context, key, value string) error
+	StorePlay(ctx context.Context, play Play) error
+	MigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+	Migrate(ctx context.Context) ([]Migration, error)
 }
 
+// Song is the metadata of an indexed song. Only Title and Artist are
+// required; the other fields are empty when unknown.
 type Song struct {
+	ID        uint32 // set by RegisterSong
 	Title     string
 	Artist    string
 	YouTubeID string
+	Album     string
+	Artists   []string // every credited artist, Artist first
+	Duration  int      // in seconds
+	SpotifyID string
+	ISRC      string
+	// FilePath is the audio file the song was fingerprinted from.
+	FilePath string
+	// DateAdded is set by RegisterSong; it is zero for songs registered
+	// before it was recorded.
+	DateAdded time.Time
+	// FingerprintCount is kept up to date by StoreFingerprints and
+	// DeleteSongFingerprints.
+	FingerprintCount int
 }
 
-var DBtype = utils.GetEnv("DB_TYPE", "sqlite") // Can be "sqlite" or "mongo"
+// Play is a stretch of a monitored source: a recognised song, audio that
+// matched nothing, or silence.
+type Play struct {
+	Source    string
+	Kind      string // "song", "unknown" or "gap"
+	SongID    uint32 // set when Kind is "song"
+	StartedAt time.Time
+	EndedAt   time.Time
+}
+
+var DBtype = utils.GetEnv("DB_TYPE", "sqlite") // Can be "sqlite", "mongo" or "memory"
 
+// NewDBClient connects to the database selected by DB_TYPE and applies any
+// pending schema migrations. With DB_AUTO_MIGRATE=false it fails instead, and
+// the schema is only changed by the 'migrate up' command.
 func NewDBClient() (DBClient, error) {
+	client, err := OpenDBClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if utils.GetEnv("DB_AUTO_MIGRATE", "true") == "false" {
+		statuses, err := client.MigrationStatus(context.Background())
+		if err == nil {
+			err = checkSchema(statuses)
+		}
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		return client, nil
+	}
+
+	if _, err := client.Migrate(context.Background()); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("error migrating database: %v", err)
+	}
+	return client, nil
+}
+
+// OpenDBClient connects to the database selected by DB_TYPE without touching
+// its schema, for inspecting and migrating it.
+func OpenDBClient() (DBClient, error) {
 	switch DBtype {
 	case "mongo":
 		var (
@@ -43,10 +118,14 @@ func NewDBClient() (DBClient, error) {
 		if dbUsername == "" || dbPassword == "" {
 			dbUri = "mongodb://localhost:27017"
 		}
-		return NewMongoClient(dbUri)
+		return openMongoClient(dbUri)
 
 	case "sqlite":
-		return NewSQLiteClient("db/db.sqlite3")
+		return openSQLiteClient(utils.GetEnv("SQLITE_PATH", "db/db.sqlite3"))
+
+	case "memory":
+		// Every client is a new, empty database.
+		return NewMemoryClient(), nil
 
 	default:
 		return nil, fmt.Errorf("unsupported database type: %s", DBtype)
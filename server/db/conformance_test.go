@@ -0,0 +1,675 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"song-recognition/models"
+	"song-recognition/utils"
+	"sort"
+	"testing"
+	"time"
+)
+
+// The conformance suite pins down the semantics every DBClient must share,
+// so that code written against one backend works on the others.
+
+func TestSQLiteConformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) DBClient {
+		client, err := NewSQLiteClient(filepath.Join(t.TempDir(), "db.sqlite3"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	})
+}
+
+func TestMemoryConformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) DBClient {
+		return NewMemoryClient()
+	})
+}
+
+// TestMongoConformance runs against the server at MONGO_TEST_URI, in a
+// database of its own that is dropped afterwards.
+func TestMongoConformance(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+
+	testConformance(t, func(t *testing.T) DBClient {
+		client := newMongoTestClient(t, uri)
+		if _, err := client.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return client
+	})
+}
+
+// newMongoTestClient returns an unmigrated client of the server at uri, in a
+// database of its own that is dropped after the test. The database is dropped
+// over another connection, since tests close their client.
+func newMongoTestClient(t *testing.T, uri string) *MongoClient {
+	client, err := openMongoClient(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbName := fmt.Sprintf("song-recognition-test-%d", time.Now().UnixNano())
+	client.dbName = dbName
+	t.Cleanup(func() {
+		admin, err := openMongoClient(uri)
+		if err != nil {
+			t.Logf("cannot drop %s: %v", dbName, err)
+			return
+		}
+		defer admin.Close()
+		admin.client.Database(dbName).Drop(context.Background())
+	})
+	return client
+}
+
+// testConformance runs every conformance test on fresh clients from
+// newClient.
+func testConformance(t *testing.T, newClient func(t *testing.T) DBClient) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, client DBClient)
+	}{
+		{"RegisterAndGetSong", testRegisterAndGetSong},
+		{"DuplicateSong", testDuplicateSong},
+		{"MissingSong", testMissingSong},
+		{"InvalidFilterKey", testInvalidFilterKey},
+		{"DeleteSong", testDeleteSong},
+		{"Fingerprints", testFingerprints},
+		{"DuplicateFingerprints", testDuplicateFingerprints},
+		{"DeleteSongFingerprints", testDeleteSongFingerprints},
+		{"FingerprintCounts", testFingerprintCounts},
+		{"SongIDs", testSongIDs},
+		{"ListSongs", testListSongs},
+		{"SearchSongs", testSearchSongs},
+		{"Meta", testMeta},
+		{"Plays", testPlays},
+		{"Schema", testSchema},
+		{"CanceledContext", testCanceledContext},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newClient(t)
+			defer client.Close()
+			tc.test(t, client)
+		})
+	}
+}
+
+func mustRegister(t *testing.T, client DBClient, song Song) uint32 {
+	t.Helper()
+	ctx := context.Background()
+	songID, err := client.RegisterSong(ctx, song)
+	if err != nil {
+		t.Fatalf("registering %q: %v", song.Title, err)
+	}
+	return songID
+}
+
+func mustStore(t *testing.T, client DBClient, fingerprints ...models.Fingerprint) {
+	t.Helper()
+	ctx := context.Background()
+	if err := client.StoreFingerprints(ctx, fingerprints); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testRegisterAndGetSong(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	song := Song{
+		Title:     "Title",
+		Artist:    "Artist",
+		YouTubeID: "yt1",
+		Album:     "Album",
+		Artists:   []string{"Artist", "Guest"},
+		Duration:  215,
+		SpotifyID: "spotify1",
+		ISRC:      "USRC17607839",
+		FilePath:  "songs/Title - Artist.wav",
+	}
+	songID := mustRegister(t, client, song)
+
+	byID, found, err := client.GetSongByID(ctx, songID)
+	if err != nil || !found {
+		t.Fatalf("GetSongByID: %v, %v", found, err)
+	}
+	if byID.DateAdded.IsZero() {
+		t.Error("date added not set")
+	}
+	want := song
+	want.ID, want.DateAdded = songID, byID.DateAdded
+	if !reflect.DeepEqual(byID, want) {
+		t.Errorf("GetSongByID = %+v, want %+v", byID, want)
+	}
+
+	if got, found, err := client.GetSongByYTID(ctx, "yt1"); err != nil || !found || got.ID != songID {
+		t.Errorf("GetSongByYTID = %+v, %v, %v", got, found, err)
+	}
+	if got, found, err := client.GetSongByKey(ctx, utils.GenerateSongKey("Title", "Artist")); err != nil || !found || got.ID != songID {
+		t.Errorf("GetSongByKey = %+v, %v, %v", got, found, err)
+	}
+	for _, filter := range []struct {
+		key   string
+		value interface{}
+	}{{"id", songID}, {"ytID", "yt1"}, {"key", utils.GenerateSongKey("Title", "Artist")}} {
+		if got, found, err := client.GetSong(ctx, filter.key, filter.value); err != nil || !found || got.Title != "Title" {
+			t.Errorf("GetSong(%q) = %+v, %v, %v", filter.key, got, found, err)
+		}
+	}
+
+	if total, err := client.TotalSongs(ctx); err != nil || total != 1 {
+		t.Errorf("TotalSongs = %d, %v, want 1", total, err)
+	}
+}
+
+func testDuplicateSong(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	mustRegister(t, client, Song{Title: "Title", Artist: "Artist", YouTubeID: "yt1"})
+
+	// Songs are unique by title and artist, whatever their YouTube ID.
+	if _, err := client.RegisterSong(ctx, Song{Title: "Title", Artist: "Artist", YouTubeID: "yt2"}); err == nil {
+		t.Error("registered the same song twice")
+	}
+	// Songs saved without a YouTube video share the empty ID.
+	mustRegister(t, client, Song{Title: "Other", Artist: "Artist"})
+	mustRegister(t, client, Song{Title: "Third", Artist: "Artist"})
+
+	if total, err := client.TotalSongs(ctx); err != nil || total != 3 {
+		t.Errorf("TotalSongs = %d, %v, want 3", total, err)
+	}
+}
+
+func testMissingSong(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	if _, found, err := client.GetSongByID(ctx, 12345); err != nil || found {
+		t.Errorf("GetSongByID = %v, %v, want not found", found, err)
+	}
+	if _, found, err := client.GetSongByYTID(ctx, "missing"); err != nil || found {
+		t.Errorf("GetSongByYTID = %v, %v, want not found", found, err)
+	}
+	if _, found, err := client.GetSongByKey(ctx, "missing---song"); err != nil || found {
+		t.Errorf("GetSongByKey = %v, %v, want not found", found, err)
+	}
+	if err := client.DeleteSongByID(ctx, 12345); err != nil {
+		t.Errorf("deleting a missing song: %v", err)
+	}
+	if err := client.DeleteSongFingerprints(ctx, 12345); err != nil {
+		t.Errorf("deleting the fingerprints of a missing song: %v", err)
+	}
+	if total, err := client.TotalSongs(ctx); err != nil || total != 0 {
+		t.Errorf("TotalSongs = %d, %v, want 0", total, err)
+	}
+}
+
+func testInvalidFilterKey(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	for _, key := range []string{"", "title", "i", "id | ytID"} {
+		if _, _, err := client.GetSong(ctx, key, "x"); err == nil {
+			t.Errorf("GetSong(%q) accepted an invalid filter key", key)
+		}
+	}
+}
+
+func testDeleteSong(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	songID := mustRegister(t, client, Song{Title: "Title", Artist: "Artist", YouTubeID: "yt1"})
+	other := mustRegister(t, client, Song{Title: "Other", Artist: "Artist"})
+	mustStore(t, client,
+		models.Fingerprint{Address: 1, AnchorTimeMs: 100, SongID: songID},
+		models.Fingerprint{Address: 2, AnchorTimeMs: 200, SongID: songID},
+		models.Fingerprint{Address: 1, AnchorTimeMs: 300, SongID: other},
+	)
+	if err := client.DeleteSongByID(ctx, songID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The song's fingerprints go with it; the other song's stay.
+	couples, err := client.GetCouples(ctx, []uint32{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(couples[1], []models.Couple{{AnchorTimeMs: 300, SongID: other}}) || len(couples[2]) != 0 {
+		t.Errorf("couples after delete = %+v, want only the other song's", couples)
+	}
+	if fingerprints, err := client.GetSongFingerprints(ctx, songID); err != nil || len(fingerprints) != 0 {
+		t.Errorf("GetSongFingerprints after delete = %+v, %v", fingerprints, err)
+	}
+
+	if _, found, err := client.GetSongByID(ctx, songID); err != nil || found {
+		t.Errorf("GetSongByID after delete = %v, %v", found, err)
+	}
+	if total, err := client.TotalSongs(ctx); err != nil || total != 1 {
+		t.Errorf("TotalSongs = %d, %v, want 1", total, err)
+	}
+	// The key is free again.
+	mustRegister(t, client, Song{Title: "Title", Artist: "Artist", YouTubeID: "yt1"})
+}
+
+func sortedCouples(couples []models.Couple) []models.Couple {
+	sort.Slice(couples, func(i, j int) bool {
+		if couples[i].SongID != couples[j].SongID {
+			return couples[i].SongID < couples[j].SongID
+		}
+		return couples[i].AnchorTimeMs < couples[j].AnchorTimeMs
+	})
+	return couples
+}
+
+func sortedFingerprints(fingerprints []models.Fingerprint) []models.Fingerprint {
+	sort.Slice(fingerprints, func(i, j int) bool {
+		if fingerprints[i].Address != fingerprints[j].Address {
+			return fingerprints[i].Address < fingerprints[j].Address
+		}
+		return fingerprints[i].AnchorTimeMs < fingerprints[j].AnchorTimeMs
+	})
+	return fingerprints
+}
+
+func testFingerprints(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	a := mustRegister(t, client, Song{Title: "A", Artist: "Artist"})
+	b := mustRegister(t, client, Song{Title: "B", Artist: "Artist"})
+
+	// Address 1 occurs twice in A and once in B.
+	mustStore(t, client,
+		models.Fingerprint{Address: 1, AnchorTimeMs: 100, SongID: a},
+		models.Fingerprint{Address: 1, AnchorTimeMs: 900, SongID: a},
+		models.Fingerprint{Address: 2, AnchorTimeMs: 200, SongID: a},
+	)
+	mustStore(t, client, models.Fingerprint{Address: 1, AnchorTimeMs: 300, SongID: b})
+
+	couples, err := client.GetCouples(ctx, []uint32{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.Couple{{AnchorTimeMs: 100, SongID: a}, {AnchorTimeMs: 900, SongID: a}, {AnchorTimeMs: 300, SongID: b}}
+	if got := sortedCouples(couples[1]); !reflect.DeepEqual(got, sortedCouples(want)) {
+		t.Errorf("couples of address 1 = %+v, want %+v", got, want)
+	}
+	if got := couples[2]; !reflect.DeepEqual(got, []models.Couple{{AnchorTimeMs: 200, SongID: a}}) {
+		t.Errorf("couples of address 2 = %+v", got)
+	}
+	if len(couples[3]) != 0 {
+		t.Errorf("couples of unknown address 3 = %+v", couples[3])
+	}
+
+	fingerprints, err := client.GetSongFingerprints(ctx, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFingerprints := []models.Fingerprint{
+		{Address: 1, AnchorTimeMs: 100, SongID: a},
+		{Address: 1, AnchorTimeMs: 900, SongID: a},
+		{Address: 2, AnchorTimeMs: 200, SongID: a},
+	}
+	if got := sortedFingerprints(fingerprints); !reflect.DeepEqual(got, wantFingerprints) {
+		t.Errorf("GetSongFingerprints = %+v, want %+v", got, wantFingerprints)
+	}
+
+	for songID, want := range map[uint32]int{a: 3, b: 1} {
+		song, _, err := client.GetSongByID(ctx, songID)
+		if err != nil || song.FingerprintCount != want {
+			t.Errorf("song %d counts %d fingerprints (%v), want %d", songID, song.FingerprintCount, err, want)
+		}
+	}
+}
+
+func testDuplicateFingerprints(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	songID := mustRegister(t, client, Song{Title: "A", Artist: "Artist"})
+
+	fingerprint := models.Fingerprint{Address: 1, AnchorTimeMs: 100, SongID: songID}
+	mustStore(t, client, fingerprint, fingerprint)
+	mustStore(t, client, fingerprint)
+
+	couples, err := client.GetCouples(ctx, []uint32{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(couples[1]) != 1 {
+		t.Errorf("a fingerprint stored three times has %d couples, want 1", len(couples[1]))
+	}
+	if song, _, err := client.GetSongByID(ctx, songID); err != nil || song.FingerprintCount != 1 {
+		t.Errorf("fingerprint count = %d (%v), want 1", song.FingerprintCount, err)
+	}
+}
+
+func testDeleteSongFingerprints(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	a := mustRegister(t, client, Song{Title: "A", Artist: "Artist"})
+	b := mustRegister(t, client, Song{Title: "B", Artist: "Artist"})
+	mustStore(t, client,
+		models.Fingerprint{Address: 1, AnchorTimeMs: 100, SongID: a},
+		models.Fingerprint{Address: 2, AnchorTimeMs: 200, SongID: a},
+		models.Fingerprint{Address: 1, AnchorTimeMs: 300, SongID: b},
+	)
+
+	if err := client.DeleteSongFingerprints(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+
+	couples, err := client.GetCouples(ctx, []uint32{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(couples[1], []models.Couple{{AnchorTimeMs: 300, SongID: b}}) {
+		t.Errorf("couples of address 1 = %+v, want only B's", couples[1])
+	}
+	if len(couples[2]) != 0 {
+		t.Errorf("couples of address 2 = %+v, want none", couples[2])
+	}
+	if fingerprints, err := client.GetSongFingerprints(ctx, a); err != nil || len(fingerprints) != 0 {
+		t.Errorf("GetSongFingerprints = %+v, %v, want none", fingerprints, err)
+	}
+	if song, found, err := client.GetSongByID(ctx, a); err != nil || !found || song.FingerprintCount != 0 {
+		t.Errorf("song A = %+v, %v, %v, want registered without fingerprints", song, found, err)
+	}
+	if song, _, err := client.GetSongByID(ctx, b); err != nil || song.FingerprintCount != 1 {
+		t.Errorf("song B counts %d fingerprints (%v), want 1", song.FingerprintCount, err)
+	}
+}
+
+func testFingerprintCounts(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	if counts, err := client.FingerprintCounts(ctx); err != nil || len(counts) != 0 {
+		t.Errorf("FingerprintCounts of an empty index = %v, %v", counts, err)
+	}
+
+	songID := mustRegister(t, client, Song{Title: "A", Artist: "Artist"})
+	mustRegister(t, client, Song{Title: "Empty", Artist: "Artist"})
+	orphan := songID + 1 // never registered
+	mustStore(t, client,
+		models.Fingerprint{Address: 1, AnchorTimeMs: 100, SongID: songID},
+		models.Fingerprint{Address: 2, AnchorTimeMs: 200, SongID: songID},
+		models.Fingerprint{Address: 1, AnchorTimeMs: 300, SongID: orphan},
+	)
+
+	counts, err := client.FingerprintCounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[uint32]int{songID: 2, orphan: 1}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("FingerprintCounts = %v, want %v", counts, want)
+	}
+}
+
+func testSongIDs(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	if songIDs, err := client.SongIDs(ctx); err != nil || len(songIDs) != 0 {
+		t.Errorf("SongIDs of an empty database = %v, %v", songIDs, err)
+	}
+
+	var want []uint32
+	for i := 0; i < 5; i++ {
+		want = append(want, mustRegister(t, client, Song{Title: fmt.Sprintf("Song %d", i), Artist: "Artist"}))
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i] < want[j] })
+
+	if got, err := client.SongIDs(ctx); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("SongIDs = %v, %v, want %v in order", got, err, want)
+	}
+}
+
+// registerCatalog registers songs whose titles and artists differ in case
+// and share words.
+func registerCatalog(t *testing.T, client DBClient) {
+	t.Helper()
+	for _, song := range []Song{
+		{Title: "Yellow Submarine", Artist: "The Beatles"},
+		{Title: "Let It Be", Artist: "The Beatles"},
+		{Title: "yellow", Artist: "Coldplay"},
+		{Title: "Paranoid Android", Artist: "Radiohead"},
+		{Title: "Submarine", Artist: "Yellow Magic Orchestra"},
+	} {
+		mustRegister(t, client, song)
+	}
+}
+
+func songTitles(songs []Song) []string {
+	titles := []string{}
+	for _, song := range songs {
+		titles = append(titles, song.Title)
+	}
+	return titles
+}
+
+func testListSongs(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	if songs, total, err := client.ListSongs(ctx, SongQuery{}); err != nil || len(songs) != 0 || total != 0 {
+		t.Errorf("ListSongs of an empty database = %v, %d, %v", songs, total, err)
+	}
+	registerCatalog(t, client)
+
+	tests := []struct {
+		query SongQuery
+		want  []string
+	}{
+		{SongQuery{}, []string{"Let It Be", "Paranoid Android", "Submarine", "yellow", "Yellow Submarine"}},
+		{SongQuery{SortBy: SortByTitle, Descending: true}, []string{"Yellow Submarine", "yellow", "Submarine", "Paranoid Android", "Let It Be"}},
+		{SongQuery{SortBy: SortByArtist}, []string{"yellow", "Paranoid Android", "Let It Be", "Yellow Submarine", "Submarine"}},
+		{SongQuery{Offset: 1, Limit: 2}, []string{"Paranoid Android", "Submarine"}},
+		{SongQuery{Offset: 4, Limit: 2}, []string{"Yellow Submarine"}},
+		{SongQuery{Offset: 5}, []string{}},
+	}
+	for _, tc := range tests {
+		songs, total, err := client.ListSongs(ctx, tc.query)
+		if err != nil {
+			t.Fatalf("%+v: %v", tc.query, err)
+		}
+		if got := songTitles(songs); !reflect.DeepEqual(got, tc.want) || total != 5 {
+			t.Errorf("%+v: got %q of %d, want %q of 5", tc.query, got, total, tc.want)
+		}
+	}
+
+	songs, _, err := client.ListSongs(ctx, SongQuery{SortBy: SortByAdded})
+	if err != nil || len(songs) != 5 {
+		t.Fatalf("sorting by date added: %d songs, %v", len(songs), err)
+	}
+	for i := 1; i < len(songs); i++ {
+		if songs[i].DateAdded.Before(songs[i-1].DateAdded) {
+			t.Errorf("song %d was added before song %d", i, i-1)
+		}
+	}
+
+	if _, _, err := client.ListSongs(ctx, SongQuery{SortBy: "plays"}); err == nil {
+		t.Error("listed songs by an invalid sort")
+	}
+	if _, _, err := client.ListSongs(ctx, SongQuery{Offset: -1}); err == nil {
+		t.Error("listed songs from a negative offset")
+	}
+}
+
+func testSearchSongs(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	registerCatalog(t, client)
+
+	tests := []struct {
+		text  string
+		mode  SearchMode
+		query SongQuery
+		want  []string
+		total int
+	}{
+		{"yellow", SearchSubstring, SongQuery{}, []string{"Submarine", "yellow", "Yellow Submarine"}, 3},
+		{"ELLOW sub", SearchSubstring, SongQuery{}, []string{"Yellow Submarine"}, 1},
+		{"yellow", SearchSubstring, SongQuery{Offset: 1, Limit: 1}, []string{"yellow"}, 3},
+		{"", SearchSubstring, SongQuery{Limit: 1}, []string{"Let It Be"}, 5},
+		{"beatles YELLOW", SearchFullText, SongQuery{}, []string{"Yellow Submarine"}, 1},
+		{"submarine", SearchFullText, SongQuery{SortBy: SortByArtist}, []string{"Yellow Submarine", "Submarine"}, 2},
+		{"sub", SearchFullText, SongQuery{}, []string{}, 0},
+		{"the, be!", SearchFullText, SongQuery{}, []string{"Let It Be"}, 1},
+		{"", SearchFullText, SongQuery{}, []string{"Let It Be", "Paranoid Android", "Submarine", "yellow", "Yellow Submarine"}, 5},
+	}
+	for _, tc := range tests {
+		songs, total, err := client.SearchSongs(ctx, tc.text, tc.mode, tc.query)
+		if err != nil {
+			t.Fatalf("%s %q: %v", tc.mode, tc.text, err)
+		}
+		if got := songTitles(songs); !reflect.DeepEqual(got, tc.want) || total != tc.total {
+			t.Errorf("%s %q %+v: got %q of %d, want %q of %d", tc.mode, tc.text, tc.query, got, total, tc.want, tc.total)
+		}
+	}
+
+	// Case is folded beyond ASCII, but diacritics are kept.
+	mustRegister(t, client, Song{Title: "Ça Plane Pour Moi", Artist: "Plastic Bertrand"})
+	mustRegister(t, client, Song{Title: "Hoppípolla", Artist: "Sigur Rós"})
+	// Titles that differ in case alone tie, and fall back on the artist.
+	mustRegister(t, client, Song{Title: "Élan", Artist: "Zed"})
+	mustRegister(t, client, Song{Title: "élan", Artist: "Abba"})
+	tests = []struct {
+		text  string
+		mode  SearchMode
+		query SongQuery
+		want  []string
+		total int
+	}{
+		{"ÇA PLANE", SearchSubstring, SongQuery{}, []string{"Ça Plane Pour Moi"}, 1},
+		{"ca plane", SearchSubstring, SongQuery{}, []string{}, 0},
+		{"RÓS", SearchSubstring, SongQuery{}, []string{"Hoppípolla"}, 1},
+		{"moi ça", SearchFullText, SongQuery{}, []string{"Ça Plane Pour Moi"}, 1},
+		{"ca", SearchFullText, SongQuery{}, []string{}, 0},
+		{"HOPPÍPOLLA sigur RÓS", SearchFullText, SongQuery{}, []string{"Hoppípolla"}, 1},
+		{"ÉLAN", SearchSubstring, SongQuery{}, []string{"élan", "Élan"}, 2},
+		{"élan", SearchFullText, SongQuery{Descending: true}, []string{"Élan", "élan"}, 2},
+	}
+	for _, tc := range tests {
+		songs, total, err := client.SearchSongs(ctx, tc.text, tc.mode, tc.query)
+		if err != nil {
+			t.Fatalf("%s %q: %v", tc.mode, tc.text, err)
+		}
+		if got := songTitles(songs); !reflect.DeepEqual(got, tc.want) || total != tc.total {
+			t.Errorf("%s %q %+v: got %q of %d, want %q of %d", tc.mode, tc.text, tc.query, got, total, tc.want, tc.total)
+		}
+	}
+
+	// Deleted songs are no longer found.
+	songs, _, _ := client.SearchSongs(ctx, "yellow submarine beatles", SearchFullText, SongQuery{})
+	if len(songs) != 1 {
+		t.Fatalf("found %q, want one song", songTitles(songs))
+	}
+	if err := client.DeleteSongByID(ctx, songs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if songs, total, err := client.SearchSongs(ctx, "submarine", SearchFullText, SongQuery{}); err != nil || total != 1 || songs[0].Title != "Submarine" {
+		t.Errorf("after deleting: got %q, %v", songTitles(songs), err)
+	}
+
+	if _, _, err := client.SearchSongs(ctx, "yellow", "regex", SongQuery{}); err == nil {
+		t.Error("searched in an invalid mode")
+	}
+}
+
+func testMeta(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	if value, found, err := client.GetMeta(ctx, "missing"); err != nil || found || value != "" {
+		t.Errorf("GetMeta(missing) = %q, %v, %v", value, found, err)
+	}
+
+	for _, value := range []string{"first", "second"} {
+		if err := client.SetMeta(ctx, "key", value); err != nil {
+			t.Fatal(err)
+		}
+		if got, found, err := client.GetMeta(ctx, "key"); err != nil || !found || got != value {
+			t.Errorf("GetMeta = %q, %v, %v, want %q", got, found, err, value)
+		}
+	}
+
+	if err := client.DeleteCollection(ctx, "unknown"); err != nil {
+		t.Errorf("deleting an unknown collection: %v", err)
+	}
+}
+
+func testPlays(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	songID := mustRegister(t, client, Song{Title: "A", Artist: "Artist"})
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, play := range []Play{
+		{Source: "radio", Kind: "song", SongID: songID, StartedAt: start, EndedAt: start.Add(3 * time.Minute)},
+		{Source: "radio", Kind: "unknown", StartedAt: start.Add(3 * time.Minute), EndedAt: start.Add(4 * time.Minute)},
+		{Source: "radio", Kind: "gap", StartedAt: start.Add(4 * time.Minute), EndedAt: start.Add(5 * time.Minute)},
+	} {
+		if err := client.StorePlay(ctx, play); err != nil {
+			t.Errorf("StorePlay(%s): %v", play.Kind, err)
+		}
+	}
+}
+
+func testSchema(t *testing.T, client DBClient) {
+	ctx := context.Background()
+	statuses, err := client.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSchema(statuses); err != nil {
+		t.Error(err)
+	}
+	if migrated, err := client.Migrate(ctx); err != nil || len(migrated) != 0 {
+		t.Errorf("Migrate on a current schema = %v, %v", migrated, err)
+	}
+}
+
+// Every method but Close gives up once its context is done, without changing
+// the database.
+func testCanceledContext(t *testing.T, client DBClient) {
+	songID := mustRegister(t, client, Song{Title: "Title", Artist: "Artist", YouTubeID: "yt"})
+	mustStore(t, client, models.Fingerprint{Address: 1, AnchorTimeMs: 10, SongID: songID})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := map[string]func() error{
+		"StoreFingerprints": func() error {
+			return client.StoreFingerprints(ctx, []models.Fingerprint{{Address: 2, AnchorTimeMs: 20, SongID: songID}})
+		},
+		"GetCouples":    func() error { _, err := client.GetCouples(ctx, []uint32{1}); return err },
+		"TotalSongs":    func() error { _, err := client.TotalSongs(ctx); return err },
+		"RegisterSong":  func() error { _, err := client.RegisterSong(ctx, Song{Title: "Other", Artist: "Artist"}); return err },
+		"GetSong":       func() error { _, _, err := client.GetSong(ctx, "key", "Title---Artist"); return err },
+		"GetSongByID":   func() error { _, _, err := client.GetSongByID(ctx, songID); return err },
+		"GetSongByYTID": func() error { _, _, err := client.GetSongByYTID(ctx, "yt"); return err },
+		"GetSongByKey":  func() error { _, _, err := client.GetSongByKey(ctx, "Title---Artist"); return err },
+		"SongIDs":       func() error { _, err := client.SongIDs(ctx); return err },
+		"ListSongs":     func() error { _, _, err := client.ListSongs(ctx, SongQuery{}); return err },
+		"SearchSongs": func() error {
+			_, _, err := client.SearchSongs(ctx, "title", SearchFullText, SongQuery{})
+			return err
+		},
+		"GetSongFingerprints":    func() error { _, err := client.GetSongFingerprints(ctx, songID); return err },
+		"FingerprintCounts":      func() error { _, err := client.FingerprintCounts(ctx); return err },
+		"DeleteSongByID":         func() error { return client.DeleteSongByID(ctx, songID) },
+		"DeleteSongFingerprints": func() error { return client.DeleteSongFingerprints(ctx, songID) },
+		"DeleteCollection":       func() error { return client.DeleteCollection(ctx, "songs") },
+		"GetMeta":                func() error { _, _, err := client.GetMeta(ctx, "k"); return err },
+		"SetMeta":                func() error { return client.SetMeta(ctx, "k", "v") },
+		"StorePlay": func() error {
+			return client.StorePlay(ctx, Play{Source: "radio", Kind: "gap", StartedAt: time.Now(), EndedAt: time.Now()})
+		},
+		"MigrationStatus": func() error { _, err := client.MigrationStatus(ctx); return err },
+		"Migrate":         func() error { _, err := client.Migrate(ctx); return err },
+	}
+	for name, call := range calls {
+		if err := call(); err == nil {
+			t.Errorf("%s succeeded with a canceled context", name)
+		}
+	}
+
+	song, found, err := client.GetSongByID(context.Background(), songID)
+	if err != nil || !found || song.FingerprintCount != 1 {
+		t.Errorf("got %+v, %v, %v after canceled calls, want the song with 1 fingerprint", song, found, err)
+	}
+	if total, err := client.TotalSongs(context.Background()); err != nil || total != 1 {
+		t.Errorf("got %d songs (%v) after canceled calls, want 1", total, err)
+	}
+	if _, found, err := client.GetMeta(context.Background(), "k"); err != nil || found {
+		t.Errorf("meta set by a canceled call (%v)", err)
+	}
+}
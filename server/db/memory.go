@@ -0,0 +1,401 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"song-recognition/models"
+	"song-recognition/utils"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryClient keeps the database in memory, for tests and demos. Its
+// contents are lost when the program exits. It is safe for concurrent use.
+// No call blocks, but like the other backends every call fails once its
+// context is done.
+type MemoryClient struct {
+	mu sync.RWMutex
+
+	songs   map[uint32]Song
+	songKey map[string]uint32 // song key -> song ID
+	// couples holds the fingerprints by address and songFingerprints by song;
+	// both are sets.
+	couples          map[uint32]map[models.Couple]bool
+	songFingerprints map[uint32]map[models.Fingerprint]bool
+	meta             map[string]string
+	plays            []Play
+}
+
+// NewMemoryClient returns an empty in-memory database.
+func NewMemoryClient() *MemoryClient {
+	return &MemoryClient{
+		songs:            make(map[uint32]Song),
+		songKey:          make(map[string]uint32),
+		couples:          make(map[uint32]map[models.Couple]bool),
+		songFingerprints: make(map[uint32]map[models.Fingerprint]bool),
+		meta:             make(map[string]string),
+	}
+}
+
+func (db *MemoryClient) Close() error {
+	return nil
+}
+
+// StoreFingerprints stores fingerprints and updates the fingerprint count of
+// their songs. Storing a fingerprint twice keeps one copy.
+func (db *MemoryClient) StoreFingerprints(ctx context.Context, fingerprints []models.Fingerprint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	songIDs := make(map[uint32]bool)
+	for _, fingerprint := range fingerprints {
+		songIDs[fingerprint.SongID] = true
+		if db.couples[fingerprint.Address] == nil {
+			db.couples[fingerprint.Address] = make(map[models.Couple]bool)
+		}
+		db.couples[fingerprint.Address][models.Couple{AnchorTimeMs: fingerprint.AnchorTimeMs, SongID: fingerprint.SongID}] = true
+
+		if db.songFingerprints[fingerprint.SongID] == nil {
+			db.songFingerprints[fingerprint.SongID] = make(map[models.Fingerprint]bool)
+		}
+		db.songFingerprints[fingerprint.SongID][fingerprint] = true
+	}
+
+	for songID := range songIDs {
+		if song, ok := db.songs[songID]; ok {
+			song.FingerprintCount = len(db.songFingerprints[songID])
+			db.songs[songID] = song
+		}
+	}
+	return nil
+}
+
+func (db *MemoryClient) GetCouples(ctx context.Context, addresses []uint32) (map[uint32][]models.Couple, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	couples := make(map[uint32][]models.Couple, len(addresses))
+	for _, address := range addresses {
+		var docCouples []models.Couple
+		for couple := range db.couples[address] {
+			docCouples = append(docCouples, couple)
+		}
+		couples[address] = docCouples
+	}
+	return couples, nil
+}
+
+func (db *MemoryClient) TotalSongs(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return len(db.songs), nil
+}
+
+// RegisterSong stores a song's metadata under a new ID, which it returns.
+func (db *MemoryClient) RegisterSong(ctx context.Context, song Song) (uint32, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key := utils.GenerateSongKey(song.Title, song.Artist)
+	if _, exists := db.songKey[key]; exists {
+		return 0, errors.New("song with ytID or key already exists")
+	}
+
+	song.ID = utils.GenerateUniqueID()
+	song.Artists = append([]string(nil), song.Artists...)
+	song.DateAdded = time.Now().UTC()
+	song.FingerprintCount = 0
+	db.songs[song.ID] = song
+	db.songKey[key] = song.ID
+
+	return song.ID, nil
+}
+
+// GetSong retrieves a song by "id", "ytID" or "key".
+func (db *MemoryClient) GetSong(ctx context.Context, filterKey string, value interface{}) (Song, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return Song{}, false, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var song Song
+	var found bool
+	switch filterKey {
+	case "id":
+		songID, err := strconv.ParseUint(fmt.Sprint(value), 10, 32)
+		if err != nil {
+			return Song{}, false, fmt.Errorf("invalid song ID %v", value)
+		}
+		song, found = db.songs[uint32(songID)]
+	case "ytID":
+		for _, s := range db.songs {
+			if s.YouTubeID == fmt.Sprint(value) {
+				song, found = s, true
+				break
+			}
+		}
+	case "key":
+		song, found = db.songs[db.songKey[fmt.Sprint(value)]]
+	default:
+		return Song{}, false, fmt.Errorf("invalid filter key")
+	}
+
+	if !found {
+		return Song{}, false, nil
+	}
+	song.Artists = append([]string(nil), song.Artists...)
+	return song, true, nil
+}
+
+func (db *MemoryClient) GetSongByID(ctx context.Context, songID uint32) (Song, bool, error) {
+	return db.GetSong(ctx, "id", songID)
+}
+
+func (db *MemoryClient) GetSongByYTID(ctx context.Context, ytID string) (Song, bool, error) {
+	return db.GetSong(ctx, "ytID", ytID)
+}
+
+func (db *MemoryClient) GetSongByKey(ctx context.Context, key string) (Song, bool, error) {
+	return db.GetSong(ctx, "key", key)
+}
+
+// SongIDs returns the IDs of all registered songs.
+func (db *MemoryClient) SongIDs(ctx context.Context) ([]uint32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	songIDs := make([]uint32, 0, len(db.songs))
+	for songID := range db.songs {
+		songIDs = append(songIDs, songID)
+	}
+	sort.Slice(songIDs, func(i, j int) bool { return songIDs[i] < songIDs[j] })
+	return songIDs, nil
+}
+
+// ListSongs returns a page of the songs and the number of songs.
+func (db *MemoryClient) ListSongs(ctx context.Context, query SongQuery) ([]Song, int, error) {
+	return db.findSongs(ctx, query, func(Song) bool { return true })
+}
+
+// SearchSongs returns a page of the songs matching text and the number of
+// songs matching it.
+func (db *MemoryClient) SearchSongs(ctx context.Context, text string, mode SearchMode, query SongQuery) ([]Song, int, error) {
+	if err := checkSearchMode(mode); err != nil {
+		return nil, 0, err
+	}
+	return db.findSongs(ctx, query, func(song Song) bool { return matchesSearch(song, text, mode) })
+}
+
+func (db *MemoryClient) findSongs(ctx context.Context, query SongQuery, match func(Song) bool) ([]Song, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if err := query.check(); err != nil {
+		return nil, 0, err
+	}
+
+	db.mu.RLock()
+	var songs []Song
+	for _, song := range db.songs {
+		if match(song) {
+			song.Artists = append([]string(nil), song.Artists...)
+			songs = append(songs, song)
+		}
+	}
+	db.mu.RUnlock()
+
+	sortSongs(songs, query)
+	return query.page(songs), len(songs), nil
+}
+
+// GetSongFingerprints returns every fingerprint stored for a song.
+func (db *MemoryClient) GetSongFingerprints(ctx context.Context, songID uint32) ([]models.Fingerprint, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var fingerprints []models.Fingerprint
+	for fingerprint := range db.songFingerprints[songID] {
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	return fingerprints, nil
+}
+
+// FingerprintCounts counts the stored fingerprints of every song ID in the
+// index, whether or not the song is registered.
+func (db *MemoryClient) FingerprintCounts(ctx context.Context) (map[uint32]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	counts := make(map[uint32]int, len(db.songFingerprints))
+	for songID, fingerprints := range db.songFingerprints {
+		counts[songID] = len(fingerprints)
+	}
+	return counts, nil
+}
+
+// DeleteSongByID deletes a song and its fingerprints.
+func (db *MemoryClient) DeleteSongByID(ctx context.Context, songID uint32) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.deleteFingerprints(songID)
+	if song, ok := db.songs[songID]; ok {
+		delete(db.songKey, utils.GenerateSongKey(song.Title, song.Artist))
+		delete(db.songs, songID)
+	}
+	return nil
+}
+
+// DeleteSongFingerprints deletes every fingerprint of a song.
+func (db *MemoryClient) DeleteSongFingerprints(ctx context.Context, songID uint32) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.deleteFingerprints(songID)
+	if song, ok := db.songs[songID]; ok {
+		song.FingerprintCount = 0
+		db.songs[songID] = song
+	}
+	return nil
+}
+
+// deleteFingerprints removes a song's fingerprints; db.mu must be held.
+func (db *MemoryClient) deleteFingerprints(songID uint32) {
+	for fingerprint := range db.songFingerprints[songID] {
+		couples := db.couples[fingerprint.Address]
+		delete(couples, models.Couple{AnchorTimeMs: fingerprint.AnchorTimeMs, SongID: songID})
+		if len(couples) == 0 {
+			delete(db.couples, fingerprint.Address)
+		}
+	}
+	delete(db.songFingerprints, songID)
+}
+
+// DeleteCollection empties a collection; unknown collections are ignored.
+func (db *MemoryClient) DeleteCollection(ctx context.Context, collectionName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	switch collectionName {
+	case "songs":
+		db.songs = make(map[uint32]Song)
+		db.songKey = make(map[string]uint32)
+	case "fingerprints":
+		db.couples = make(map[uint32]map[models.Couple]bool)
+		db.songFingerprints = make(map[uint32]map[models.Fingerprint]bool)
+		for songID, song := range db.songs {
+			song.FingerprintCount = 0
+			db.songs[songID] = song
+		}
+	case "meta":
+		db.meta = make(map[string]string)
+	case "plays":
+		db.plays = nil
+	}
+	return nil
+}
+
+// GetMeta retrieves an index-wide setting such as the fingerprint config.
+func (db *MemoryClient) GetMeta(ctx context.Context, key string) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	value, ok := db.meta[key]
+	return value, ok, nil
+}
+
+// SetMeta stores an index-wide setting, replacing any previous value.
+func (db *MemoryClient) SetMeta(ctx context.Context, key, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.meta[key] = value
+	return nil
+}
+
+// StorePlay appends a play to the play log of monitored sources.
+func (db *MemoryClient) StorePlay(ctx context.Context, play Play) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.plays = append(db.plays, play)
+	return nil
+}
+
+// MigrationStatus returns no migrations: an in-memory database is created
+// with the current schema.
+func (db *MemoryClient) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Migrate has nothing to apply.
+func (db *MemoryClient) Migrate(ctx context.Context) ([]Migration, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Migration is one step in the evolution of a backend's schema. Migrations
+// are applied in order of version, and each is applied once.
+type Migration struct {
+	Version     int
+	Description string
+}
+
+// MigrationStatus tells whether a migration has been applied to a database.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// Unknown is set for migrations recorded in the database that this build
+	// does not know, when the database was migrated by a newer build.
+	Unknown bool
+}
+
+// appliedMigration is a migration recorded in a database.
+type appliedMigration struct {
+	Description string
+	AppliedAt   time.Time
+}
+
+// migrationStatus merges the migrations a backend knows with those recorded
+// in its database.
+func migrationStatus(known []Migration, applied map[int]appliedMigration) []MigrationStatus {
+	var statuses []MigrationStatus
+	for _, migration := range known {
+		status := MigrationStatus{Migration: migration}
+		if record, ok := applied[migration.Version]; ok {
+			status.Applied, status.AppliedAt = true, record.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	for version, record := range applied {
+		if version > known[len(known)-1].Version {
+			statuses = append(statuses, MigrationStatus{
+				Migration: Migration{Version: version, Description: record.Description},
+				Applied:   true,
+				AppliedAt: record.AppliedAt,
+				Unknown:   true,
+			})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses
+}
+
+// SchemaVersion returns the version of the last applied migration, or 0.
+func SchemaVersion(statuses []MigrationStatus) int {
+	version := 0
+	for _, status := range statuses {
+		if status.Applied {
+			version = status.Version
+		}
+	}
+	return version
+}
+
+// checkNotNewer fails when the database was migrated by a newer build, whose
+// schema this build may not be able to use.
+func checkNotNewer(statuses []MigrationStatus) error {
+	for _, status := range statuses {
+		if status.Unknown {
+			return fmt.Errorf("database schema version %d is newer than this build supports", status.Version)
+		}
+	}
+	return nil
+}
+
+// checkSchema fails when the database needs migrations, or was migrated by a
+// newer build.
+func checkSchema(statuses []MigrationStatus) error {
+	if err := checkNotNewer(statuses); err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			return fmt.Errorf("database schema is out of date (migration %d is pending); run 'migrate up'", status.Version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSQLiteMigrate(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db.sqlite3")
+
+	client, err := openSQLiteClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := client.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != len(sqliteMigrations) || SchemaVersion(statuses) != 0 {
+		t.Fatalf("fresh database: got %+v", statuses)
+	}
+	if err := checkSchema(statuses); err == nil {
+		t.Error("fresh database passed the schema check")
+	}
+
+	migrated, err := client.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrated) != len(sqliteMigrations) {
+		t.Errorf("applied %d migrations, want %d", len(migrated), len(sqliteMigrations))
+	}
+	if _, err := client.RegisterSong(ctx, Song{Title: "Title", Artist: "Artist", YouTubeID: "yt"}); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	// Reopening applies nothing and keeps the data.
+	client, err = NewSQLiteClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if migrated, err := client.Migrate(ctx); err != nil || len(migrated) != 0 {
+		t.Errorf("second migration applied %v, %v", migrated, err)
+	}
+	statuses, err = client.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSchema(statuses); err != nil {
+		t.Error(err)
+	}
+	if total, err := client.TotalSongs(ctx); err != nil || total != 1 {
+		t.Errorf("got %d songs (%v), want 1", total, err)
+	}
+}
+
+// A database created before migrations were recorded is adopted as is.
+func TestSQLiteMigrateUnversionedDatabase(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db.sqlite3")
+
+	legacy, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		"CREATE TABLE songs (id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT NOT NULL, artist TEXT NOT NULL, ytID TEXT, key TEXT NOT NULL UNIQUE)",
+		"CREATE TABLE fingerprints (address INTEGER NOT NULL, anchorTimeMs INTEGER NOT NULL, songID INTEGER NOT NULL, PRIMARY KEY (address, anchorTimeMs, songID))",
+		"INSERT INTO songs (id, title, artist, ytID, key) VALUES (7, 'Title', 'Artist', 'yt', 'Title---Artist')",
+	} {
+		if _, err := legacy.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	legacy.Close()
+
+	client, err := NewSQLiteClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	song, found, err := client.GetSongByID(ctx, 7)
+	if err != nil || !found || song.Title != "Title" {
+		t.Errorf("got %+v, %v, %v", song, found, err)
+	}
+	if err := client.SetMeta(ctx, "k", "v"); err != nil {
+		t.Errorf("meta table missing: %v", err)
+	}
+}
+
+func TestSQLiteMigrateNewerDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.sqlite3")
+
+	client, err := NewSQLiteClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := sqliteMigrations[len(sqliteMigrations)-1].Version + 1
+	_, err = client.db.Exec("INSERT INTO schema_migrations (version, description, appliedAt) VALUES (?, ?, ?)",
+		next, "From the future", time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	if _, err := NewSQLiteClient(path); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("got %v, want an error about a newer schema", err)
+	}
+}
+
+// Databases from before migration 5 may hold songs sharing a key under
+// different YouTube IDs; the migration names them instead of failing on the
+// index.
+func TestMongoMigrateDuplicateKeys(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	ctx := context.Background()
+	client := newMongoTestClient(t, uri)
+	defer client.Close()
+
+	songs := client.client.Database(client.dbName).Collection("songs")
+	for i, ytID := range []string{"yt1", "yt2"} {
+		if _, err := songs.InsertOne(ctx, bson.M{"_id": int64(i + 1), "key": "Title---Artist", "ytID": ytID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrated, err := client.Migrate(ctx)
+	if err == nil || !strings.Contains(err.Error(), `"Title---Artist"`) || !strings.Contains(err.Error(), "fsck -prune") {
+		t.Fatalf("got %v, want an error naming the shared key", err)
+	}
+	if len(migrated) != 4 {
+		t.Errorf("applied %d migrations before the failure, want 4", len(migrated))
+	}
+
+	if _, err := songs.DeleteOne(ctx, bson.M{"_id": int64(2)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := songs.InsertOne(ctx, bson.M{"_id": int64(3), "key": "Title---Artist", "ytID": "yt3"}); err == nil {
+		t.Error("inserted a song with a duplicate key")
+	}
+}
+
+// Songs from before migration 7 get the sort keys that $toLower cannot
+// compute for non-ASCII titles.
+func TestMongoMigrateSortKeys(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	ctx := context.Background()
+	client := newMongoTestClient(t, uri)
+	defer client.Close()
+
+	songs := client.client.Database(client.dbName).Collection("songs")
+	if _, err := songs.InsertOne(ctx, bson.M{"_id": int64(1), "key": "Élan---Zed", "ytID": "yt", "title": "Élan", "artist": "Zed"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		SortTitle  string `bson:"sortTitle"`
+		SortArtist string `bson:"sortArtist"`
+	}
+	if err := songs.FindOne(ctx, bson.M{"_id": int64(1)}).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.SortTitle != "élan" || doc.SortArtist != "zed" {
+		t.Errorf("got sort keys %q and %q, want \"élan\" and \"zed\"", doc.SortTitle, doc.SortArtist)
+	}
+}
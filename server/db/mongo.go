@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"song-recognition/models"
 	"song-recognition/utils"
 	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,15 +18,32 @@ import (
 
 type MongoClient struct {
 	client *mongo.Client
+	dbName string
 }
 
+// NewMongoClient connects to MongoDB and applies any pending migrations.
 func NewMongoClient(uri string) (*MongoClient, error) {
+	client, err := openMongoClient(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := client.Migrate(context.Background()); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("error migrating database: %v", err)
+	}
+
+	return client, nil
+}
+
+// openMongoClient connects to MongoDB without touching the schema.
+func openMongoClient(uri string) (*MongoClient, error) {
 	clientOptions := options.Client().ApplyURI(uri)
 	client, err := mongo.Connect(context.Background(), clientOptions)
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to MongoDB: %s", err)
 	}
-	return &MongoClient{client: client}, nil
+	return &MongoClient{client: client, dbName: "song-recognition"}, nil
 }
 
 func (db *MongoClient) Close() error {
@@ -34,39 +53,101 @@ func (db *MongoClient) Close() error {
 	return nil
 }
 
-func (db *MongoClient) StoreFingerprints(fingerprints map[uint32]models.Couple) error {
-	collection := db.client.Database("song-recognition").Collection("fingerprints")
+// StoreFingerprints stores fingerprints and updates the fingerprint count of
+// their songs. Storing a fingerprint twice keeps one copy.
+func (db *MongoClient) StoreFingerprints(ctx context.Context, fingerprints []models.Fingerprint) error {
+	collection := db.client.Database(db.dbName).Collection("fingerprints")
+
+	// Group occurrences by address so each address document is updated once.
+	// Couples are bson.D so that equal couples encode equally for $addToSet.
+	couplesByAddress := make(map[uint32]bson.A)
+	var addresses []uint32
+	songIDs := make(map[uint32]bool)
+	for _, fingerprint := range fingerprints {
+		songIDs[fingerprint.SongID] = true
+		if _, ok := couplesByAddress[fingerprint.Address]; !ok {
+			addresses = append(addresses, fingerprint.Address)
+		}
+		couplesByAddress[fingerprint.Address] = append(couplesByAddress[fingerprint.Address], bson.D{
+			{Key: "anchorTimeMs", Value: fingerprint.AnchorTimeMs},
+			{Key: "songID", Value: fingerprint.SongID},
+		})
+	}
 
-	for address, couple := range fingerprints {
-		filter := bson.M{"_id": address}
+	var writes []mongo.WriteModel
+	for _, address := range addresses {
 		update := bson.M{
-			"$push": bson.M{
-				"couples": bson.M{
-					"anchorTimeMs": couple.AnchorTimeMs,
-					"songID":       couple.SongID,
-				},
+			"$addToSet": bson.M{
+				"couples": bson.M{"$each": couplesByAddress[address]},
 			},
 		}
-		opts := options.Update().SetUpsert(true)
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": address}).
+			SetUpdate(update).
+			SetUpsert(true))
+	}
+
+	if len(writes) == 0 {
+		return nil
+	}
 
-		_, err := collection.UpdateOne(context.Background(), filter, update, opts)
+	opts := options.BulkWrite().SetOrdered(false)
+	_, err := collection.BulkWrite(ctx, writes, opts)
+	if err != nil {
+		return fmt.Errorf("error upserting document: %s", err)
+	}
+
+	songsCollection := db.client.Database(db.dbName).Collection("songs")
+	for songID := range songIDs {
+		count, err := db.countSongFingerprints(ctx, songID)
 		if err != nil {
-			return fmt.Errorf("error upserting document: %s", err)
+			return err
+		}
+		_, err = songsCollection.UpdateOne(ctx,
+			bson.M{"_id": songID}, bson.M{"$set": bson.M{"fingerprintCount": count}})
+		if err != nil {
+			return fmt.Errorf("error updating fingerprint count: %v", err)
 		}
 	}
 
 	return nil
 }
 
-func (db *MongoClient) GetCouples(addresses []uint32) (map[uint32][]models.Couple, error) {
-	collection := db.client.Database("song-recognition").Collection("fingerprints")
+// countSongFingerprints counts the couples of a song across all addresses.
+func (db *MongoClient) countSongFingerprints(ctx context.Context, songID uint32) (int, error) {
+	collection := db.client.Database(db.dbName).Collection("fingerprints")
+
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"couples.songID": songID}}},
+		{{Key: "$unwind", Value: "$couples"}},
+		{{Key: "$match", Value: bson.M{"couples.songID": songID}}},
+		{{Key: "$count", Value: "count"}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error counting fingerprints: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Count int `bson:"count"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, fmt.Errorf("error counting fingerprints: %v", err)
+		}
+	}
+	return result.Count, cursor.Err()
+}
+
+func (db *MongoClient) GetCouples(ctx context.Context, addresses []uint32) (map[uint32][]models.Couple, error) {
+	collection := db.client.Database(db.dbName).Collection("fingerprints")
 
 	couples := make(map[uint32][]models.Couple)
 
 	for _, address := range addresses {
 		// Find the document corresponding to the address
 		var result bson.M
-		err := collection.FindOne(context.Background(), bson.M{"_id": address}).Decode(&result)
+		err := collection.FindOne(ctx, bson.M{"_id": address}).Decode(&result)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
 				continue
@@ -99,9 +180,9 @@ func (db *MongoClient) GetCouples(addresses []uint32) (map[uint32][]models.Coupl
 	return couples, nil
 }
 
-func (db *MongoClient) TotalSongs() (int, error) {
-	existingSongsCollection := db.client.Database("song-recognition").Collection("songs")
-	total, err := existingSongsCollection.CountDocuments(context.Background(), bson.D{})
+func (db *MongoClient) TotalSongs(ctx context.Context) (int, error) {
+	existingSongsCollection := db.client.Database(db.dbName).Collection("songs")
+	total, err := existingSongsCollection.CountDocuments(ctx, bson.D{})
 	if err != nil {
 		return 0, err
 	}
@@ -109,23 +190,31 @@ func (db *MongoClient) TotalSongs() (int, error) {
 	return int(total), nil
 }
 
-func (db *MongoClient) RegisterSong(songTitle, songArtist, ytID string) (uint32, error) {
-	existingSongsCollection := db.client.Database("song-recognition").Collection("songs")
-
-	// Create a compound unique index on ytID and key, if it doesn't already exist
-	indexModel := mongo.IndexModel{
-		Keys:    bson.D{{"ytID", 1}, {"key", 1}},
-		Options: options.Index().SetUnique(true),
-	}
-	_, err := existingSongsCollection.Indexes().CreateOne(context.Background(), indexModel)
-	if err != nil {
-		return 0, fmt.Errorf("failed to create unique index: %v", err)
-	}
+// RegisterSong stores a song's metadata under a new ID, which it returns.
+func (db *MongoClient) RegisterSong(ctx context.Context, song Song) (uint32, error) {
+	existingSongsCollection := db.client.Database(db.dbName).Collection("songs")
 
-	// Attempt to insert the song with ytID and key
+	// Attempt to insert the song with ytID and key; the unique index on both
+	// is created by the first migration.
 	songID := utils.GenerateUniqueID()
-	key := utils.GenerateSongKey(songTitle, songArtist)
-	_, err = existingSongsCollection.InsertOne(context.Background(), bson.M{"_id": songID, "key": key, "ytID": ytID})
+	key := utils.GenerateSongKey(song.Title, song.Artist)
+	_, err := existingSongsCollection.InsertOne(ctx, bson.M{
+		"_id":              songID,
+		"key":              key,
+		"ytID":             song.YouTubeID,
+		"title":            song.Title,
+		"artist":           song.Artist,
+		"album":            song.Album,
+		"artists":          song.Artists,
+		"duration":         song.Duration,
+		"spotifyID":        song.SpotifyID,
+		"isrc":             song.ISRC,
+		"filePath":         song.FilePath,
+		"dateAdded":        time.Now().UTC(),
+		"fingerprintCount": 0,
+		"sortTitle":        sortKey(song.Title),
+		"sortArtist":       sortKey(song.Artist),
+	})
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
 			return 0, fmt.Errorf("song with ytID or key already exists: %v", err)
@@ -137,19 +226,39 @@ func (db *MongoClient) RegisterSong(songTitle, songArtist, ytID string) (uint32,
 	return songID, nil
 }
 
-var mongofilterKeys = "_id | ytID | key"
+// mongoFilterKeys maps the filter keys of GetSong to song fields.
+var mongoFilterKeys = map[string]string{"id": "_id", "ytID": "ytID", "key": "key"}
+
+// mongoSong is the document of a song.
+type mongoSong struct {
+	ID               int64     `bson:"_id"`
+	Key              string    `bson:"key"`
+	YouTubeID        string    `bson:"ytID"`
+	Title            string    `bson:"title"`
+	Artist           string    `bson:"artist"`
+	Album            string    `bson:"album"`
+	Artists          []string  `bson:"artists"`
+	Duration         int       `bson:"duration"`
+	SpotifyID        string    `bson:"spotifyID"`
+	ISRC             string    `bson:"isrc"`
+	FilePath         string    `bson:"filePath"`
+	DateAdded        time.Time `bson:"dateAdded"`
+	FingerprintCount int       `bson:"fingerprintCount"`
+}
 
-func (db *MongoClient) GetSong(filterKey string, value interface{}) (s Song, songExists bool, e error) {
-	if !strings.Contains(mongofilterKeys, filterKey) {
+// GetSong retrieves a song by "id", "ytID" or "key".
+func (db *MongoClient) GetSong(ctx context.Context, filterKey string, value interface{}) (s Song, songExists bool, e error) {
+	field, ok := mongoFilterKeys[filterKey]
+	if !ok {
 		return Song{}, false, errors.New("invalid filter key")
 	}
 
-	songsCollection := db.client.Database("song-recognition").Collection("songs")
-	var song bson.M
+	songsCollection := db.client.Database(db.dbName).Collection("songs")
+	var song mongoSong
 
-	filter := bson.M{filterKey: value}
+	filter := bson.M{field: value}
 
-	err := songsCollection.FindOne(context.Background(), filter).Decode(&song)
+	err := songsCollection.FindOne(ctx, filter).Decode(&song)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return Song{}, false, nil
@@ -157,33 +266,266 @@ func (db *MongoClient) GetSong(filterKey string, value interface{}) (s Song, son
 		return Song{}, false, fmt.Errorf("failed to retrieve song: %v", err)
 	}
 
-	ytID := song["ytID"].(string)
-	title := strings.Split(song["key"].(string), "---")[0]
-	artist := strings.Split(song["key"].(string), "---")[1]
+	return song.song(), true, nil
+}
+
+func (song mongoSong) song() Song {
+	// Songs registered before titles were stored only have their key.
+	if song.Title == "" {
+		song.Title, song.Artist, _ = strings.Cut(song.Key, "---")
+	}
+
+	return Song{
+		ID:               uint32(song.ID),
+		Title:            song.Title,
+		Artist:           song.Artist,
+		YouTubeID:        song.YouTubeID,
+		Album:            song.Album,
+		Artists:          song.Artists,
+		Duration:         song.Duration,
+		SpotifyID:        song.SpotifyID,
+		ISRC:             song.ISRC,
+		FilePath:         song.FilePath,
+		DateAdded:        song.DateAdded,
+		FingerprintCount: song.FingerprintCount,
+	}
+}
 
-	songInstance := Song{title, artist, ytID}
+// ListSongs returns a page of the songs and the number of songs.
+func (db *MongoClient) ListSongs(ctx context.Context, query SongQuery) ([]Song, int, error) {
+	if err := query.check(); err != nil {
+		return nil, 0, err
+	}
+	return db.findSongs(ctx, query, bson.M{})
+}
 
-	return songInstance, true, nil
+// SearchSongs returns a page of the songs matching text and the number of
+// songs matching it. Full-text searches use the text index on titles and
+// artists.
+func (db *MongoClient) SearchSongs(ctx context.Context, text string, mode SearchMode, query SongQuery) ([]Song, int, error) {
+	if err := checkSearchMode(mode); err != nil {
+		return nil, 0, err
+	}
+	if err := query.check(); err != nil {
+		return nil, 0, err
+	}
+
+	filter := bson.M{}
+	if mode == SearchSubstring {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(text), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"title": pattern}, bson.M{"artist": pattern}}
+	} else if words := searchWords(text); len(words) > 0 {
+		// The text index finds the songs with any of the words; every word
+		// must then be in the title or the artist.
+		filter["$text"] = bson.M{"$search": strings.Join(words, " ")}
+		var all bson.A
+		for _, word := range words {
+			// \b only knows ASCII letters, so words are bounded by hand.
+			pattern := primitive.Regex{Pattern: `(^|[^\p{L}\p{N}])` + regexp.QuoteMeta(word) + `([^\p{L}\p{N}]|$)`, Options: "i"}
+			all = append(all, bson.M{"$or": bson.A{bson.M{"title": pattern}, bson.M{"artist": pattern}}})
+		}
+		filter["$and"] = all
+	}
+	return db.findSongs(ctx, query, filter)
 }
 
-func (db *MongoClient) GetSongByID(songID uint32) (Song, bool, error) {
-	return db.GetSong("_id", songID)
+// findSongs returns the page of query of the songs matching filter, and the
+// number of songs matching it.
+func (db *MongoClient) findSongs(ctx context.Context, query SongQuery, filter bson.M) ([]Song, int, error) {
+	collection := db.client.Database(db.dbName).Collection("songs")
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error counting songs: %v", err)
+	}
+
+	order := 1
+	if query.Descending {
+		order = -1
+	}
+	var sortKeys bson.D
+	switch query.SortBy {
+	case SortByArtist:
+		sortKeys = bson.D{{Key: "sortArtist", Value: order}, {Key: "sortTitle", Value: order}}
+	case SortByAdded:
+		sortKeys = bson.D{{Key: "dateAdded", Value: order}}
+	default:
+		sortKeys = bson.D{{Key: "sortTitle", Value: order}, {Key: "sortArtist", Value: order}}
+	}
+	sortKeys = append(sortKeys, bson.E{Key: "_id", Value: order})
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: sortKeys}},
+		{{Key: "$skip", Value: query.Offset}},
+	}
+	if query.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: query.Limit}})
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying songs: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var songs []Song
+	for cursor.Next(ctx) {
+		var doc mongoSong
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, 0, fmt.Errorf("error decoding song: %v", err)
+		}
+		songs = append(songs, doc.song())
+	}
+	return songs, int(total), cursor.Err()
+}
+
+func (db *MongoClient) GetSongByID(ctx context.Context, songID uint32) (Song, bool, error) {
+	return db.GetSong(ctx, "id", songID)
 }
 
-func (db *MongoClient) GetSongByYTID(ytID string) (Song, bool, error) {
-	return db.GetSong("ytID", ytID)
+func (db *MongoClient) GetSongByYTID(ctx context.Context, ytID string) (Song, bool, error) {
+	return db.GetSong(ctx, "ytID", ytID)
 }
 
-func (db *MongoClient) GetSongByKey(key string) (Song, bool, error) {
-	return db.GetSong("key", key)
+func (db *MongoClient) GetSongByKey(ctx context.Context, key string) (Song, bool, error) {
+	return db.GetSong(ctx, "key", key)
 }
 
-func (db *MongoClient) DeleteSongByID(songID uint32) error {
-	songsCollection := db.client.Database("song-recognition").Collection("songs")
+// SongIDs returns the IDs of all registered songs.
+func (db *MongoClient) SongIDs(ctx context.Context) ([]uint32, error) {
+	songsCollection := db.client.Database(db.dbName).Collection("songs")
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.M{"_id": 1})
+	cursor, err := songsCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error querying songs: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var songIDs []uint32
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID int64 `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("error decoding song: %v", err)
+		}
+		songIDs = append(songIDs, uint32(doc.ID))
+	}
+	return songIDs, cursor.Err()
+}
+
+// FingerprintCounts counts the stored fingerprints of every song ID in the
+// index, whether or not the song is registered.
+func (db *MongoClient) FingerprintCounts(ctx context.Context) (map[uint32]int, error) {
+	collection := db.client.Database(db.dbName).Collection("fingerprints")
+
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$unwind", Value: "$couples"}},
+		{{Key: "$group", Value: bson.M{"_id": "$couples.songID", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error counting fingerprints: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	counts := make(map[uint32]int)
+	for cursor.Next(ctx) {
+		var doc struct {
+			SongID int64 `bson:"_id"`
+			Count  int   `bson:"count"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("error decoding fingerprint count: %v", err)
+		}
+		counts[uint32(doc.SongID)] = doc.Count
+	}
+	return counts, cursor.Err()
+}
+
+// GetSongFingerprints returns every fingerprint stored for a song.
+func (db *MongoClient) GetSongFingerprints(ctx context.Context, songID uint32) ([]models.Fingerprint, error) {
+	collection := db.client.Database(db.dbName).Collection("fingerprints")
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"couples.songID": songID}}},
+		{{Key: "$unwind", Value: "$couples"}},
+		{{Key: "$match", Value: bson.M{"couples.songID": songID}}},
+		{{Key: "$project", Value: bson.M{"anchorTimeMs": "$couples.anchorTimeMs"}}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("error querying fingerprints: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var fingerprints []models.Fingerprint
+	for cursor.Next(ctx) {
+		var doc struct {
+			Address      int64 `bson:"_id"`
+			AnchorTimeMs int64 `bson:"anchorTimeMs"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("error decoding fingerprint: %v", err)
+		}
+		fingerprints = append(fingerprints, models.Fingerprint{
+			Address:      uint32(doc.Address),
+			AnchorTimeMs: uint32(doc.AnchorTimeMs),
+			SongID:       songID,
+		})
+	}
+	return fingerprints, cursor.Err()
+}
+
+// deleteCouples removes a song's couples from every address and drops the
+// addresses left without couples.
+func (db *MongoClient) deleteCouples(ctx context.Context, songID uint32) error {
+	collection := db.client.Database(db.dbName).Collection("fingerprints")
+
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"couples.songID": songID},
+		bson.M{"$pull": bson.M{"couples": bson.M{"songID": songID}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete fingerprints: %v", err)
+	}
+
+	_, err = collection.DeleteMany(ctx, bson.M{"couples": bson.M{"$size": 0}})
+	if err != nil {
+		return fmt.Errorf("failed to delete empty addresses: %v", err)
+	}
+	return nil
+}
+
+// DeleteSongFingerprints deletes every fingerprint of a song and keeps the
+// song registered.
+func (db *MongoClient) DeleteSongFingerprints(ctx context.Context, songID uint32) error {
+	if err := db.deleteCouples(ctx, songID); err != nil {
+		return err
+	}
+
+	songsCollection := db.client.Database(db.dbName).Collection("songs")
+	_, err := songsCollection.UpdateOne(ctx,
+		bson.M{"_id": songID}, bson.M{"$set": bson.M{"fingerprintCount": 0}})
+	if err != nil {
+		return fmt.Errorf("failed to reset fingerprint count: %v", err)
+	}
+	return nil
+}
+
+// DeleteSongByID deletes a song and its fingerprints. The fingerprints go
+// first, so a failure never leaves couples pointing at a missing song.
+func (db *MongoClient) DeleteSongByID(ctx context.Context, songID uint32) error {
+	if err := db.deleteCouples(ctx, songID); err != nil {
+		return err
+	}
+
+	songsCollection := db.client.Database(db.dbName).Collection("songs")
 
 	filter := bson.M{"_id": songID}
 
-	_, err := songsCollection.DeleteOne(context.Background(), filter)
+	_, err := songsCollection.DeleteOne(ctx, filter)
 	if err != nil {
 		return fmt.Errorf("failed to delete song: %v", err)
 	}
@@ -191,11 +533,62 @@ func (db *MongoClient) DeleteSongByID(songID uint32) error {
 	return nil
 }
 
-func (db *MongoClient) DeleteCollection(collectionName string) error {
-	collection := db.client.Database("song-recognition").Collection(collectionName)
-	err := collection.Drop(context.Background())
+func (db *MongoClient) DeleteCollection(ctx context.Context, collectionName string) error {
+	collection := db.client.Database(db.dbName).Collection(collectionName)
+	err := collection.Drop(ctx)
 	if err != nil {
 		return fmt.Errorf("error deleting collection: %v", err)
 	}
 	return nil
 }
+
+// GetMeta retrieves a value from the meta collection, which holds index-wide
+// settings such as the fingerprint config.
+func (db *MongoClient) GetMeta(ctx context.Context, key string) (string, bool, error) {
+	collection := db.client.Database(db.dbName).Collection("meta")
+
+	var doc struct {
+		Value string `bson:"value"`
+	}
+	err := collection.FindOne(ctx, bson.M{"_id": key}).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to retrieve meta %q: %v", key, err)
+	}
+	return doc.Value, true, nil
+}
+
+// SetMeta stores a value in the meta collection, replacing any previous value.
+func (db *MongoClient) SetMeta(ctx context.Context, key, value string) error {
+	collection := db.client.Database(db.dbName).Collection("meta")
+
+	opts := options.Update().SetUpsert(true)
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$set": bson.M{"value": value}}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to store meta %q: %v", key, err)
+	}
+	return nil
+}
+
+// StorePlay appends a play to the play log of monitored sources.
+func (db *MongoClient) StorePlay(ctx context.Context, play Play) error {
+	collection := db.client.Database(db.dbName).Collection("plays")
+
+	doc := bson.M{
+		"source":    play.Source,
+		"kind":      play.Kind,
+		"startedAt": play.StartedAt,
+		"endedAt":   play.EndedAt,
+	}
+	if play.Kind == "song" {
+		doc["songID"] = play.SongID
+	}
+
+	_, err := collection.InsertOne(ctx, doc)
+	if err != nil {
+		return fmt.Errorf("failed to store play: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoMigration changes the indexes or documents of the Mongo database.
+// Collections are created on first write, so migrations are about indexes
+// and the shape of existing documents.
+type mongoMigration struct {
+	Migration
+	up func(ctx context.Context, database *mongo.Database) error
+}
+
+// mongoMigrations is the schema history of the Mongo backend. Creating an
+// index that already exists is a no-op, so databases created before
+// migrations were recorded are brought under version control unchanged.
+// Never edit an applied migration; append a new one.
+var mongoMigrations = []mongoMigration{
+	{Migration{1, "Index songs uniquely by ytID and key"}, mongoIndex("songs", mongo.IndexModel{
+		Keys:    bson.D{{Key: "ytID", Value: 1}, {Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})},
+	// Lets a song's fingerprints be listed or deleted without a full scan.
+	{Migration{2, "Index fingerprint couples by song"}, mongoIndex("fingerprints", mongo.IndexModel{
+		Keys: bson.D{{Key: "couples.songID", Value: 1}},
+	})},
+	{Migration{3, "Index plays by source and start"}, mongoIndex("plays", mongo.IndexModel{
+		Keys: bson.D{{Key: "source", Value: 1}, {Key: "startedAt", Value: 1}},
+	})},
+	{Migration{4, "Store song titles, artists and fingerprint counts"}, backfillSongMetadata},
+	// Songs are unique by key alone, as on the other backends.
+	{Migration{5, "Index songs uniquely by key"}, indexSongsByKey},
+	// Without a language, words are neither stemmed nor dropped as stop
+	// words, as in the SQLite index.
+	{Migration{6, "Index song titles and artists for full-text search"}, mongoIndex("songs", mongo.IndexModel{
+		Keys:    bson.D{{Key: "title", Value: "text"}, {Key: "artist", Value: "text"}},
+		Options: options.Index().SetDefaultLanguage("none"),
+	})},
+	{Migration{7, "Store the sort keys of song titles and artists"}, backfillSortKeys},
+}
+
+// mongoIndex returns a migration step creating an index on collection.
+func mongoIndex(collection string, index mongo.IndexModel) func(context.Context, *mongo.Database) error {
+	return func(ctx context.Context, database *mongo.Database) error {
+		_, err := database.Collection(collection).Indexes().CreateOne(ctx, index)
+		return err
+	}
+}
+
+// maxReportedKeys caps the duplicate keys named by indexSongsByKey.
+const maxReportedKeys = 5
+
+// indexSongsByKey makes song keys unique. Older databases were only unique
+// by YouTube ID and key, so they may hold songs sharing a key; those are
+// reported rather than deleted, since only the user can tell which to keep.
+func indexSongsByKey(ctx context.Context, database *mongo.Database) error {
+	songs := database.Collection("songs")
+
+	cursor, err := songs.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$key", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var keys []string
+	for cursor.Next(ctx) {
+		var doc struct {
+			Key string `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		keys = append(keys, fmt.Sprintf("%q", doc.Key))
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		named := keys
+		if len(named) > maxReportedKeys {
+			named = append(named[:maxReportedKeys:maxReportedKeys], "...")
+		}
+		return fmt.Errorf(
+			"%d song keys are shared by several songs (%s); run 'fsck' to list them and 'fsck -prune' to keep the copy of each with the most fingerprints, then migrate again",
+			len(keys), strings.Join(named, ", "))
+	}
+
+	return mongoIndex("songs", mongo.IndexModel{
+		Keys:    bson.D{{Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})(ctx, database)
+}
+
+// backfillSongMetadata splits the title and artist of older songs out of
+// their key, and counts their fingerprints.
+func backfillSongMetadata(ctx context.Context, database *mongo.Database) error {
+	songs := database.Collection("songs")
+
+	split := bson.M{"$split": bson.A{"$key", "---"}}
+	_, err := songs.UpdateMany(ctx, bson.M{"title": bson.M{"$exists": false}}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"title":   bson.M{"$arrayElemAt": bson.A{split, 0}},
+			"artist":  bson.M{"$arrayElemAt": bson.A{split, 1}},
+			"artists": bson.A{bson.M{"$arrayElemAt": bson.A{split, 1}}},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	cursor, err := database.Collection("fingerprints").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$unwind", Value: "$couples"}},
+		{{Key: "$group", Value: bson.M{"_id": "$couples.songID", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc struct {
+			SongID int64 `bson:"_id"`
+			Count  int   `bson:"count"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if _, err := songs.UpdateOne(ctx, bson.M{"_id": doc.SongID}, bson.M{"$set": bson.M{"fingerprintCount": doc.Count}}); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (db *MongoClient) appliedMigrations(ctx context.Context) (map[int]appliedMigration, error) {
+	collection := db.client.Database(db.dbName).Collection("schema_migrations")
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("error querying schema migrations: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	applied := make(map[int]appliedMigration)
+	for cursor.Next(ctx) {
+		var doc struct {
+			Version     int       `bson:"_id"`
+			Description string    `bson:"description"`
+			AppliedAt   time.Time `bson:"appliedAt"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("error decoding schema migration: %v", err)
+		}
+		applied[doc.Version] = appliedMigration{Description: doc.Description, AppliedAt: doc.AppliedAt}
+	}
+	return applied, cursor.Err()
+}
+
+// MigrationStatus lists the migrations of the Mongo schema and whether each
+// has been applied.
+func (db *MongoClient) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make([]Migration, len(mongoMigrations))
+	for i, migration := range mongoMigrations {
+		known[i] = migration.Migration
+	}
+	return migrationStatus(known, applied), nil
+}
+
+// Migrate applies the pending migrations in order and returns those it
+// applied. A migration is recorded once it has succeeded, so one that failed
+// halfway is retried as a whole.
+func (db *MongoClient) Migrate(ctx context.Context) ([]Migration, error) {
+	statuses, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNotNewer(statuses); err != nil {
+		return nil, err
+	}
+
+	database := db.client.Database(db.dbName)
+
+	var migrated []Migration
+	for i, status := range statuses {
+		if status.Applied {
+			continue
+		}
+		migration := mongoMigrations[i]
+
+		if err := migration.up(ctx, database); err != nil {
+			return migrated, fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Description, err)
+		}
+		_, err := database.Collection("schema_migrations").InsertOne(ctx, bson.M{
+			"_id":         migration.Version,
+			"description": migration.Description,
+			"appliedAt":   time.Now().UTC(),
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("error recording migration %d: %v", migration.Version, err)
+		}
+
+		migrated = append(migrated, migration.Migration)
+	}
+
+	return migrated, nil
+}
+
+// backfillSortKeys stores the sort keys of every song. They are computed
+// here rather than by the server, whose $toLower only folds ASCII letters.
+func backfillSortKeys(ctx context.Context, database *mongo.Database) error {
+	songs := database.Collection("songs")
+
+	cursor, err := songs.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"title": 1, "artist": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID     int64  `bson:"_id"`
+			Title  string `bson:"title"`
+			Artist string `bson:"artist"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		update := bson.M{"$set": bson.M{"sortTitle": sortKey(doc.Title), "sortArtist": sortKey(doc.Artist)}}
+		if _, err := songs.UpdateOne(ctx, bson.M{"_id": doc.ID}, update); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -1,20 +1,68 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"runtime"
 	"song-recognition/models"
 	"song-recognition/utils"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/mattn/go-sqlite3"
 )
 
+// sqliteDriver is go-sqlite3 with a fold function lower-casing text like
+// strings.ToLower. SQLite's own lower only folds ASCII letters, so non-ASCII
+// titles would be searched and sorted unlike on the other backends.
+const sqliteDriver = "sqlite3_fold"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("fold", strings.ToLower, true)
+		},
+	})
+}
+
+// couplesBatchSize is the number of addresses looked up per query. It stays
+// well below SQLite's limit on bound parameters.
+const couplesBatchSize = 500
+
 type SQLiteClient struct {
 	db *sql.DB
+
+	// couplesStmt looks up the couples of couplesBatchSize addresses. It is
+	// prepared on first use, once the schema exists.
+	couplesStmt   *sql.Stmt
+	couplesStmtMu sync.Mutex
+	// lookupWorkers is the number of connections GetCouples reads with.
+	lookupWorkers int
+	// fts5 is set when SQLite was built with FTS5, which go-sqlite3 only
+	// includes with the sqlite_fts5 build tag.
+	fts5 bool
 }
 
+// NewSQLiteClient opens a SQLite database and applies any pending migrations.
 func NewSQLiteClient(dataSourceName string) (*SQLiteClient, error) {
+	client, err := openSQLiteClient(dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := client.Migrate(context.Background()); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("error migrating database: %s", err)
+	}
+
+	return client, nil
+}
+
+// openSQLiteClient opens a SQLite database without touching its schema.
+func openSQLiteClient(dataSourceName string) (*SQLiteClient, error) {
 	// Add busy timeout param to DSN (milliseconds)
 	if !strings.Contains(dataSourceName, "_busy_timeout") {
 		if strings.Contains(dataSourceName, "?") {
@@ -24,128 +72,217 @@ func NewSQLiteClient(dataSourceName string) (*SQLiteClient, error) {
 		}
 	}
 
-	db, err := sql.Open("sqlite3", dataSourceName)
+	db, err := sql.Open(sqliteDriver, dataSourceName)
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to SQLite: %s", err)
 	}
 
-	err = createTables(db)
-	if err != nil {
-		return nil, fmt.Errorf("error creating tables: %s", err)
+	// Every connection to an in-memory database opens a database of its own,
+	// so the client must keep to one.
+	lookupWorkers := min(runtime.NumCPU(), 4)
+	if strings.Contains(dataSourceName, ":memory:") || strings.Contains(dataSourceName, "mode=memory") {
+		db.SetMaxOpenConns(1)
+		lookupWorkers = 1
 	}
+	// Keep enough connections open for a batched lookup between requests.
+	db.SetMaxIdleConns(max(lookupWorkers, 2))
 
-	return &SQLiteClient{db: db}, nil
-}
-
-
-// createTables creates the required tables if they don't exist
-func createTables(db *sql.DB) error {
-	createSongsTable := `
-    CREATE TABLE IF NOT EXISTS songs (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        title TEXT NOT NULL,
-        artist TEXT NOT NULL,
-        ytID TEXT,
-        key TEXT NOT NULL UNIQUE
-    );
-    `
-
-	createFingerprintsTable := `
-    CREATE TABLE IF NOT EXISTS fingerprints (
-        address INTEGER NOT NULL,
-        anchorTimeMs INTEGER NOT NULL,
-        songID INTEGER NOT NULL,
-        PRIMARY KEY (address, anchorTimeMs, songID)
-    );
-    `
-
-	_, err := db.Exec(createSongsTable)
-	if err != nil {
-		return fmt.Errorf("error creating songs table: %s", err)
+	var fts5 bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error connecting to SQLite: %s", err)
 	}
 
-	_, err = db.Exec(createFingerprintsTable)
-	if err != nil {
-		return fmt.Errorf("error creating fingerprints table: %s", err)
-	}
+	return &SQLiteClient{db: db, lookupWorkers: lookupWorkers, fts5: fts5}, nil
+}
 
-	return nil
+// FullTextIndexed reports whether full-text searches use the songs_fts
+// index. Without FTS5, they scan the songs table instead.
+func (db *SQLiteClient) FullTextIndexed() bool {
+	return db.fts5
 }
 
 func (db *SQLiteClient) Close() error {
+	db.couplesStmtMu.Lock()
+	defer db.couplesStmtMu.Unlock()
+	if db.couplesStmt != nil {
+		db.couplesStmt.Close()
+	}
 	if db.db != nil {
 		return db.db.Close()
 	}
 	return nil
 }
 
-func (db *SQLiteClient) StoreFingerprints(fingerprints map[uint32]models.Couple) error {
-	tx, err := db.db.Begin()
+// StoreFingerprints stores fingerprints and updates the fingerprint count of
+// their songs.
+func (db *SQLiteClient) StoreFingerprints(ctx context.Context, fingerprints []models.Fingerprint) error {
+	tx, err := db.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("error starting transaction: %s", err)
 	}
 
-	stmt, err := tx.Prepare("INSERT OR REPLACE INTO fingerprints (address, anchorTimeMs, songID) VALUES (?, ?, ?)")
+	stmt, err := tx.PrepareContext(ctx, "INSERT OR REPLACE INTO fingerprints (address, anchorTimeMs, songID) VALUES (?, ?, ?)")
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("error preparing statement: %s", err)
 	}
 	defer stmt.Close()
 
-	for address, couple := range fingerprints {
-		if _, err := stmt.Exec(address, couple.AnchorTimeMs, couple.SongID); err != nil {
+	songIDs := make(map[uint32]bool)
+	for _, fingerprint := range fingerprints {
+		if _, err := stmt.ExecContext(ctx, fingerprint.Address, fingerprint.AnchorTimeMs, fingerprint.SongID); err != nil {
 			tx.Rollback()
 			return fmt.Errorf("error executing statement: %s", err)
 		}
+		songIDs[fingerprint.SongID] = true
+	}
+
+	for songID := range songIDs {
+		_, err := tx.ExecContext(ctx, "UPDATE songs SET fingerprintCount = (SELECT COUNT(*) FROM fingerprints WHERE songID = ?) WHERE id = ?", songID, songID)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error updating fingerprint count: %s", err)
+		}
 	}
 
 	return tx.Commit()
 }
 
-func (db *SQLiteClient) GetCouples(addresses []uint32) (map[uint32][]models.Couple, error) {
-	couples := make(map[uint32][]models.Couple)
+// GetCouples returns the couples stored under each address. Addresses are
+// looked up in batches of couplesBatchSize, on several connections when there
+// is more than one batch.
+func (db *SQLiteClient) GetCouples(ctx context.Context, addresses []uint32) (map[uint32][]models.Couple, error) {
+	couples := make(map[uint32][]models.Couple, len(addresses))
+	if len(addresses) == 0 {
+		return couples, nil
+	}
+
+	var batches [][]uint32
+	for start := 0; start < len(addresses); start += couplesBatchSize {
+		batches = append(batches, addresses[start:min(start+couplesBatchSize, len(addresses))])
+	}
 
-	for _, address := range addresses {
-		rows, err := db.db.Query("SELECT anchorTimeMs, songID FROM fingerprints WHERE address = ?", address)
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	jobs := make(chan []uint32)
+	for w := 0; w < min(db.lookupWorkers, len(batches)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range jobs {
+				batchCouples, err := db.getCouplesBatch(ctx, batch)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				for address, docCouples := range batchCouples {
+					couples[address] = docCouples
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, batch := range batches {
+		jobs <- batch
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return couples, nil
+}
+
+// couplesStatement returns the statement looking up a batch of addresses,
+// preparing it on first use.
+func (db *SQLiteClient) couplesStatement() (*sql.Stmt, error) {
+	db.couplesStmtMu.Lock()
+	defer db.couplesStmtMu.Unlock()
+
+	if db.couplesStmt == nil {
+		query := "SELECT address, anchorTimeMs, songID FROM fingerprints WHERE address IN (?" +
+			strings.Repeat(", ?", couplesBatchSize-1) + ")"
+		stmt, err := db.db.Prepare(query)
 		if err != nil {
-			return nil, fmt.Errorf("error querying database: %s", err)
+			return nil, fmt.Errorf("error preparing statement: %s", err)
 		}
+		db.couplesStmt = stmt
+	}
+	return db.couplesStmt, nil
+}
 
-		var docCouples []models.Couple
-		for rows.Next() {
-			var couple models.Couple
-			if err := rows.Scan(&couple.AnchorTimeMs, &couple.SongID); err != nil {
-				rows.Close() // close before returning error
-				return nil, fmt.Errorf("error scanning row: %s", err)
-			}
-			docCouples = append(docCouples, couple)
+// getCouplesBatch looks up at most couplesBatchSize addresses with the
+// prepared statement. Short batches are padded by repeating their first
+// address, which IN ignores.
+func (db *SQLiteClient) getCouplesBatch(ctx context.Context, addresses []uint32) (map[uint32][]models.Couple, error) {
+	args := make([]interface{}, couplesBatchSize)
+	for i := range args {
+		if i < len(addresses) {
+			args[i] = addresses[i]
+		} else {
+			args[i] = addresses[0]
 		}
+	}
+
+	stmt, err := db.couplesStatement()
+	if err != nil {
+		return nil, err
+	}
 
-		rows.Close() // close explicitly after reading
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %s", err)
+	}
+	defer rows.Close()
 
-		couples[address] = docCouples
+	couples := make(map[uint32][]models.Couple, len(addresses))
+	for _, address := range addresses {
+		couples[address] = nil
+	}
+	for rows.Next() {
+		var address uint32
+		var couple models.Couple
+		if err := rows.Scan(&address, &couple.AnchorTimeMs, &couple.SongID); err != nil {
+			return nil, fmt.Errorf("error scanning row: %s", err)
+		}
+		couples[address] = append(couples[address], couple)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %s", err)
 	}
 
 	return couples, nil
 }
 
-
-func (db *SQLiteClient) TotalSongs() (int, error) {
+func (db *SQLiteClient) TotalSongs(ctx context.Context) (int, error) {
 	var count int
-	err := db.db.QueryRow("SELECT COUNT(*) FROM songs").Scan(&count)
+	err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM songs").Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("error counting songs: %s", err)
 	}
 	return count, nil
 }
 
-func (db *SQLiteClient) RegisterSong(songTitle, songArtist, ytID string) (uint32, error) {
-	tx, err := db.db.Begin()
+// RegisterSong stores a song's metadata under a new ID, which it returns.
+func (db *SQLiteClient) RegisterSong(ctx context.Context, song Song) (uint32, error) {
+	artists, err := json.Marshal(song.Artists)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode artists: %v", err)
+	}
+
+	tx, err := db.db.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, fmt.Errorf("error starting transaction: %s", err)
 	}
 
-	stmt, err := tx.Prepare("INSERT INTO songs (id, title, artist, ytID, key) VALUES (?, ?, ?, ?, ?)")
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO songs (id, title, artist, ytID, key, album, artists, duration, spotifyID, isrc, filePath, dateAdded)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		tx.Rollback()
 		return 0, fmt.Errorf("error preparing statement: %s", err)
@@ -153,8 +290,10 @@ func (db *SQLiteClient) RegisterSong(songTitle, songArtist, ytID string) (uint32
 	defer stmt.Close()
 
 	songID := utils.GenerateUniqueID()
-	songKey := utils.GenerateSongKey(songTitle, songArtist)
-	if _, err := stmt.Exec(songID, songTitle, songArtist, ytID, songKey); err != nil {
+	songKey := utils.GenerateSongKey(song.Title, song.Artist)
+	_, err = stmt.ExecContext(ctx, songID, song.Title, song.Artist, song.YouTubeID, songKey, song.Album, string(artists),
+		song.Duration, song.SpotifyID, song.ISRC, song.FilePath, time.Now().UTC())
+	if err != nil {
 		tx.Rollback()
 		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
 			return 0, fmt.Errorf("song with ytID or key already exists: %v", err)
@@ -165,21 +304,40 @@ func (db *SQLiteClient) RegisterSong(songTitle, songArtist, ytID string) (uint32
 	return songID, tx.Commit()
 }
 
-var sqlitefilterKeys = "id | ytID | key"
+var sqlitefilterKeys = map[string]bool{"id": true, "ytID": true, "key": true}
 
-// GetSong retrieves a song by filter key
-func (s *SQLiteClient) GetSong(filterKey string, value interface{}) (Song, bool, error) {
+// songColumns are the columns of songs scanSong reads, in order.
+const songColumns = "id, title, artist, ytID, album, artists, duration, spotifyID, isrc, filePath, dateAdded, fingerprintCount"
 
-	if !strings.Contains(sqlitefilterKeys, filterKey) {
-		return Song{}, false, fmt.Errorf("invalid filter key")
+// scanSong reads a song from a row of songColumns.
+func scanSong(row interface{ Scan(...interface{}) error }) (Song, error) {
+	var song Song
+	var ytID sql.NullString
+	var artists string
+	var dateAdded sql.NullTime
+	err := row.Scan(&song.ID, &song.Title, &song.Artist, &ytID, &song.Album, &artists, &song.Duration,
+		&song.SpotifyID, &song.ISRC, &song.FilePath, &dateAdded, &song.FingerprintCount)
+	if err != nil {
+		return Song{}, err
+	}
+	song.YouTubeID = ytID.String
+	song.DateAdded = dateAdded.Time
+	if err := json.Unmarshal([]byte(artists), &song.Artists); err != nil {
+		return Song{}, fmt.Errorf("failed to decode artists: %v", err)
 	}
+	return song, nil
+}
 
-	query := fmt.Sprintf("SELECT title, artist, ytID FROM songs WHERE %s = ?", filterKey)
+// GetSong retrieves a song by "id", "ytID" or "key".
+func (s *SQLiteClient) GetSong(ctx context.Context, filterKey string, value interface{}) (Song, bool, error) {
 
-	row := s.db.QueryRow(query, value)
+	if !sqlitefilterKeys[filterKey] {
+		return Song{}, false, fmt.Errorf("invalid filter key")
+	}
 
-	var song Song
-	err := row.Scan(&song.Title, &song.Artist, &song.YouTubeID)
+	query := fmt.Sprintf("SELECT %s FROM songs WHERE %s = ?", songColumns, filterKey)
+
+	song, err := scanSong(s.db.QueryRowContext(ctx, query, value))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return Song{}, false, nil
@@ -190,32 +348,268 @@ func (s *SQLiteClient) GetSong(filterKey string, value interface{}) (Song, bool,
 	return song, true, nil
 }
 
-func (db *SQLiteClient) GetSongByID(songID uint32) (Song, bool, error) {
-	return db.GetSong("id", songID)
+func (db *SQLiteClient) GetSongByID(ctx context.Context, songID uint32) (Song, bool, error) {
+	return db.GetSong(ctx, "id", songID)
+}
+
+func (db *SQLiteClient) GetSongByYTID(ctx context.Context, ytID string) (Song, bool, error) {
+	return db.GetSong(ctx, "ytID", ytID)
+}
+
+func (db *SQLiteClient) GetSongByKey(ctx context.Context, key string) (Song, bool, error) {
+	return db.GetSong(ctx, "key", key)
+}
+
+// SongIDs returns the IDs of all registered songs.
+func (db *SQLiteClient) SongIDs(ctx context.Context) ([]uint32, error) {
+	rows, err := db.db.QueryContext(ctx, "SELECT id FROM songs ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error querying songs: %s", err)
+	}
+	defer rows.Close()
+
+	var songIDs []uint32
+	for rows.Next() {
+		var songID uint32
+		if err := rows.Scan(&songID); err != nil {
+			return nil, fmt.Errorf("error scanning row: %s", err)
+		}
+		songIDs = append(songIDs, songID)
+	}
+	return songIDs, rows.Err()
+}
+
+// ListSongs returns a page of the songs and the number of songs.
+func (db *SQLiteClient) ListSongs(ctx context.Context, query SongQuery) ([]Song, int, error) {
+	if err := query.check(); err != nil {
+		return nil, 0, err
+	}
+	return db.findSongs(ctx, query, "1")
+}
+
+// SearchSongs returns a page of the songs matching text and the number of
+// songs matching it. Full-text searches use the songs_fts index when SQLite
+// was built with FTS5.
+func (db *SQLiteClient) SearchSongs(ctx context.Context, text string, mode SearchMode, query SongQuery) ([]Song, int, error) {
+	if err := checkSearchMode(mode); err != nil {
+		return nil, 0, err
+	}
+	if err := query.check(); err != nil {
+		return nil, 0, err
+	}
+
+	if mode == SearchSubstring {
+		needle := strings.ToLower(text)
+		return db.findSongs(ctx, query, "instr(fold(title), ?) > 0 OR instr(fold(artist), ?) > 0", needle, needle)
+	}
+
+	words := searchWords(text)
+	if len(words) == 0 {
+		return db.findSongs(ctx, query, "1")
+	}
+	indexed, err := db.searchIndexed(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if indexed {
+		// Quoted, the words are matched as terms rather than FTS5 syntax.
+		match := `"` + strings.Join(words, `" "`) + `"`
+		return db.findSongs(ctx, query, "id IN (SELECT rowid FROM songs_fts WHERE songs_fts MATCH ?)", match)
+	}
+
+	// Without the index, narrow the songs down by substring and match words
+	// here.
+	conditions := make([]string, len(words))
+	args := make([]interface{}, len(words))
+	for i, word := range words {
+		conditions[i] = "instr(fold(title || ' ' || artist), ?) > 0"
+		args[i] = word
+	}
+	candidates, _, err := db.findSongs(ctx, SongQuery{SortBy: query.SortBy, Descending: query.Descending},
+		strings.Join(conditions, " AND "), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	var songs []Song
+	for _, song := range candidates {
+		if matchesSearch(song, text, mode) {
+			songs = append(songs, song)
+		}
+	}
+	return query.page(songs), len(songs), nil
 }
 
-func (db *SQLiteClient) GetSongByYTID(ytID string) (Song, bool, error) {
-	return db.GetSong("ytID", ytID)
+// findSongs returns the page of query of the songs matching the SQL condition
+// where, and the number of songs matching it.
+func (db *SQLiteClient) findSongs(ctx context.Context, query SongQuery, where string, args ...interface{}) ([]Song, int, error) {
+	var total int
+	err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM songs WHERE "+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error counting songs: %s", err)
+	}
+
+	limit := query.Limit
+	if limit == 0 {
+		limit = -1 // no limit
+	}
+	statement := fmt.Sprintf("SELECT %s FROM songs WHERE %s ORDER BY %s LIMIT ? OFFSET ?", songColumns, where, sqliteSongOrder(query))
+	rows, err := db.db.QueryContext(ctx, statement, append(args, limit, query.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying songs: %s", err)
+	}
+	defer rows.Close()
+
+	var songs []Song
+	for rows.Next() {
+		song, err := scanSong(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error scanning row: %s", err)
+		}
+		songs = append(songs, song)
+	}
+	return songs, total, rows.Err()
 }
 
-func (db *SQLiteClient) GetSongByKey(key string) (Song, bool, error) {
-	return db.GetSong("key", key)
+// sqliteSongOrder returns the ORDER BY terms of query's sort.
+func sqliteSongOrder(query SongQuery) string {
+	var terms []string
+	switch query.SortBy {
+	case SortByArtist:
+		terms = []string{"fold(artist)", "fold(title)"}
+	case SortByAdded:
+		terms = []string{"dateAdded"}
+	default:
+		terms = []string{"fold(title)", "fold(artist)"}
+	}
+	terms = append(terms, "id")
+	if query.Descending {
+		for i := range terms {
+			terms[i] += " DESC"
+		}
+	}
+	return strings.Join(terms, ", ")
 }
 
-// DeleteSongByID deletes a song by ID
-func (db *SQLiteClient) DeleteSongByID(songID uint32) error {
-	_, err := db.db.Exec("DELETE FROM songs WHERE id = ?", songID)
+// FingerprintCounts counts the stored fingerprints of every song ID in the
+// index, whether or not the song is registered.
+func (db *SQLiteClient) FingerprintCounts(ctx context.Context) (map[uint32]int, error) {
+	rows, err := db.db.QueryContext(ctx, "SELECT songID, COUNT(*) FROM fingerprints GROUP BY songID")
 	if err != nil {
+		return nil, fmt.Errorf("error counting fingerprints: %s", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[uint32]int)
+	for rows.Next() {
+		var songID uint32
+		var count int
+		if err := rows.Scan(&songID, &count); err != nil {
+			return nil, fmt.Errorf("error scanning row: %s", err)
+		}
+		counts[songID] = count
+	}
+	return counts, rows.Err()
+}
+
+// GetSongFingerprints returns every fingerprint stored for a song.
+func (db *SQLiteClient) GetSongFingerprints(ctx context.Context, songID uint32) ([]models.Fingerprint, error) {
+	rows, err := db.db.QueryContext(ctx, "SELECT address, anchorTimeMs FROM fingerprints WHERE songID = ?", songID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying fingerprints: %s", err)
+	}
+	defer rows.Close()
+
+	var fingerprints []models.Fingerprint
+	for rows.Next() {
+		fingerprint := models.Fingerprint{SongID: songID}
+		if err := rows.Scan(&fingerprint.Address, &fingerprint.AnchorTimeMs); err != nil {
+			return nil, fmt.Errorf("error scanning row: %s", err)
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	return fingerprints, rows.Err()
+}
+
+// DeleteSongByID deletes a song and its fingerprints.
+func (db *SQLiteClient) DeleteSongByID(ctx context.Context, songID uint32) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM fingerprints WHERE songID = ?", songID); err != nil {
+		return fmt.Errorf("failed to delete fingerprints: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM songs WHERE id = ?", songID); err != nil {
 		return fmt.Errorf("failed to delete song: %v", err)
 	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// DeleteSongFingerprints deletes every fingerprint of a song.
+func (db *SQLiteClient) DeleteSongFingerprints(ctx context.Context, songID uint32) error {
+	_, err := db.db.ExecContext(ctx, "DELETE FROM fingerprints WHERE songID = ?", songID)
+	if err != nil {
+		return fmt.Errorf("failed to delete fingerprints: %v", err)
+	}
+
+	_, err = db.db.ExecContext(ctx, "UPDATE songs SET fingerprintCount = 0 WHERE id = ?", songID)
+	if err != nil {
+		return fmt.Errorf("failed to reset fingerprint count: %v", err)
+	}
 	return nil
 }
 
 // DeleteCollection deletes a collection (table) from the database
-func (db *SQLiteClient) DeleteCollection(collectionName string) error {
-	_, err := db.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", collectionName))
+func (db *SQLiteClient) DeleteCollection(ctx context.Context, collectionName string) error {
+	_, err := db.db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", collectionName))
 	if err != nil {
 		return fmt.Errorf("error deleting collection: %v", err)
 	}
 	return nil
 }
+
+// GetMeta retrieves a value from the meta table, which holds index-wide
+// settings such as the fingerprint config.
+func (db *SQLiteClient) GetMeta(ctx context.Context, key string) (string, bool, error) {
+	var value string
+	err := db.db.QueryRowContext(ctx, "SELECT value FROM meta WHERE key = ?", key).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to retrieve meta %q: %s", key, err)
+	}
+	return value, true, nil
+}
+
+// SetMeta stores a value in the meta table, replacing any previous value.
+func (db *SQLiteClient) SetMeta(ctx context.Context, key, value string) error {
+	_, err := db.db.ExecContext(ctx, "INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)", key, value)
+	if err != nil {
+		return fmt.Errorf("failed to store meta %q: %s", key, err)
+	}
+	return nil
+}
+
+// StorePlay appends a play to the play log of monitored sources.
+func (db *SQLiteClient) StorePlay(ctx context.Context, play Play) error {
+	var songID interface{}
+	if play.Kind == "song" {
+		songID = play.SongID
+	}
+
+	_, err := db.db.ExecContext(ctx,
+		"INSERT INTO plays (source, kind, songID, startedAt, endedAt) VALUES (?, ?, ?, ?, ?)",
+		play.Source, play.Kind, songID, play.StartedAt.UTC(), play.EndedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store play: %v", err)
+	}
+	return nil
+}
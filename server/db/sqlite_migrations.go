@@ -0,0 +1,288 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqliteMigration changes the SQLite schema inside a transaction.
+type sqliteMigration struct {
+	Migration
+	up func(ctx context.Context, tx *sql.Tx) error
+}
+
+// sqliteMigrations is the schema history of the SQLite backend. The first
+// steps only create what is missing, so databases created before migrations
+// were recorded are brought under version control unchanged. Never edit an
+// applied migration; append a new one.
+var sqliteMigrations = []sqliteMigration{
+	{Migration{1, "Create songs and fingerprints tables"}, sqliteExec(`
+    CREATE TABLE IF NOT EXISTS songs (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        title TEXT NOT NULL,
+        artist TEXT NOT NULL,
+        ytID TEXT,
+        key TEXT NOT NULL UNIQUE
+    );
+    `, `
+    CREATE TABLE IF NOT EXISTS fingerprints (
+        address INTEGER NOT NULL,
+        anchorTimeMs INTEGER NOT NULL,
+        songID INTEGER NOT NULL,
+        PRIMARY KEY (address, anchorTimeMs, songID)
+    );
+    `)},
+	{Migration{2, "Create meta table for the fingerprint config"}, sqliteExec(`
+    CREATE TABLE IF NOT EXISTS meta (
+        key TEXT PRIMARY KEY,
+        value TEXT NOT NULL
+    );
+    `)},
+	{Migration{3, "Create plays table for monitored sources"}, sqliteExec(`
+    CREATE TABLE IF NOT EXISTS plays (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        source TEXT NOT NULL,
+        kind TEXT NOT NULL,
+        songID INTEGER,
+        startedAt TIMESTAMP NOT NULL,
+        endedAt TIMESTAMP NOT NULL
+    );
+    `)},
+	// Lets a song's fingerprints be listed or deleted without a full scan.
+	{Migration{4, "Index fingerprints by song"}, sqliteExec(`
+    CREATE INDEX IF NOT EXISTS idx_fingerprints_songID ON fingerprints (songID);
+    `)},
+	{Migration{5, "Add album, artists, duration, Spotify ID, ISRC, file path, date added and fingerprint count to songs"}, sqliteExec(
+		"ALTER TABLE songs ADD COLUMN album TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE songs ADD COLUMN artists TEXT NOT NULL DEFAULT '[]'", // JSON array
+		"ALTER TABLE songs ADD COLUMN duration INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE songs ADD COLUMN spotifyID TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE songs ADD COLUMN isrc TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE songs ADD COLUMN filePath TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE songs ADD COLUMN dateAdded TIMESTAMP",
+		"ALTER TABLE songs ADD COLUMN fingerprintCount INTEGER NOT NULL DEFAULT 0",
+		"UPDATE songs SET fingerprintCount = (SELECT COUNT(*) FROM fingerprints WHERE fingerprints.songID = songs.id)",
+	)},
+}
+
+// sqliteExec returns a migration step executing statements in order.
+func sqliteExec(statements ...string) func(context.Context, *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func createMigrationsTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INTEGER PRIMARY KEY,
+        description TEXT NOT NULL,
+        appliedAt TIMESTAMP NOT NULL
+    );
+    `)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %s", err)
+	}
+	return nil
+}
+
+func (db *SQLiteClient) appliedMigrations(ctx context.Context) (map[int]appliedMigration, error) {
+	if err := createMigrationsTable(ctx, db.db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.db.QueryContext(ctx, "SELECT version, description, appliedAt FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error querying schema migrations: %s", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var record appliedMigration
+		if err := rows.Scan(&version, &record.Description, &record.AppliedAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %s", err)
+		}
+		applied[version] = record
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus lists the migrations of the SQLite schema and whether each
+// has been applied.
+func (db *SQLiteClient) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make([]Migration, len(sqliteMigrations))
+	for i, migration := range sqliteMigrations {
+		known[i] = migration.Migration
+	}
+	return migrationStatus(known, applied), nil
+}
+
+// Migrate applies the pending migrations in order, each in a transaction of
+// its own, and returns those it applied.
+func (db *SQLiteClient) Migrate(ctx context.Context) ([]Migration, error) {
+	statuses, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNotNewer(statuses); err != nil {
+		return nil, err
+	}
+
+	var migrated []Migration
+	for i, status := range statuses {
+		if status.Applied {
+			continue
+		}
+		migration := sqliteMigrations[i]
+
+		tx, err := db.db.BeginTx(ctx, nil)
+		if err != nil {
+			return migrated, fmt.Errorf("error starting transaction: %s", err)
+		}
+		if err := migration.up(ctx, tx); err != nil {
+			tx.Rollback()
+			return migrated, fmt.Errorf("migration %d (%s) failed: %s", migration.Version, migration.Description, err)
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, description, appliedAt) VALUES (?, ?, ?)",
+			migration.Version, migration.Description, time.Now().UTC())
+		if err != nil {
+			tx.Rollback()
+			return migrated, fmt.Errorf("error recording migration %d: %s", migration.Version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return migrated, fmt.Errorf("error committing migration %d: %s", migration.Version, err)
+		}
+
+		migrated = append(migrated, migration.Migration)
+	}
+
+	if err := db.syncSearchIndex(ctx); err != nil {
+		return migrated, err
+	}
+	return migrated, nil
+}
+
+// The songs_fts full-text index of song titles and artists needs FTS5, so it
+// is not a migration: the same database may be opened by builds with and
+// without FTS5. Triggers keep the index up to date. Builds without FTS5
+// drop them, as songs could not be written with them, and builds with FTS5
+// recreate them and rebuild the index.
+var searchIndexTriggers = []string{"songs_fts_insert", "songs_fts_delete", "songs_fts_update"}
+
+// searchIndexTokenizer splits titles and artists into words of letters and
+// digits and folds their case, but keeps diacritics as the other backends do.
+const searchIndexTokenizer = "unicode61 remove_diacritics 0"
+
+// syncSearchIndex creates and fills songs_fts if it is missing, stale or
+// built with another tokenizer, or drops its triggers if SQLite was built
+// without FTS5.
+func (db *SQLiteClient) syncSearchIndex(ctx context.Context) error {
+	triggers, err := db.searchTriggers(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !db.fts5 {
+		if triggers == 0 {
+			return nil
+		}
+		for _, trigger := range searchIndexTriggers {
+			if _, err := db.db.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+trigger); err != nil {
+				return fmt.Errorf("error dropping search index trigger: %s", err)
+			}
+		}
+		return nil
+	}
+	if triggers == len(searchIndexTriggers) {
+		current, err := db.searchTableCurrent(ctx)
+		if err != nil || current {
+			return err
+		}
+	}
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	err = sqliteExec(`
+    DROP TABLE IF EXISTS songs_fts;
+    `, `
+    CREATE VIRTUAL TABLE songs_fts USING fts5 (
+        title, artist, content='songs', content_rowid='id', tokenize='`+searchIndexTokenizer+`'
+    );
+    `, `
+    CREATE TRIGGER IF NOT EXISTS songs_fts_insert AFTER INSERT ON songs BEGIN
+        INSERT INTO songs_fts (rowid, title, artist) VALUES (new.id, new.title, new.artist);
+    END;
+    `, `
+    CREATE TRIGGER IF NOT EXISTS songs_fts_delete AFTER DELETE ON songs BEGIN
+        INSERT INTO songs_fts (songs_fts, rowid, title, artist) VALUES ('delete', old.id, old.title, old.artist);
+    END;
+    `, `
+    CREATE TRIGGER IF NOT EXISTS songs_fts_update AFTER UPDATE OF title, artist ON songs BEGIN
+        INSERT INTO songs_fts (songs_fts, rowid, title, artist) VALUES ('delete', old.id, old.title, old.artist);
+        INSERT INTO songs_fts (rowid, title, artist) VALUES (new.id, new.title, new.artist);
+    END;
+    `, `
+    INSERT INTO songs_fts (songs_fts) VALUES ('rebuild');
+    `)(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("error creating search index: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing search index: %s", err)
+	}
+	return nil
+}
+
+// searchIndexed reports whether songs_fts can be searched: SQLite has FTS5
+// and the index's triggers keep it up to date.
+func (db *SQLiteClient) searchIndexed(ctx context.Context) (bool, error) {
+	if !db.fts5 {
+		return false, nil
+	}
+	triggers, err := db.searchTriggers(ctx)
+	return triggers == len(searchIndexTriggers), err
+}
+
+// searchTableCurrent reports whether songs_fts exists with the current
+// tokenizer.
+func (db *SQLiteClient) searchTableCurrent(ctx context.Context) (bool, error) {
+	var statement string
+	err := db.db.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'songs_fts'").Scan(&statement)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error checking search index: %s", err)
+	}
+	return strings.Contains(statement, searchIndexTokenizer), nil
+}
+
+// searchTriggers counts the triggers of songs_fts in the database.
+func (db *SQLiteClient) searchTriggers(ctx context.Context) (int, error) {
+	var triggers int
+	err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN (?, ?, ?)",
+		searchIndexTriggers[0], searchIndexTriggers[1], searchIndexTriggers[2]).Scan(&triggers)
+	if err != nil {
+		return 0, fmt.Errorf("error checking search index: %s", err)
+	}
+	return triggers, nil
+}
@@ -0,0 +1,262 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"song-recognition/models"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSQLiteGetCouplesBatches(t *testing.T) {
+	ctx := context.Background()
+	for _, dsn := range []string{"file::memory:?cache=private", ""} {
+		if dsn == "" {
+			dsn = filepath.Join(t.TempDir(), "db.sqlite3")
+		}
+		client, err := NewSQLiteClient(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		// Addresses 0..1199 span three batches; even ones have two couples,
+		// odd ones none.
+		var fingerprints []models.Fingerprint
+		var addresses []uint32
+		for address := uint32(0); address < 1200; address++ {
+			addresses = append(addresses, address)
+			if address%2 == 0 {
+				fingerprints = append(fingerprints,
+					models.Fingerprint{Address: address, AnchorTimeMs: address, SongID: 1},
+					models.Fingerprint{Address: address, AnchorTimeMs: address + 1, SongID: 2})
+			}
+		}
+		if err := client.StoreFingerprints(ctx, fingerprints); err != nil {
+			t.Fatal(err)
+		}
+
+		couples, err := client.GetCouples(ctx, addresses)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(couples) != len(addresses) {
+			t.Fatalf("%s: got %d addresses, want %d", dsn, len(couples), len(addresses))
+		}
+		for _, address := range addresses {
+			got := couples[address]
+			want := 0
+			if address%2 == 0 {
+				want = 2
+			}
+			if len(got) != want {
+				t.Fatalf("%s: address %d has %d couples, want %d", dsn, address, len(got), want)
+			}
+			if want > 0 {
+				sort.Slice(got, func(i, j int) bool { return got[i].SongID < got[j].SongID })
+				if got[0] != (models.Couple{AnchorTimeMs: address, SongID: 1}) || got[1] != (models.Couple{AnchorTimeMs: address + 1, SongID: 2}) {
+					t.Fatalf("%s: address %d has couples %+v", dsn, address, got)
+				}
+			}
+		}
+
+		if couples, err := client.GetCouples(ctx, nil); err != nil || len(couples) != 0 {
+			t.Errorf("%s: got %v, %v for no addresses", dsn, couples, err)
+		}
+	}
+}
+
+const (
+	benchmarkSongs            = 10_000
+	benchmarkSongFingerprints = 300
+	benchmarkQueryAddresses   = 800 // about a 10 s sample
+)
+
+// BenchmarkSQLiteGetCouples compares batched lookups with one query per
+// address on a database of 10k songs. Building the database takes a while.
+func BenchmarkSQLiteGetCouples(b *testing.B) {
+	ctx := context.Background()
+	client, err := NewSQLiteClient(filepath.Join(b.TempDir(), "db.sqlite3"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+
+	rng := rand.New(rand.NewSource(1))
+	fingerprints := make([]models.Fingerprint, 0, benchmarkSongs*benchmarkSongFingerprints)
+	for songID := uint32(1); songID <= benchmarkSongs; songID++ {
+		for i := 0; i < benchmarkSongFingerprints; i++ {
+			fingerprints = append(fingerprints, models.Fingerprint{
+				Address:      rng.Uint32(),
+				AnchorTimeMs: uint32(i * 50),
+				SongID:       songID,
+			})
+		}
+	}
+	if err := client.StoreFingerprints(ctx, fingerprints); err != nil {
+		b.Fatal(err)
+	}
+
+	// Half the sample's addresses are in the index.
+	addresses := make([]uint32, benchmarkQueryAddresses)
+	for i := range addresses {
+		if i%2 == 0 {
+			addresses[i] = fingerprints[rng.Intn(len(fingerprints))].Address
+		} else {
+			addresses[i] = rng.Uint32()
+		}
+	}
+
+	b.Run("per-address", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := getCouplesPerAddress(client, addresses); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("batched", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := client.GetCouples(ctx, addresses); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// getCouplesPerAddress is the lookup GetCouples replaced, with one query per
+// address.
+func getCouplesPerAddress(client *SQLiteClient, addresses []uint32) (map[uint32][]models.Couple, error) {
+	couples := make(map[uint32][]models.Couple)
+	for _, address := range addresses {
+		rows, err := client.db.Query("SELECT anchorTimeMs, songID FROM fingerprints WHERE address = ?", address)
+		if err != nil {
+			return nil, fmt.Errorf("error querying database: %s", err)
+		}
+		for rows.Next() {
+			var couple models.Couple
+			if err := rows.Scan(&couple.AnchorTimeMs, &couple.SongID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			couples[address] = append(couples[address], couple)
+		}
+		rows.Close()
+	}
+	return couples, nil
+}
+
+func TestSQLiteSongMetadata(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewSQLiteClient(filepath.Join(t.TempDir(), "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	song := Song{
+		Title:     "Title",
+		Artist:    "Artist",
+		YouTubeID: "yt",
+		Album:     "Album",
+		Artists:   []string{"Artist", "Guest"},
+		Duration:  215,
+		SpotifyID: "4pqwGuGu34g8KtfN8LDGZm",
+		ISRC:      "USRC17607839",
+		FilePath:  "songs/Title - Artist.wav",
+	}
+	songID, err := client.RegisterSong(ctx, song)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprints := []models.Fingerprint{
+		{Address: 1, AnchorTimeMs: 0, SongID: songID},
+		{Address: 2, AnchorTimeMs: 50, SongID: songID},
+		{Address: 3, AnchorTimeMs: 100, SongID: songID},
+	}
+	if err := client.StoreFingerprints(ctx, fingerprints); err != nil {
+		t.Fatal(err)
+	}
+
+	got, found, err := client.GetSongByID(ctx, songID)
+	if err != nil || !found {
+		t.Fatalf("got %v, %v", found, err)
+	}
+	if got.DateAdded.IsZero() || time.Since(got.DateAdded) > time.Minute {
+		t.Errorf("date added is %v", got.DateAdded)
+	}
+	song.ID, song.DateAdded, song.FingerprintCount = songID, got.DateAdded, len(fingerprints)
+	if !reflect.DeepEqual(got, song) {
+		t.Errorf("got %+v, want %+v", got, song)
+	}
+
+	if err := client.DeleteSongFingerprints(ctx, songID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := client.GetSongByID(ctx, songID); got.FingerprintCount != 0 {
+		t.Errorf("%d fingerprints counted after deleting them", got.FingerprintCount)
+	}
+}
+
+func TestSQLiteCanceledContext(t *testing.T) {
+	client, err := NewSQLiteClient(filepath.Join(t.TempDir(), "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	fingerprints := []models.Fingerprint{{Address: 1, AnchorTimeMs: 0, SongID: 1}}
+	if err := client.StoreFingerprints(ctx, fingerprints); err == nil {
+		t.Error("stored fingerprints with a canceled context")
+	}
+	if _, err := client.GetCouples(ctx, []uint32{1, 2, 3}); err == nil {
+		t.Error("looked up couples with a canceled context")
+	}
+
+	counts, err := client.FingerprintCounts(context.Background())
+	if err != nil || len(counts) != 0 {
+		t.Errorf("got %v, %v after a canceled store, want nothing stored", counts, err)
+	}
+}
+
+// A database opened by a build without FTS5 loses its search index, which a
+// build with FTS5 rebuilds. Run with -tags sqlite_fts5.
+func TestSQLiteSearchIndex(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewSQLiteClient(filepath.Join(t.TempDir(), "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !client.fts5 {
+		t.Skip("SQLite was built without FTS5")
+	}
+	if indexed, err := client.searchIndexed(ctx); err != nil || !indexed {
+		t.Fatalf("search index: %v, %v", indexed, err)
+	}
+	mustRegister(t, client, Song{Title: "Indexed", Artist: "Artist"})
+
+	client.fts5 = false
+	if _, err := client.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if triggers, err := client.searchTriggers(ctx); err != nil || triggers != 0 {
+		t.Fatalf("%d triggers left without FTS5 (%v)", triggers, err)
+	}
+	mustRegister(t, client, Song{Title: "Unindexed", Artist: "Artist"})
+
+	client.fts5 = true
+	if _, err := client.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	songs, total, err := client.SearchSongs(ctx, "artist", SearchFullText, SongQuery{})
+	if err != nil || total != 2 {
+		t.Errorf("found %q, %v after rebuilding the index", songTitles(songs), err)
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"song-recognition/db"
+	"song-recognition/shazam"
 	"song-recognition/utils"
+	"strings"
 
 	"github.com/joho/godotenv"
 	"github.com/mdobak/go-xerrors"
@@ -31,11 +34,23 @@ func main() {
 	}
 
 	if len(os.Args) < 2 {
-		fmt.Println("Expected 'find', 'download', 'erase', 'save', or 'serve' subcommands")
+		fmt.Println("Expected 'find', 'tracklist', 'monitor', 'visualize', 'evaluate', 'dedupe', 'fsck', 'export', 'import', 'list', 'search', 'download', 'erase', 'migrate', 'remove', 'save', or 'serve' subcommands")
 		fmt.Println("\nUsage examples:")
-		fmt.Println("  find <path_to_wav_file>")
+		fmt.Println("  find [-min-aligned <n>] [-min-zscore <z>] [-min-ratio <r>] <path_to_wav_file>")
+		fmt.Println("  tracklist [-format <text|json|cue>] [-segment <s>] [-hop <s>] <path_to_file>")
+		fmt.Println("  monitor [-save] [-window <s>] [-interval <s>] [-raw -rate <hz> -channels <n>] <url_file_or_pipe | ->")
+		fmt.Println("  visualize [-format <png|svg>] [-o <path>] [-query] [-song <id>] <path_to_file>")
+		fmt.Println("  evaluate [-clip <s>] [-clips <n>] [-degrade <list>] [-negatives <dir>] [-json <path>] [library_dir]")
+		fmt.Println("  dedupe [-remove] [-json] [-min-fraction <f>] [-keep <longest|youtube>] [-prefer <ids>]")
+		fmt.Println("  fsck [-prune] [-refingerprint] [-register]")
+		fmt.Println("  export [-artist <name>]... [-songs <ids>] <bundle_file>")
+		fmt.Println("  import <bundle_file>...")
+		fmt.Println("  list [-sort <title|artist|added>] [-desc] [-limit <n>] [-offset <n>] [-json]")
+		fmt.Println("  search [-fulltext] [-sort <title|artist|added>] [-desc] [-limit <n>] [-offset <n>] [-json] <text>")
 		fmt.Println("  download <spotify_url>")
 		fmt.Println("  erase [db | all]  (default: db)")
+		fmt.Println("  migrate [status | up | adopt-config]  (default: status)")
+		fmt.Println("  remove [-file] <song_id>... | -yt <youtube_id> | -title <title> -artist <artist>")
 		fmt.Println("  save [-f|--force] <path_to_file_or_dir>")
 		fmt.Println("  serve [-proto <http|https>] [-p <port>]")
 		os.Exit(1)
@@ -44,12 +59,178 @@ func main() {
 
 	switch os.Args[1] {
 	case "find":
+		findCmd := flag.NewFlagSet("find", flag.ExitOnError)
+		defaults := shazam.DefaultDecisionOptions()
+		minAligned := findCmd.Int("min-aligned", defaults.MinAlignedHashes, "minimum number of aligned hashes for a match")
+		minZScore := findCmd.Float64("min-zscore", defaults.MinZScore, "minimum offset peak height above background, in standard deviations")
+		minRatio := findCmd.Float64("min-ratio", defaults.MinRunnerUpRatio, "minimum ratio of aligned hashes between the best and second-best song")
+		findCmd.Parse(os.Args[2:])
+		if findCmd.NArg() < 1 {
+			fmt.Println("Usage: main.go find [-min-aligned <n>] [-min-zscore <z>] [-min-ratio <r>] <path_to_wav_file>")
+			os.Exit(1)
+		}
+		filePath := findCmd.Arg(0)
+		find(filePath, shazam.DecisionOptions{
+			MinAlignedHashes: *minAligned,
+			MinZScore:        *minZScore,
+			MinRunnerUpRatio: *minRatio,
+		})
+	case "tracklist":
+		tracklistCmd := flag.NewFlagSet("tracklist", flag.ExitOnError)
+		defaults := shazam.DefaultTracklistOptions()
+		format := tracklistCmd.String("format", "text", "output format (text, json or cue)")
+		segment := tracklistCmd.Float64("segment", defaults.SegmentSeconds, "length of each query in seconds")
+		hop := tracklistCmd.Float64("hop", defaults.HopSeconds, "seconds between the starts of two queries")
+		minSegments := tracklistCmd.Int("min-segments", defaults.MinSegments, "drop songs detected in fewer segments")
+		tracklistCmd.Parse(os.Args[2:])
+		if tracklistCmd.NArg() < 1 || (*format != "text" && *format != "json" && *format != "cue") {
+			fmt.Println("Usage: main.go tracklist [-format <text|json|cue>] [-segment <s>] [-hop <s>] [-min-segments <n>] <path_to_file>")
+			os.Exit(1)
+		}
+		opts := defaults
+		opts.SegmentSeconds = *segment
+		opts.HopSeconds = *hop
+		opts.MinSegments = *minSegments
+		tracklist(tracklistCmd.Arg(0), *format, opts)
+	case "monitor":
+		monitorCmd := flag.NewFlagSet("monitor", flag.ExitOnError)
+		defaults := shazam.DefaultMonitorOptions()
+		save := monitorCmd.Bool("save", false, "store the play log in the database")
+		window := monitorCmd.Float64("window", defaults.WindowSeconds, "seconds of audio sent with each query")
+		interval := monitorCmd.Float64("interval", defaults.IntervalSeconds, "seconds between queries")
+		debounce := monitorCmd.Int("debounce", defaults.Debounce, "consecutive queries needed to report a change")
+		raw := monitorCmd.Bool("raw", false, "read raw 16-bit little-endian PCM instead of decoding with ffmpeg (implied for -)")
+		rate := monitorCmd.Int("rate", 44100, "sample rate of raw PCM")
+		channels := monitorCmd.Int("channels", 1, "number of channels of raw PCM")
+		monitorCmd.Parse(os.Args[2:])
+		if monitorCmd.NArg() < 1 {
+			fmt.Println("Usage: main.go monitor [-save] [-window <s>] [-interval <s>] [-debounce <n>] [-raw -rate <hz> -channels <n>] <url_file_or_pipe | ->")
+			os.Exit(1)
+		}
+		opts := defaults
+		opts.WindowSeconds = *window
+		opts.IntervalSeconds = *interval
+		opts.Debounce = *debounce
+		monitor(monitorCmd.Arg(0), *raw, *rate, *channels, *save, opts)
+	case "visualize":
+		visualizeCmd := flag.NewFlagSet("visualize", flag.ExitOnError)
+		format := visualizeCmd.String("format", "png", "image format (png or svg)")
+		output := visualizeCmd.String("o", "", "output path (default: <file name>.<format>)")
+		query := visualizeCmd.Bool("query", false, "match the recording and draw the hashes it shares with a song")
+		songID := visualizeCmd.Uint("song", 0, "song to trace with -query (default: best match)")
+		visualizeCmd.Parse(os.Args[2:])
+		if visualizeCmd.NArg() < 1 || (*format != "png" && *format != "svg") {
+			fmt.Println("Usage: main.go visualize [-format <png|svg>] [-o <path>] [-query] [-song <id>] <path_to_file>")
+			os.Exit(1)
+		}
+		visualize(visualizeCmd.Arg(0), *output, *format, *query, uint32(*songID))
+	case "evaluate":
+		evaluateCmd := flag.NewFlagSet("evaluate", flag.ExitOnError)
+		defaults := shazam.DefaultEvaluationOptions()
+		var defaultDegradations []string
+		for _, d := range defaults.Degradations {
+			defaultDegradations = append(defaultDegradations, d.Name)
+		}
+		clip := evaluateCmd.Float64("clip", defaults.ClipSeconds, "length of each clip in seconds")
+		clips := evaluateCmd.Int("clips", defaults.ClipsPerSong, "number of clips per song")
+		degrade := evaluateCmd.String("degrade", strings.Join(defaultDegradations, ","), "comma-separated degradations; join with + to chain")
+		negatives := evaluateCmd.String("negatives", "", "directory of recordings that are not in the library")
+		jsonPath := evaluateCmd.String("json", "", "also write the report as JSON to this path")
+		seed := evaluateCmd.Int64("seed", defaults.Seed, "seed of clip positions and noise")
+		evaluateCmd.Parse(os.Args[2:])
+		degradations, err := shazam.ParseDegradations(*degrade)
+		if err != nil || evaluateCmd.NArg() > 1 {
+			if err != nil {
+				yellow.Println("Error:", err)
+			}
+			fmt.Println("Usage: main.go evaluate [-clip <s>] [-clips <n>] [-degrade <list>] [-negatives <dir>] [-json <path>] [-seed <n>] [library_dir]")
+			os.Exit(1)
+		}
+		libraryDir := SONGS_DIR
+		if evaluateCmd.NArg() == 1 {
+			libraryDir = evaluateCmd.Arg(0)
+		}
+		opts := defaults
+		opts.ClipSeconds = *clip
+		opts.ClipsPerSong = *clips
+		opts.Degradations = degradations
+		opts.Seed = *seed
+		evaluate(libraryDir, *negatives, *jsonPath, opts)
+	case "dedupe":
+		dedupeCmd := flag.NewFlagSet("dedupe", flag.ExitOnError)
+		defaults := shazam.DefaultDuplicateOptions()
+		remove := dedupeCmd.Bool("remove", false, "delete every song but the kept one of each cluster")
+		asJSON := dedupeCmd.Bool("json", false, "print the clusters as JSON")
+		minFraction := dedupeCmd.Float64("min-fraction", defaults.MinFraction, "fraction of a song's hashes that must align with another song")
+		minAligned := dedupeCmd.Int("min-aligned", defaults.MinAlignedHashes, "minimum number of aligned hashes")
+		keep := dedupeCmd.String("keep", string(defaults.Keep), "song kept in each cluster (longest or youtube)")
+		prefer := dedupeCmd.String("prefer", "", "comma-separated song IDs to keep whenever they are duplicated")
+		dedupeCmd.Parse(os.Args[2:])
+		preferIDs, err := parseSongIDs(*prefer)
+		if err != nil || dedupeCmd.NArg() > 0 {
+			fmt.Println("Usage: main.go dedupe [-remove] [-json] [-min-fraction <f>] [-min-aligned <n>] [-keep <longest|youtube>] [-prefer <id,id,...>]")
+			os.Exit(1)
+		}
+		opts := defaults
+		opts.MinFraction = *minFraction
+		opts.MinAlignedHashes = *minAligned
+		opts.Keep = shazam.KeepPolicy(*keep)
+		opts.Prefer = preferIDs
+		dedupe(opts, *remove, *asJSON)
+	case "fsck":
+		fsckCmd := flag.NewFlagSet("fsck", flag.ExitOnError)
+		prune := fsckCmd.Bool("prune", false, "delete orphan fingerprints, duplicate songs and songs without fingerprints")
+		refingerprint := fsckCmd.Bool("refingerprint", false, "fingerprint songs without fingerprints again from their WAV file")
+		register := fsckCmd.Bool("register", false, "index the WAV files of the songs directory that are not in the database")
+		fsckCmd.Parse(os.Args[2:])
+		if fsckCmd.NArg() > 0 {
+			fmt.Println("Usage: main.go fsck [-prune] [-refingerprint] [-register]")
+			os.Exit(1)
+		}
+		fsck(shazam.RepairOptions{Prune: *prune, Refingerprint: *refingerprint, Register: *register})
+	case "export":
+		exportCmd := flag.NewFlagSet("export", flag.ExitOnError)
+		var opts shazam.ExportOptions
+		exportCmd.Func("artist", "export the songs credited to this artist (repeatable)", func(artist string) error {
+			opts.Artists = append(opts.Artists, artist)
+			return nil
+		})
+		songs := exportCmd.String("songs", "", "comma-separated song IDs to export")
+		exportCmd.Parse(os.Args[2:])
+		songIDs, err := parseSongIDs(*songs)
+		if err != nil || exportCmd.NArg() != 1 {
+			fmt.Println("Usage: main.go export [-artist <name>]... [-songs <id,id,...>] <bundle_file>")
+			os.Exit(1)
+		}
+		opts.SongIDs = songIDs
+		exportBundle(exportCmd.Arg(0), opts)
+	case "import":
 		if len(os.Args) < 3 {
-			fmt.Println("Usage: main.go find <path_to_wav_file>")
+			fmt.Println("Usage: main.go import <bundle_file>...")
 			os.Exit(1)
 		}
-		filePath := os.Args[2]
-		find(filePath)
+		importBundle(os.Args[2:])
+	case "list", "search":
+		search := os.Args[1] == "search"
+		listCmd := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+		sortBy := listCmd.String("sort", string(db.SortByTitle), "order of the songs (title, artist or added)")
+		desc := listCmd.Bool("desc", false, "list the songs in descending order")
+		limit := listCmd.Int("limit", 0, "maximum number of songs to print (default: all)")
+		offset := listCmd.Int("offset", 0, "number of songs to skip")
+		asJSON := listCmd.Bool("json", false, "print the songs as JSON")
+		fulltext := listCmd.Bool("fulltext", false, "search for every word of the text instead of a substring")
+		listCmd.Parse(os.Args[2:])
+		if search != (listCmd.NArg() > 0) || (!search && *fulltext) {
+			fmt.Println("Usage: main.go list [-sort <title|artist|added>] [-desc] [-limit <n>] [-offset <n>] [-json]")
+			fmt.Println("       main.go search [-fulltext] [-sort <title|artist|added>] [-desc] [-limit <n>] [-offset <n>] [-json] <text>")
+			os.Exit(1)
+		}
+		mode := db.SearchSubstring
+		if *fulltext {
+			mode = db.SearchFullText
+		}
+		query := db.SongQuery{SortBy: db.SongSort(*sortBy), Descending: *desc, Offset: *offset, Limit: *limit}
+		listSongs(search, strings.Join(listCmd.Args(), " "), mode, query, *asJSON)
 	case "download":
 		if len(os.Args) < 3 {
 			fmt.Println("Usage: main.go download <spotify_url>")
@@ -86,6 +267,42 @@ func main() {
 		}
 
 		erase(SONGS_DIR, dbOnly, all)
+	case "migrate":
+		action := "status"
+		if len(os.Args) > 2 {
+			action = os.Args[2]
+		}
+		if action != "status" && action != "up" && action != "adopt-config" {
+			fmt.Println("Usage: main.go migrate [status | up | adopt-config]")
+			fmt.Println("  status       : list the schema migrations and whether each is applied (default)")
+			fmt.Println("  up           : apply the pending migrations")
+			fmt.Println("  adopt-config : record the current fingerprint config in an index built before configs were recorded")
+			os.Exit(1)
+		}
+		migrate(action)
+	case "remove":
+		removeCmd := flag.NewFlagSet("remove", flag.ExitOnError)
+		deleteFile := removeCmd.Bool("file", false, "also delete the song's WAV file from the songs directory")
+		ytID := removeCmd.String("yt", "", "remove the song with this YouTube ID")
+		title := removeCmd.String("title", "", "remove the song with this title (with -artist)")
+		artist := removeCmd.String("artist", "", "remove the song by this artist (with -title)")
+		removeCmd.Parse(os.Args[2:])
+		var refs []songRef
+		switch {
+		case *ytID != "":
+			refs = append(refs, songRef{YouTubeID: *ytID})
+		case *title != "" && *artist != "":
+			refs = append(refs, songRef{Title: *title, Artist: *artist})
+		}
+		songIDs, err := parseSongIDs(strings.Join(removeCmd.Args(), ","))
+		for _, songID := range songIDs {
+			refs = append(refs, songRef{ID: songID})
+		}
+		if err != nil || len(refs) == 0 || (*title == "") != (*artist == "") {
+			fmt.Println("Usage: main.go remove [-file] <song_id>... | -yt <youtube_id> | -title <title> -artist <artist>")
+			os.Exit(1)
+		}
+		remove(refs, *deleteFile)
 	case "save":
 		indexCmd := flag.NewFlagSet("save", flag.ExitOnError)
 		force := indexCmd.Bool("force", false, "save song with or without YouTube ID")
@@ -98,11 +315,23 @@ func main() {
 		filePath := indexCmd.Arg(0)
 		save(filePath, *force)
 	default:
-		fmt.Println("Expected 'find', 'download', 'erase', 'save', or 'serve' subcommands")
+		fmt.Println("Expected 'find', 'tracklist', 'monitor', 'visualize', 'evaluate', 'dedupe', 'fsck', 'export', 'import', 'list', 'search', 'download', 'erase', 'migrate', 'remove', 'save', or 'serve' subcommands")
 		fmt.Println("\nUsage examples:")
-		fmt.Println("  find <path_to_wav_file>")
+		fmt.Println("  find [-min-aligned <n>] [-min-zscore <z>] [-min-ratio <r>] <path_to_wav_file>")
+		fmt.Println("  tracklist [-format <text|json|cue>] [-segment <s>] [-hop <s>] <path_to_file>")
+		fmt.Println("  monitor [-save] [-window <s>] [-interval <s>] [-raw -rate <hz> -channels <n>] <url_file_or_pipe | ->")
+		fmt.Println("  visualize [-format <png|svg>] [-o <path>] [-query] [-song <id>] <path_to_file>")
+		fmt.Println("  evaluate [-clip <s>] [-clips <n>] [-degrade <list>] [-negatives <dir>] [-json <path>] [library_dir]")
+		fmt.Println("  dedupe [-remove] [-json] [-min-fraction <f>] [-keep <longest|youtube>] [-prefer <ids>]")
+		fmt.Println("  fsck [-prune] [-refingerprint] [-register]")
+		fmt.Println("  export [-artist <name>]... [-songs <ids>] <bundle_file>")
+		fmt.Println("  import <bundle_file>...")
+		fmt.Println("  list [-sort <title|artist|added>] [-desc] [-limit <n>] [-offset <n>] [-json]")
+		fmt.Println("  search [-fulltext] [-sort <title|artist|added>] [-desc] [-limit <n>] [-offset <n>] [-json] <text>")
 		fmt.Println("  download <spotify_url>")
 		fmt.Println("  erase [db | all]  (default: db)")
+		fmt.Println("  migrate [status | up | adopt-config]  (default: status)")
+		fmt.Println("  remove [-file] <song_id>... | -yt <youtube_id> | -title <title> -artist <artist>")
 		fmt.Println("  save [-f|--force] <path_to_file_or_dir>")
 		fmt.Println("  serve [-proto <http|https>] [-p <port>]")
 		os.Exit(1)
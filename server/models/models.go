@@ -5,6 +5,14 @@ type Couple struct {
 	SongID       uint32
 }
 
+// Fingerprint is one occurrence of a hash: the address of an anchor/target
+// pair and the time of its anchor. An address may occur many times in a song.
+type Fingerprint struct {
+	Address      uint32 `json:"address"`
+	AnchorTimeMs uint32 `json:"anchorTime"`
+	SongID       uint32 `json:"songID,omitempty"`
+}
+
 type RecordData struct {
 	Audio      string  `json:"audio"`
 	Duration   float64 `json:"duration"`
@@ -0,0 +1,262 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"song-recognition/db"
+	"song-recognition/models"
+	"song-recognition/utils"
+	"strings"
+	"time"
+)
+
+const (
+	bundleFormat = "song-recognition-bundle"
+	// BundleVersion is the version of the bundles ExportBundle writes.
+	// ImportBundle reads bundles up to this version.
+	BundleVersion = 1
+)
+
+// A bundle is a gzip-compressed stream of JSON lines: a bundleHeader, then a
+// bundleSong per song. It depends on no backend, so songs move between
+// SQLite and MongoDB databases.
+type bundleHeader struct {
+	Format        string            `json:"format"`
+	Version       int               `json:"version"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	Config        FingerprintConfig `json:"config"`
+	ConfigVersion string            `json:"configVersion"`
+}
+
+// bundleSong is a song with its fingerprints as [address, anchor time]
+// pairs. File paths are not exported: they only make sense on the machine
+// the song was saved on.
+type bundleSong struct {
+	ID           uint32      `json:"id"`
+	Title        string      `json:"title"`
+	Artist       string      `json:"artist"`
+	YouTubeID    string      `json:"ytID,omitempty"`
+	Album        string      `json:"album,omitempty"`
+	Artists      []string    `json:"artists,omitempty"`
+	Duration     int         `json:"duration,omitempty"`
+	SpotifyID    string      `json:"spotifyID,omitempty"`
+	ISRC         string      `json:"isrc,omitempty"`
+	Fingerprints [][2]uint32 `json:"fingerprints"`
+}
+
+// ExportOptions selects the songs ExportBundle writes. A song is exported
+// when it matches either filter; with no filter, every song is.
+type ExportOptions struct {
+	// Artists selects the songs credited to one of these artists, compared
+	// case-insensitively.
+	Artists []string
+	// SongIDs selects these songs.
+	SongIDs []uint32
+}
+
+func (opts ExportOptions) selects(song db.Song) bool {
+	if len(opts.Artists) == 0 && len(opts.SongIDs) == 0 {
+		return true
+	}
+	for _, songID := range opts.SongIDs {
+		if song.ID == songID {
+			return true
+		}
+	}
+	for _, artist := range opts.Artists {
+		if strings.EqualFold(song.Artist, artist) {
+			return true
+		}
+		for _, credited := range song.Artists {
+			if strings.EqualFold(credited, artist) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ExportBundle writes the songs selected by opts and their fingerprints to w
+// as a bundle, and returns the number of songs written. The bundle records
+// the index's fingerprint config, or the environment's for an empty index.
+func ExportBundle(ctx context.Context, dbClient db.DBClient, w io.Writer, opts ExportOptions) (int, error) {
+	cfg, _, found, err := LoadIndexConfig(ctx, dbClient)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		if cfg, err = ConfigFromEnv(); err != nil {
+			return 0, err
+		}
+	}
+
+	songIDs, err := dbClient.SongIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	registered := make(map[uint32]bool, len(songIDs))
+	for _, songID := range songIDs {
+		registered[songID] = true
+	}
+	for _, songID := range opts.SongIDs {
+		if !registered[songID] {
+			return 0, fmt.Errorf("no song with ID %d", songID)
+		}
+	}
+
+	zw := gzip.NewWriter(w)
+	encoder := json.NewEncoder(zw)
+	header := bundleHeader{
+		Format:        bundleFormat,
+		Version:       BundleVersion,
+		CreatedAt:     time.Now().UTC(),
+		Config:        cfg,
+		ConfigVersion: cfg.Version(),
+	}
+	if err := encoder.Encode(header); err != nil {
+		return 0, err
+	}
+
+	exported := 0
+	for _, songID := range songIDs {
+		song, found, err := dbClient.GetSongByID(ctx, songID)
+		if err != nil {
+			return exported, err
+		}
+		if !found || !opts.selects(song) {
+			continue
+		}
+
+		fingerprints, err := dbClient.GetSongFingerprints(ctx, songID)
+		if err != nil {
+			return exported, fmt.Errorf("error reading fingerprints of song %d: %v", songID, err)
+		}
+		entry := bundleSong{
+			ID:           song.ID,
+			Title:        song.Title,
+			Artist:       song.Artist,
+			YouTubeID:    song.YouTubeID,
+			Album:        song.Album,
+			Artists:      song.Artists,
+			Duration:     song.Duration,
+			SpotifyID:    song.SpotifyID,
+			ISRC:         song.ISRC,
+			Fingerprints: make([][2]uint32, len(fingerprints)),
+		}
+		for i, fingerprint := range fingerprints {
+			entry.Fingerprints[i] = [2]uint32{fingerprint.Address, fingerprint.AnchorTimeMs}
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return exported, err
+		}
+		exported++
+	}
+
+	return exported, zw.Close()
+}
+
+// ImportResult counts what ImportBundle added to the index.
+type ImportResult struct {
+	Imported     int
+	Fingerprints int
+	// Skipped lists the songs of the bundle whose key or YouTube ID is
+	// already in the index.
+	Skipped []db.Song
+	// SongIDs maps the IDs of the imported songs in the bundle to their new
+	// IDs in the index.
+	SongIDs map[uint32]uint32
+}
+
+// ImportBundle adds the songs of a bundle to the index under new IDs,
+// skipping those already in it. The bundle's fingerprint config must match
+// the index's; an empty index adopts it.
+func ImportBundle(ctx context.Context, dbClient db.DBClient, r io.Reader) (ImportResult, error) {
+	result := ImportResult{SongIDs: make(map[uint32]uint32)}
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return result, fmt.Errorf("not a bundle: %v", err)
+	}
+	defer zr.Close()
+	decoder := json.NewDecoder(zr)
+
+	var header bundleHeader
+	if err := decoder.Decode(&header); err != nil || header.Format != bundleFormat {
+		return result, errors.New("not a bundle: missing header")
+	}
+	if header.Version < 1 || header.Version > BundleVersion {
+		return result, fmt.Errorf("unsupported bundle version %d (this build reads up to %d)", header.Version, BundleVersion)
+	}
+	if header.Config.Version() != header.ConfigVersion {
+		return result, errors.New("corrupt bundle: config does not match its version")
+	}
+	if err := EnsureIndexConfig(ctx, dbClient, header.Config); err != nil {
+		return result, err
+	}
+
+	for {
+		var entry bundleSong
+		if err := decoder.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return result, fmt.Errorf("error reading bundle: %v", err)
+		}
+
+		song := db.Song{
+			Title:     entry.Title,
+			Artist:    entry.Artist,
+			YouTubeID: entry.YouTubeID,
+			Album:     entry.Album,
+			Artists:   entry.Artists,
+			Duration:  entry.Duration,
+			SpotifyID: entry.SpotifyID,
+			ISRC:      entry.ISRC,
+		}
+		exists, err := songExists(ctx, dbClient, song)
+		if err != nil {
+			return result, err
+		}
+		if exists {
+			song.ID = entry.ID
+			result.Skipped = append(result.Skipped, song)
+			continue
+		}
+
+		songID, err := dbClient.RegisterSong(ctx, song)
+		if err != nil {
+			return result, fmt.Errorf("error registering '%s' by '%s': %v", song.Title, song.Artist, err)
+		}
+		fingerprints := make([]models.Fingerprint, len(entry.Fingerprints))
+		for i, pair := range entry.Fingerprints {
+			fingerprints[i] = models.Fingerprint{Address: pair[0], AnchorTimeMs: pair[1], SongID: songID}
+		}
+		if err := dbClient.StoreFingerprints(ctx, fingerprints); err != nil {
+			// Do not leave a song that is never recognised.
+			return result, unregisterSong(ctx, dbClient, songID, fmt.Errorf("error storing fingerprints of '%s' by '%s': %v", song.Title, song.Artist, err))
+		}
+
+		result.Imported++
+		result.Fingerprints += len(fingerprints)
+		result.SongIDs[entry.ID] = songID
+	}
+
+	return result, nil
+}
+
+// songExists reports whether the index has a song with the key or the
+// YouTube ID of song.
+func songExists(ctx context.Context, dbClient db.DBClient, song db.Song) (bool, error) {
+	_, found, err := dbClient.GetSongByKey(ctx, utils.GenerateSongKey(song.Title, song.Artist))
+	if err != nil || found || song.YouTubeID == "" {
+		return found, err
+	}
+	_, found, err = dbClient.GetSongByYTID(ctx, song.YouTubeID)
+	return found, err
+}
@@ -0,0 +1,133 @@
+package shazam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"song-recognition/db"
+	"song-recognition/models"
+	"sort"
+	"testing"
+)
+
+func TestExportImportBundle(t *testing.T) {
+	ctx := context.Background()
+	source := db.NewMemoryClient()
+	if err := EnsureIndexConfig(ctx, source, testCfg); err != nil {
+		t.Fatal(err)
+	}
+	register := func(client db.DBClient, song db.Song, fingerprints int) uint32 {
+		t.Helper()
+		songID, err := client.RegisterSong(ctx, song)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fps := make([]models.Fingerprint, fingerprints)
+		for i := range fps {
+			fps[i] = models.Fingerprint{Address: songID ^ uint32(i), AnchorTimeMs: uint32(i * 50), SongID: songID}
+		}
+		if err := client.StoreFingerprints(ctx, fps); err != nil {
+			t.Fatal(err)
+		}
+		return songID
+	}
+
+	solo := register(source, db.Song{Title: "Solo", Artist: "Artist", Album: "Album", ISRC: "USRC17607839", FilePath: "songs/Solo - Artist.wav"}, 30)
+	feature := register(source, db.Song{Title: "Feature", Artist: "Other", Artists: []string{"Other", "artist"}}, 20)
+	existing := register(source, db.Song{Title: "Existing", Artist: "Artist", YouTubeID: "yt"}, 10)
+	picked := register(source, db.Song{Title: "Picked", Artist: "Someone"}, 5)
+	register(source, db.Song{Title: "Left out", Artist: "Someone"}, 5)
+
+	var bundle bytes.Buffer
+	exported, err := ExportBundle(ctx, source, &bundle, ExportOptions{Artists: []string{"ARTIST"}, SongIDs: []uint32{picked}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported != 4 {
+		t.Fatalf("exported %d songs, want 4", exported)
+	}
+	if _, err := ExportBundle(ctx, source, &bytes.Buffer{}, ExportOptions{SongIDs: []uint32{picked + 1}}); err == nil {
+		t.Error("exported an unknown song")
+	}
+
+	// Import into another backend that already has one of the songs under
+	// another title.
+	target, err := db.NewSQLiteClient(filepath.Join(t.TempDir(), "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	if err := EnsureIndexConfig(ctx, target, testCfg); err != nil {
+		t.Fatal(err)
+	}
+	register(target, db.Song{Title: "Existing (Remaster)", Artist: "Artist", YouTubeID: "yt"}, 10)
+
+	result, err := ImportBundle(ctx, target, bytes.NewReader(bundle.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 3 || result.Fingerprints != 55 {
+		t.Errorf("imported %d songs and %d fingerprints, want 3 and 55", result.Imported, result.Fingerprints)
+	}
+	if len(result.Skipped) != 1 || result.Skipped[0].ID != existing {
+		t.Errorf("skipped %+v, want song %d", result.Skipped, existing)
+	}
+	if _, ok := result.SongIDs[existing]; ok || len(result.SongIDs) != 3 {
+		t.Errorf("song IDs = %v", result.SongIDs)
+	}
+
+	for _, songID := range []uint32{solo, feature, picked} {
+		want, _, _ := source.GetSongByID(ctx, songID)
+		got, found, err := target.GetSongByID(ctx, result.SongIDs[songID])
+		if err != nil || !found {
+			t.Fatalf("song %d: got %v, %v", songID, found, err)
+		}
+		if got.Title != want.Title || got.ISRC != want.ISRC || len(got.Artists) != len(want.Artists) ||
+			got.FingerprintCount != want.FingerprintCount || got.FilePath != "" {
+			t.Errorf("imported %+v from %+v", got, want)
+		}
+
+		fingerprints, err := target.GetSongFingerprints(ctx, got.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(fingerprints, func(i, j int) bool { return fingerprints[i].AnchorTimeMs < fingerprints[j].AnchorTimeMs })
+		for i, fingerprint := range fingerprints {
+			if fingerprint != (models.Fingerprint{Address: songID ^ uint32(i), AnchorTimeMs: uint32(i * 50), SongID: got.ID}) {
+				t.Fatalf("song %d: fingerprint %d is %+v", songID, i, fingerprint)
+			}
+		}
+	}
+
+	// Importing again skips every song.
+	result, err = ImportBundle(ctx, target, bytes.NewReader(bundle.Bytes()))
+	if err != nil || result.Imported != 0 || len(result.Skipped) != 4 {
+		t.Errorf("second import: %+v, %v", result, err)
+	}
+}
+
+func TestImportBundleConfigMismatch(t *testing.T) {
+	ctx := context.Background()
+	source := db.NewMemoryClient()
+	if err := EnsureIndexConfig(ctx, source, testCfg); err != nil {
+		t.Fatal(err)
+	}
+	var bundle bytes.Buffer
+	if _, err := ExportBundle(ctx, source, &bundle, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	target := db.NewMemoryClient()
+	if err := EnsureIndexConfig(ctx, target, lmxConfig(DefaultLMXPeakOptions())); err != nil {
+		t.Fatal(err)
+	}
+	var mismatch *ConfigMismatchError
+	if _, err := ImportBundle(ctx, target, &bundle); !errors.As(err, &mismatch) {
+		t.Errorf("got %v, want a config mismatch", err)
+	}
+
+	if _, err := ImportBundle(ctx, target, bytes.NewReader([]byte("not a bundle"))); err == nil {
+		t.Error("imported garbage")
+	}
+}
@@ -0,0 +1,160 @@
+package shazam
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Band is a range [Min, Max) of spectrogram bins searched by the band peak
+// picker.
+type Band struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// FingerprintConfig holds every parameter that influences the hashes produced
+// from a recording. Two fingerprints are only comparable when they were built
+// with configs of the same Version, so the config used to build an index is
+// stored alongside it.
+//
+// New fields must use `omitempty` with a zero value that reproduces the
+// previous behaviour; that keeps the version of existing configs stable.
+type FingerprintConfig struct {
+	// SampleRate is the analysis rate every input is resampled to, so songs and
+	// recordings captured at different rates share the same bins.
+	SampleRate int     `json:"sampleRate"`
+	WindowSize int     `json:"windowSize"`
+	HopSize    int     `json:"hopSize"`
+	WindowType string  `json:"windowType"` // "hanning" or "hamming"
+	MaxFreq    float64 `json:"maxFreq"`    // anti-aliasing cutoff in Hz
+
+	FreqBits       int `json:"freqBits"`
+	DeltaBits      int `json:"deltaBits"`
+	TargetZoneSize int `json:"targetZoneSize"`
+
+	Bands []Band      `json:"bands"`
+	Peaks PeakOptions `json:"peaks"`
+
+	// Hashing selects the address layout; the zero value is HashPairs.
+	Hashing HashScheme `json:"hashing,omitempty"`
+}
+
+// DefaultConfig returns the standard fingerprinting parameters.
+func DefaultConfig() FingerprintConfig {
+	return FingerprintConfig{
+		SampleRate: 11025,
+		WindowSize: 1024,
+		HopSize:    512, // 50% overlap for better time-frequency resolution
+		WindowType: "hanning",
+		MaxFreq:    5000,
+
+		FreqBits:       9,
+		DeltaBits:      14, // max ~16 seconds
+		TargetZoneSize: 5,
+
+		Bands: []Band{
+			{0, 10}, {10, 20}, {20, 40}, {40, 80}, {80, 160}, {160, 512},
+		},
+		Peaks: DefaultPeakOptions(),
+	}
+}
+
+// ConfigFromEnv returns DefaultConfig adjusted by the environment
+// (PEAK_PICKER selects the peak extractor, FINGERPRINT_HASHING the address
+// layout).
+func ConfigFromEnv() (FingerprintConfig, error) {
+	peakOpts, err := PeakOptionsFromEnv()
+	if err != nil {
+		return FingerprintConfig{}, err
+	}
+
+	hashing, err := HashSchemeFromEnv()
+	if err != nil {
+		return FingerprintConfig{}, err
+	}
+
+	cfg := DefaultConfig()
+	cfg.Peaks = peakOpts
+	cfg.Hashing = hashing
+	return cfg, nil
+}
+
+// Version returns a short, stable identifier of the config derived from its
+// canonical JSON encoding.
+func (c FingerprintConfig) Version() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		// A struct of plain values cannot fail to marshal.
+		panic(err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// Validate reports parameters that cannot produce a usable fingerprint.
+func (c FingerprintConfig) Validate() error {
+	if c.SampleRate <= 0 || c.WindowSize <= 0 || c.HopSize <= 0 {
+		return errors.New("sample rate, window size and hop size must be positive")
+	}
+	if c.MaxFreq <= 0 {
+		return errors.New("max frequency must be positive")
+	}
+	if c.WindowType != "hanning" && c.WindowType != "hamming" {
+		return fmt.Errorf("unknown window type %q", c.WindowType)
+	}
+	if c.FreqBits <= 0 || c.DeltaBits <= 0 || 2*c.FreqBits+c.DeltaBits > 32 {
+		return fmt.Errorf("address layout (%d+%d+%d bits) must fit in 32 bits", c.FreqBits, c.FreqBits, c.DeltaBits)
+	}
+	switch c.Hashing {
+	case HashPairs:
+	case HashTriplets:
+		if tripletBandBits+tripletSpanBits+2*c.FreqBits+tripletTimeBits > 32 {
+			return fmt.Errorf("triplet address layout (%d+%d+%d+%d+%d bits) must fit in 32 bits",
+				tripletBandBits, tripletSpanBits, c.FreqBits, c.FreqBits, tripletTimeBits)
+		}
+		if c.TargetZoneSize < 2 {
+			return errors.New("triplet hashing needs a target zone of at least two peaks")
+		}
+	default:
+		return fmt.Errorf("unknown hashing scheme %q", c.Hashing)
+	}
+	if c.TargetZoneSize <= 0 {
+		return errors.New("target zone size must be positive")
+	}
+	for _, band := range c.Bands {
+		if band.Min < 0 || band.Min >= band.Max || band.Max > c.WindowSize/2 {
+			return fmt.Errorf("band [%d, %d) is outside the %d spectrogram bins", band.Min, band.Max, c.WindowSize/2)
+		}
+	}
+	return nil
+}
+
+// frameDuration returns the time between two spectrogram frames in seconds.
+func (c FingerprintConfig) frameDuration() float64 {
+	return float64(c.HopSize) / float64(c.SampleRate)
+}
+
+// freqResolution returns the width of a spectrogram bin in Hz.
+func (c FingerprintConfig) freqResolution() float64 {
+	return float64(c.SampleRate) / float64(c.WindowSize)
+}
+
+// ConfigMismatchError is returned when a fingerprint built with one config
+// is used against an index built with another.
+type ConfigMismatchError struct {
+	QueryVersion string
+	IndexVersion string
+}
+
+func (e *ConfigMismatchError) Error() string {
+	if e.QueryVersion == "" {
+		return fmt.Sprintf("fingerprint has no config version, so the client is out of date; reload it (the index was built with config %s)", e.IndexVersion)
+	}
+	return fmt.Sprintf(
+		"fingerprint config %s does not match the index (built with config %s); use the same settings or rebuild the index",
+		e.QueryVersion, e.IndexVersion)
+}
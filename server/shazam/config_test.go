@@ -0,0 +1,119 @@
+package shazam
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"song-recognition/db"
+	"testing"
+)
+
+var testCfg = DefaultConfig()
+
+// lmxConfig returns the default config with the LMX picker tuned by opts.
+func lmxConfig(opts PeakOptions) FingerprintConfig {
+	cfg := DefaultConfig()
+	cfg.Peaks = opts
+	return cfg
+}
+
+func TestConfigVersion(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+
+	if DefaultConfig().Version() != DefaultConfig().Version() {
+		t.Fatal("version is not deterministic")
+	}
+
+	changed := DefaultConfig()
+	changed.TargetZoneSize++
+	if changed.Version() == DefaultConfig().Version() {
+		t.Error("changing the target zone size did not change the version")
+	}
+
+	if lmxConfig(DefaultLMXPeakOptions()).Version() == DefaultConfig().Version() {
+		t.Error("changing the peak picker did not change the version")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FreqBits, cfg.DeltaBits = 10, 14
+	if cfg.Validate() == nil {
+		t.Error("expected a 34-bit address layout to be rejected")
+	}
+
+	cfg = DefaultConfig()
+	cfg.Bands = append(cfg.Bands, Band{500, 600})
+	if cfg.Validate() == nil {
+		t.Error("expected a band beyond the spectrum to be rejected")
+	}
+}
+
+func TestIndexConfig(t *testing.T) {
+	ctx := context.Background()
+	client, err := db.NewSQLiteClient(filepath.Join(t.TempDir(), "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := CheckIndexConfig(ctx, client, "anything"); err != nil {
+		t.Fatalf("an empty index should accept any config: %v", err)
+	}
+
+	if err := EnsureIndexConfig(ctx, client, DefaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckIndexConfig(ctx, client, DefaultConfig().Version()); err != nil {
+		t.Fatalf("same config rejected: %v", err)
+	}
+
+	other := lmxConfig(DefaultLMXPeakOptions())
+	var mismatch *ConfigMismatchError
+	if err := CheckIndexConfig(ctx, client, other.Version()); !errors.As(err, &mismatch) {
+		t.Fatalf("expected a config mismatch, got %v", err)
+	}
+	if err := EnsureIndexConfig(ctx, client, other); !errors.As(err, &mismatch) {
+		t.Fatalf("expected indexing with another config to be rejected, got %v", err)
+	}
+
+	stored, version, found, err := LoadIndexConfig(ctx, client)
+	if err != nil || !found {
+		t.Fatalf("LoadIndexConfig: found=%v err=%v", found, err)
+	}
+	if version != DefaultConfig().Version() || stored.Version() != version {
+		t.Errorf("stored config version %s (recomputed %s), want %s", version, stored.Version(), DefaultConfig().Version())
+	}
+}
+
+func TestIndexConfigRefusesUnversionedSongs(t *testing.T) {
+	ctx := context.Background()
+	client := db.NewMemoryClient()
+	if _, err := client.RegisterSong(ctx, db.Song{Title: "Old", Artist: "Artist"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var unversioned *UnversionedIndexError
+	if err := EnsureIndexConfig(ctx, client, DefaultConfig()); !errors.As(err, &unversioned) || unversioned.Songs != 1 {
+		t.Fatalf("got %v, want an unversioned index", err)
+	}
+	if err := CheckIndexConfig(ctx, client, DefaultConfig().Version()); !errors.As(err, &unversioned) {
+		t.Fatalf("got %v, want an unversioned index", err)
+	}
+	if _, _, found, _ := LoadIndexConfig(ctx, client); found {
+		t.Fatal("a config was recorded")
+	}
+
+	if err := AdoptIndexConfig(ctx, client, DefaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnsureIndexConfig(ctx, client, DefaultConfig()); err != nil {
+		t.Fatalf("adopted config rejected: %v", err)
+	}
+	var mismatch *ConfigMismatchError
+	if err := AdoptIndexConfig(ctx, client, lmxConfig(DefaultLMXPeakOptions())); !errors.As(err, &mismatch) {
+		t.Errorf("got %v, want a config mismatch", err)
+	}
+}
@@ -0,0 +1,80 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"fmt"
+	"math"
+)
+
+// DecisionOptions configures when the best match is accepted. A match must
+// pass every test; otherwise the query is reported as not matching anything.
+type DecisionOptions struct {
+	// MinAlignedHashes is the smallest number of aligned hashes accepted.
+	MinAlignedHashes int
+	// MinZScore is how many standard deviations the winning offset bucket
+	// must rise above the background of the song's offset histogram.
+	MinZScore float64
+	// MinRunnerUpRatio is how many times more aligned hashes the best song
+	// needs than the second best.
+	MinRunnerUpRatio float64
+}
+
+// DefaultDecisionOptions returns thresholds that reject pure noise and
+// unrelated recordings while accepting short, noisy microphone samples.
+func DefaultDecisionOptions() DecisionOptions {
+	return DecisionOptions{
+		MinAlignedHashes: 10,
+		MinZScore:        5,
+		MinRunnerUpRatio: 1.5,
+	}
+}
+
+// Decision is the outcome of the decision stage.
+type Decision struct {
+	// Match is the accepted match, nil when NoMatch is set.
+	Match *Match
+	// NoMatch reports that the evidence was insufficient; Reason says why.
+	NoMatch bool
+	Reason  string
+}
+
+// Decide accepts or rejects the best of the ranked matches returned by
+// Recognizer.FindMatchesFGP.
+func Decide(matches []Match, opts DecisionOptions) Decision {
+	if len(matches) == 0 {
+		return Decision{NoMatch: true, Reason: "no song shares hashes with the sample"}
+	}
+
+	best := matches[0]
+	if best.AlignedHashes < opts.MinAlignedHashes {
+		return Decision{NoMatch: true, Reason: fmt.Sprintf(
+			"best candidate has %d aligned hashes, need %d", best.AlignedHashes, opts.MinAlignedHashes)}
+	}
+
+	if best.ZScore < opts.MinZScore {
+		return Decision{NoMatch: true, Reason: fmt.Sprintf(
+			"best offset peak is %.1f standard deviations above background, need %.1f", best.ZScore, opts.MinZScore)}
+	}
+
+	if len(matches) > 1 && matches[1].AlignedHashes > 0 {
+		ratio := float64(best.AlignedHashes) / float64(matches[1].AlignedHashes)
+		if ratio < opts.MinRunnerUpRatio {
+			return Decision{NoMatch: true, Reason: fmt.Sprintf(
+				"best candidate is only %.2f× the runner-up, need %.2f×", ratio, opts.MinRunnerUpRatio)}
+		}
+	}
+
+	return Decision{Match: &best}
+}
+
+// offsetZScore compares the winning offset bucket with the background of the
+// song's offset histogram. Votes that are not aligned are modelled as spread
+// uniformly over the buckets spanned by all offsets, i.e. as a Poisson
+// process with mean lambda per bucket.
+func offsetZScore(aligned, total int, minOffset, maxOffset float64) float64 {
+	buckets := float64(offsetBucket(maxOffset)-offsetBucket(minOffset)) + 1
+	lambda := float64(total-aligned) / buckets
+	return (float64(aligned) - lambda) / math.Sqrt(math.Max(lambda, 1))
+}
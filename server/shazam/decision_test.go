@@ -0,0 +1,55 @@
+package shazam
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecide(t *testing.T) {
+	opts := DefaultDecisionOptions()
+	strong := Match{SongID: 1, AlignedHashes: 80, ZScore: 40}
+
+	tests := []struct {
+		name    string
+		matches []Match
+		reason  string
+	}{
+		{"empty", nil, "no song"},
+		{"too few aligned", []Match{{SongID: 1, AlignedHashes: 4, ZScore: 40}}, "aligned hashes"},
+		{"flat histogram", []Match{{SongID: 1, AlignedHashes: 30, ZScore: 2}}, "standard deviations"},
+		{"ambiguous", []Match{strong, {SongID: 2, AlignedHashes: 70, ZScore: 35}}, "runner-up"},
+		{"accepted", []Match{strong, {SongID: 2, AlignedHashes: 12, ZScore: 6}}, ""},
+		{"accepted alone", []Match{strong}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Decide(tt.matches, opts)
+			if tt.reason == "" {
+				if d.NoMatch || d.Match == nil || d.Match.SongID != strong.SongID {
+					t.Fatalf("expected song %d to be accepted, got %+v", strong.SongID, d)
+				}
+				return
+			}
+			if !d.NoMatch || d.Match != nil {
+				t.Fatalf("expected no match, got %+v", d)
+			}
+			if !strings.Contains(d.Reason, tt.reason) {
+				t.Errorf("reason %q does not mention %q", d.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestOffsetZScore(t *testing.T) {
+	// 50 aligned votes over a background of 100 votes spread across 100
+	// buckets stand far above the noise.
+	if z := offsetZScore(50, 150, 0, 9999); z < 40 {
+		t.Errorf("clear peak: z = %.1f, want > 40", z)
+	}
+
+	// The same peak height is unremarkable when the background is dense.
+	if z := offsetZScore(50, 5050, 0, 9999); z > 1 {
+		t.Errorf("noise peak: z = %.1f, want < 1", z)
+	}
+}
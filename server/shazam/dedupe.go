@@ -0,0 +1,198 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"context"
+	"fmt"
+	"song-recognition/db"
+	"sort"
+)
+
+// KeepPolicy chooses the entry of a duplicate cluster that is kept.
+type KeepPolicy string
+
+const (
+	// KeepLongest keeps the song with the most fingerprints, i.e. the longest
+	// version.
+	KeepLongest KeepPolicy = "longest"
+	// KeepYouTube keeps the longest song that has a YouTube ID, so the
+	// frontend can still play it.
+	KeepYouTube KeepPolicy = "youtube"
+)
+
+// DuplicateOptions configures FindDuplicates.
+type DuplicateOptions struct {
+	// MinFraction is the fraction of a song's hashes that must align with
+	// another song for the two to be duplicates.
+	MinFraction float64
+	// MinAlignedHashes guards short songs against chance alignments.
+	MinAlignedHashes int
+	// Keep selects the canonical entry of each cluster.
+	Keep KeepPolicy
+	// Prefer lists song IDs that are kept whenever they belong to a cluster,
+	// overriding Keep.
+	Prefer []uint32
+}
+
+// DefaultDuplicateOptions returns thresholds that group re-ingested copies,
+// remasters and radio edits of a recording.
+func DefaultDuplicateOptions() DuplicateOptions {
+	return DuplicateOptions{
+		MinFraction:      0.3,
+		MinAlignedHashes: 50,
+		Keep:             KeepLongest,
+	}
+}
+
+// DuplicateSong is a member of a duplicate cluster.
+type DuplicateSong struct {
+	SongID       uint32 `json:"songID"`
+	Title        string `json:"title"`
+	Artist       string `json:"artist"`
+	YouTubeID    string `json:"youtubeID,omitempty"`
+	Fingerprints int    `json:"fingerprints"`
+	// MatchedFraction is the largest fraction of the song's hashes aligned
+	// with another member of the cluster.
+	MatchedFraction float64 `json:"matchedFraction"`
+}
+
+// DuplicateCluster is a group of songs that are the same recording.
+type DuplicateCluster struct {
+	Canonical  DuplicateSong   `json:"canonical"`
+	Duplicates []DuplicateSong `json:"duplicates"`
+}
+
+// FindDuplicates matches the fingerprints of every indexed song against the
+// index and groups the songs sharing at least opts.MinFraction of their hashes
+// at a consistent offset. Songs related through a third one end up in the
+// same cluster.
+func FindDuplicates(ctx context.Context, dbClient db.DBClient, opts DuplicateOptions) ([]DuplicateCluster, error) {
+	if opts.Keep != KeepLongest && opts.Keep != KeepYouTube {
+		return nil, fmt.Errorf("unknown keep policy %q (expected %q or %q)", opts.Keep, KeepLongest, KeepYouTube)
+	}
+
+	indexCfg, _, _, err := LoadIndexConfig(ctx, dbClient)
+	if err != nil {
+		return nil, err
+	}
+
+	songIDs, err := dbClient.SongIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	songs := make(map[uint32]*DuplicateSong, len(songIDs))
+	parent := make(map[uint32]uint32, len(songIDs)) // union-find forest
+	var find func(uint32) uint32
+	find = func(id uint32) uint32 {
+		if parent[id] != id {
+			parent[id] = find(parent[id])
+		}
+		return parent[id]
+	}
+
+	for _, songID := range songIDs {
+		song, _, err := dbClient.GetSongByID(ctx, songID)
+		if err != nil {
+			return nil, err
+		}
+		songs[songID] = &DuplicateSong{SongID: songID, Title: song.Title, Artist: song.Artist, YouTubeID: song.YouTubeID}
+		parent[songID] = songID
+	}
+
+	for _, songID := range songIDs {
+		fingerprints, err := dbClient.GetSongFingerprints(ctx, songID)
+		if err != nil {
+			return nil, err
+		}
+		songs[songID].Fingerprints = len(fingerprints)
+		if len(fingerprints) == 0 {
+			continue
+		}
+
+		matches, err := matchFingerprints(ctx, dbClient, fingerprints, indexCfg.Hashing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to match song %d: %v", songID, err)
+		}
+
+		for _, match := range matches {
+			other, ok := songs[match.SongID]
+			if !ok || match.SongID == songID ||
+				match.MatchedFraction < opts.MinFraction || match.AlignedHashes < opts.MinAlignedHashes {
+				continue
+			}
+
+			song := songs[songID]
+			song.MatchedFraction = max(song.MatchedFraction, match.MatchedFraction)
+			parent[find(songID)] = find(other.SongID)
+		}
+	}
+
+	members := make(map[uint32][]DuplicateSong)
+	for _, songID := range songIDs {
+		root := find(songID)
+		members[root] = append(members[root], *songs[songID])
+	}
+
+	var clusters []DuplicateCluster
+	for _, group := range members {
+		if len(group) > 1 {
+			clusters = append(clusters, newDuplicateCluster(group, opts))
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		a, b := clusters[i].Canonical, clusters[j].Canonical
+		if a.Artist != b.Artist {
+			return a.Artist < b.Artist
+		}
+		return a.Title < b.Title
+	})
+
+	return clusters, nil
+}
+
+// newDuplicateCluster picks the canonical song of a group.
+func newDuplicateCluster(group []DuplicateSong, opts DuplicateOptions) DuplicateCluster {
+	preferred := make(map[uint32]bool, len(opts.Prefer))
+	for _, songID := range opts.Prefer {
+		preferred[songID] = true
+	}
+
+	rank := func(song DuplicateSong) (bool, bool, int) {
+		hasYouTube := opts.Keep == KeepYouTube && song.YouTubeID != ""
+		return preferred[song.SongID], hasYouTube, song.Fingerprints
+	}
+	sort.Slice(group, func(i, j int) bool {
+		pi, yi, ni := rank(group[i])
+		pj, yj, nj := rank(group[j])
+		switch {
+		case pi != pj:
+			return pi
+		case yi != yj:
+			return yi
+		case ni != nj:
+			return ni > nj
+		default:
+			return group[i].SongID < group[j].SongID
+		}
+	})
+
+	return DuplicateCluster{Canonical: group[0], Duplicates: group[1:]}
+}
+
+// RemoveDuplicates deletes the non-canonical songs of the clusters and their
+// fingerprints, and returns how many songs were removed.
+func RemoveDuplicates(ctx context.Context, dbClient db.DBClient, clusters []DuplicateCluster) (int, error) {
+	removed := 0
+	for _, cluster := range clusters {
+		for _, song := range cluster.Duplicates {
+			if err := dbClient.DeleteSongByID(ctx, song.SongID); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,95 @@
+package shazam
+
+import (
+	"context"
+	"math/rand"
+	"path/filepath"
+	"song-recognition/db"
+	"song-recognition/models"
+	"testing"
+)
+
+func TestFindAndRemoveDuplicates(t *testing.T) {
+	ctx := context.Background()
+	client, err := db.NewSQLiteClient(filepath.Join(t.TempDir(), "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	register := func(title string, fingerprints []models.Fingerprint) uint32 {
+		t.Helper()
+		songID, err := client.RegisterSong(ctx, db.Song{Title: title, Artist: "Artist"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range fingerprints {
+			fingerprints[i].SongID = songID
+		}
+		if err := client.StoreFingerprints(ctx, fingerprints); err != nil {
+			t.Fatal(err)
+		}
+		return songID
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	randomFingerprints := func(n int) []models.Fingerprint {
+		fps := make([]models.Fingerprint, n)
+		for i := range fps {
+			fps[i] = models.Fingerprint{Address: rng.Uint32(), AnchorTimeMs: uint32(i * 50)}
+		}
+		return fps
+	}
+
+	original := randomFingerprints(1000)
+	// The radio edit is the middle of the original, starting 10 s in.
+	var edit []models.Fingerprint
+	for _, fp := range original[200:700] {
+		edit = append(edit, models.Fingerprint{Address: fp.Address, AnchorTimeMs: fp.AnchorTimeMs - 10_000})
+	}
+
+	originalID := register("Original", append([]models.Fingerprint(nil), original...))
+	editID := register("Radio Edit", edit)
+	otherID := register("Other", randomFingerprints(800))
+
+	clusters, err := FindDuplicates(ctx, client, DefaultDuplicateOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 {
+		t.Fatalf("got %d clusters, want 1: %+v", len(clusters), clusters)
+	}
+	cluster := clusters[0]
+	if cluster.Canonical.SongID != originalID || len(cluster.Duplicates) != 1 || cluster.Duplicates[0].SongID != editID {
+		t.Fatalf("cluster = %+v, want the original kept and the edit removed", cluster)
+	}
+	if f := cluster.Duplicates[0].MatchedFraction; f < 0.99 {
+		t.Errorf("edit matched fraction = %.2f, want ~1", f)
+	}
+
+	// Preferring the edit makes it the canonical entry.
+	opts := DefaultDuplicateOptions()
+	opts.Prefer = []uint32{editID}
+	preferred, err := FindDuplicates(ctx, client, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preferred[0].Canonical.SongID != editID {
+		t.Errorf("canonical = %d, want the preferred edit %d", preferred[0].Canonical.SongID, editID)
+	}
+
+	removed, err := RemoveDuplicates(ctx, client, clusters)
+	if err != nil || removed != 1 {
+		t.Fatalf("removed %d songs (%v), want 1", removed, err)
+	}
+	songIDs, err := client.SongIDs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(songIDs) != 2 || (songIDs[0] != originalID && songIDs[1] != originalID) || (songIDs[0] != otherID && songIDs[1] != otherID) {
+		t.Errorf("songs left = %v, want %d and %d", songIDs, originalID, otherID)
+	}
+	if fps, err := client.GetSongFingerprints(ctx, editID); err != nil || len(fps) != 0 {
+		t.Errorf("edit still has %d fingerprints (%v)", len(fps), err)
+	}
+}
@@ -0,0 +1,225 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"song-recognition/wav"
+	"strconv"
+	"strings"
+)
+
+// Degradation distorts a clip the way a recording captured in the wild might
+// be distorted.
+type Degradation struct {
+	Name  string
+	apply func(clip []float64, sampleRate int, rng *rand.Rand) ([]float64, error)
+}
+
+// Apply returns a degraded copy of clip.
+func (d Degradation) Apply(clip []float64, sampleRate int, rng *rand.Rand) ([]float64, error) {
+	return d.apply(append([]float64(nil), clip...), sampleRate, rng)
+}
+
+// Clean leaves clips untouched, as a baseline.
+func Clean() Degradation {
+	return Degradation{"clean", func(clip []float64, _ int, _ *rand.Rand) ([]float64, error) {
+		return clip, nil
+	}}
+}
+
+// Noise adds white Gaussian noise at snrDB below the level of the clip.
+func Noise(snrDB float64) Degradation {
+	return Degradation{fmt.Sprintf("noise:%g", snrDB), func(clip []float64, _ int, rng *rand.Rand) ([]float64, error) {
+		sigma := rms(clip) / math.Pow(10, snrDB/20)
+		for i := range clip {
+			clip[i] += sigma * rng.NormFloat64()
+		}
+		return clip, nil
+	}}
+}
+
+// LowPass removes the content above cutoffHz with a 24 dB/octave filter, like
+// a cheap speaker or microphone. The cutoff must be below half the sample
+// rate of the clip.
+func LowPass(cutoffHz float64) Degradation {
+	return Degradation{fmt.Sprintf("lowpass:%g", cutoffHz), func(clip []float64, sampleRate int, _ *rand.Rand) ([]float64, error) {
+		if cutoffHz <= 0 || cutoffHz >= float64(sampleRate)/2 {
+			return nil, fmt.Errorf("low-pass cutoff must be between 0 and %d Hz", sampleRate/2)
+		}
+		f := newBiquad(false, cutoffHz, sampleRate)
+		return f.filter(f.filter(clip)), nil
+	}}
+}
+
+// PhoneBand keeps the 300–3400 Hz band of a telephone line.
+func PhoneBand() Degradation {
+	return Degradation{"phone", func(clip []float64, sampleRate int, _ *rand.Rand) ([]float64, error) {
+		high := newBiquad(true, 300, sampleRate)
+		low := newBiquad(false, 3400, sampleRate)
+		return low.filter(low.filter(high.filter(high.filter(clip)))), nil
+	}}
+}
+
+// Gain changes the level of the clip by db decibels. Samples are clipped to
+// [-1, 1], so large positive gains also distort.
+func Gain(db float64) Degradation {
+	return Degradation{fmt.Sprintf("gain:%g", db), func(clip []float64, _ int, _ *rand.Rand) ([]float64, error) {
+		factor := math.Pow(10, db/20)
+		for i := range clip {
+			clip[i] = math.Max(-1, math.Min(1, clip[i]*factor))
+		}
+		return clip, nil
+	}}
+}
+
+// Speed plays the clip factor times faster, raising its pitch as well, like a
+// sped-up radio edit.
+func Speed(factor float64) Degradation {
+	return Degradation{fmt.Sprintf("speed:%g", factor), func(clip []float64, sampleRate int, _ *rand.Rand) ([]float64, error) {
+		if factor <= 0 {
+			return nil, fmt.Errorf("speed factor must be positive")
+		}
+		return Resample(clip, int(math.Round(float64(sampleRate)*factor)), sampleRate, 0)
+	}}
+}
+
+// Reencode passes the clip through a lossy codec ("mp3" or "opus") at
+// bitrateKbps with ffmpeg.
+func Reencode(codec string, bitrateKbps int) Degradation {
+	return Degradation{fmt.Sprintf("%s:%d", codec, bitrateKbps), func(clip []float64, sampleRate int, _ *rand.Rand) ([]float64, error) {
+		return wav.Reencode(clip, sampleRate, codec, bitrateKbps)
+	}}
+}
+
+// Chain applies degradations one after the other.
+func Chain(degradations ...Degradation) Degradation {
+	names := make([]string, len(degradations))
+	for i, d := range degradations {
+		names[i] = d.Name
+	}
+
+	return Degradation{strings.Join(names, "+"), func(clip []float64, sampleRate int, rng *rand.Rand) ([]float64, error) {
+		var err error
+		for _, d := range degradations {
+			if clip, err = d.apply(clip, sampleRate, rng); err != nil {
+				return nil, fmt.Errorf("%s: %v", d.Name, err)
+			}
+		}
+		return clip, nil
+	}}
+}
+
+// DefaultDegradations returns a baseline and one degradation of each kind.
+func DefaultDegradations() []Degradation {
+	return []Degradation{
+		Clean(),
+		Noise(10), Noise(0),
+		LowPass(2000), PhoneBand(),
+		Gain(-20), Gain(12),
+		Speed(1.03),
+		Reencode("mp3", 64), Reencode("opus", 24),
+	}
+}
+
+// ParseDegradations parses a comma-separated list of degradations: "clean",
+// "noise:<snr dB>", "lowpass:<Hz>", "phone", "gain:<dB>", "speed:<factor>",
+// "mp3:<kbps>" or "opus:<kbps>". Degradations joined with "+" are chained,
+// e.g. "phone+noise:10".
+func ParseDegradations(list string) ([]Degradation, error) {
+	var degradations []Degradation
+	for _, spec := range strings.Split(list, ",") {
+		var chain []Degradation
+		for _, part := range strings.Split(strings.TrimSpace(spec), "+") {
+			d, err := parseDegradation(part)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, d)
+		}
+		if len(chain) == 1 {
+			degradations = append(degradations, chain[0])
+		} else {
+			degradations = append(degradations, Chain(chain...))
+		}
+	}
+	return degradations, nil
+}
+
+func parseDegradation(spec string) (Degradation, error) {
+	kind, arg, hasArg := strings.Cut(spec, ":")
+
+	switch kind {
+	case "clean":
+		return Clean(), nil
+	case "phone":
+		return PhoneBand(), nil
+	}
+
+	if !hasArg {
+		return Degradation{}, fmt.Errorf("unknown degradation %q", spec)
+	}
+	value, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return Degradation{}, fmt.Errorf("invalid value in degradation %q", spec)
+	}
+
+	switch kind {
+	case "noise":
+		return Noise(value), nil
+	case "lowpass":
+		if value <= 0 {
+			return Degradation{}, fmt.Errorf("low-pass cutoff must be positive in %q", spec)
+		}
+		return LowPass(value), nil
+	case "gain":
+		return Gain(value), nil
+	case "speed":
+		if value <= 0 {
+			return Degradation{}, fmt.Errorf("speed factor must be positive in %q", spec)
+		}
+		return Speed(value), nil
+	case "mp3", "opus":
+		return Reencode(kind, int(value)), nil
+	default:
+		return Degradation{}, fmt.Errorf("unknown degradation %q", spec)
+	}
+}
+
+// biquad is a second-order Butterworth section (RBJ audio EQ cookbook).
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+}
+
+func newBiquad(highPass bool, cutoffHz float64, sampleRate int) biquad {
+	w0 := 2 * math.Pi * cutoffHz / float64(sampleRate)
+	alpha := math.Sin(w0) / math.Sqrt2
+	cosW0 := math.Cos(w0)
+	a0 := 1 + alpha
+
+	f := biquad{a1: -2 * cosW0 / a0, a2: (1 - alpha) / a0}
+	if highPass {
+		f.b0 = (1 + cosW0) / 2 / a0
+		f.b1 = -(1 + cosW0) / a0
+	} else {
+		f.b0 = (1 - cosW0) / 2 / a0
+		f.b1 = (1 - cosW0) / a0
+	}
+	f.b2 = f.b0
+	return f
+}
+
+// filter returns x filtered from silence.
+func (f biquad) filter(x []float64) []float64 {
+	y := make([]float64, len(x))
+	var x1, x2, y1, y2 float64
+	for i, v := range x {
+		y[i] = f.b0*v + f.b1*x1 + f.b2*x2 - f.a1*y1 - f.a2*y2
+		x2, x1 = x1, v
+		y2, y1 = y1, y[i]
+	}
+	return y
+}
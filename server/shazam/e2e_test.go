@@ -0,0 +1,193 @@
+package shazam_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"song-recognition/db"
+	"song-recognition/shazam"
+	"song-recognition/synth"
+	"song-recognition/wav"
+	"testing"
+)
+
+const (
+	e2eSampleRate  = 44100
+	e2eSongSeconds = 60
+	e2eLibrarySize = 5
+)
+
+// e2eSongs caches synthetic songs by seed.
+var e2eSongs = map[int64][]float64{}
+
+func e2eSong(seed int64) []float64 {
+	if song, ok := e2eSongs[seed]; ok {
+		return song
+	}
+	song := synth.Song(seed, e2eSongSeconds, e2eSampleRate)
+	e2eSongs[seed] = song
+	return song
+}
+
+// indexLibrary writes synthetic songs to WAV files, reads them back and
+// indexes them into a fresh SQLite database. It returns the database and the
+// title of each song by seed.
+func indexLibrary(t *testing.T, cfg shazam.FingerprintConfig) (db.DBClient, map[int64]string) {
+	t.Helper()
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	client, err := db.NewSQLiteClient(filepath.Join(dir, "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	indexer := shazam.NewIndexer(client, cfg)
+	titles := make(map[int64]string)
+	for seed := int64(1); seed <= e2eLibrarySize; seed++ {
+		title := fmt.Sprintf("Song %d", seed)
+		path := filepath.Join(dir, title+".wav")
+		if err := synth.WriteWAV(path, e2eSong(seed), e2eSampleRate); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := wav.ReadWavInfo(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := indexer.IndexSamples(ctx, info.LeftChannelSamples, info.SampleRate, db.Song{Title: title, Artist: "Synth", FilePath: path}); err != nil {
+			t.Fatal(err)
+		}
+		titles[seed] = title
+	}
+
+	return client, titles
+}
+
+func TestEndToEndRecognition(t *testing.T) {
+	if testing.Short() {
+		t.Skip("indexes a synthetic library")
+	}
+	ctx := context.Background()
+
+	cfg := shazam.DefaultConfig()
+	client, titles := indexLibrary(t, cfg)
+	recognizer := shazam.NewRecognizer(client, cfg, shazam.DefaultRecognizerOptions())
+
+	clip := func(seed int64, start, seconds float64) []float64 {
+		first := int(start * e2eSampleRate)
+		return append([]float64(nil), e2eSong(seed)[first:first+int(seconds*e2eSampleRate)]...)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		seed    int64
+		startS  float64
+		samples []float64
+	}{
+		{"clean", 2, 12.5, clip(2, 12.5, 8)},
+		{"short", 4, 40, clip(4, 40, 4)},
+		{"noise bed", 1, 30, synth.Mix(clip(1, 30, 8), synth.PinkNoise(9, 8, 0.3, e2eSampleRate))},
+		{"quiet", 5, 3.2, synth.Normalize(clip(5, 3.2, 8), 0.02)},
+		{"low rate", 3, 20, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			samples, sampleRate := tc.samples, e2eSampleRate
+			if samples == nil {
+				// Resampled input must land on the same fingerprints.
+				var err error
+				samples, err = shazam.Resample(clip(tc.seed, tc.startS, 8), e2eSampleRate, 22050, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sampleRate = 22050
+			}
+
+			matches, _, err := recognizer.FindMatches(ctx, samples, sampleRate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decision := recognizer.Decide(matches)
+			if decision.NoMatch {
+				t.Fatalf("no match: %s", decision.Reason)
+			}
+			if got := decision.Match.SongTitle; got != titles[tc.seed] {
+				t.Fatalf("recognised %q, want %q", got, titles[tc.seed])
+			}
+			if got := decision.Match.Duration; got != e2eSongSeconds {
+				t.Errorf("duration = %d s, want %d", got, e2eSongSeconds)
+			}
+			if offset := decision.Match.OffsetMs; math.Abs(offset-tc.startS*1000) > 100 {
+				t.Errorf("offset = %.0f ms, want %.0f", offset, tc.startS*1000)
+			}
+		})
+	}
+
+	t.Run("not in library", func(t *testing.T) {
+		matches, _, err := recognizer.FindMatches(ctx, clip(99, 10, 8), e2eSampleRate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decision := recognizer.Decide(matches); !decision.NoMatch {
+			t.Errorf("recognised %q in a song that is not indexed", decision.Match.SongTitle)
+		}
+	})
+}
+
+func TestEndToEndTripletRecognition(t *testing.T) {
+	if testing.Short() {
+		t.Skip("indexes a synthetic library")
+	}
+	ctx := context.Background()
+
+	cfg := shazam.DefaultConfig()
+	cfg.Hashing = shazam.HashTriplets
+	client, titles := indexLibrary(t, cfg)
+	recognizer := shazam.NewRecognizer(client, cfg, shazam.DefaultRecognizerOptions())
+	margin := shazam.DefaultDecisionOptions().MinRunnerUpRatio
+
+	for _, tc := range []struct {
+		seed   int64
+		startS float64
+		speed  float64
+	}{
+		{2, 12.5, 1},
+		{4, 30, 1.04},
+		{1, 20, 0.96},
+		// At 8% about 40% of the addresses change bucket.
+		{3, 15, 1.08},
+		{5, 25, 0.92},
+	} {
+		t.Run(fmt.Sprintf("song %d at %gx", tc.seed, tc.speed), func(t *testing.T) {
+			first := int(tc.startS * e2eSampleRate)
+			clip := e2eSong(tc.seed)[first : first+10*e2eSampleRate]
+			samples, err := shazam.Speed(tc.speed).Apply(clip, e2eSampleRate, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			matches, _, err := recognizer.FindMatches(ctx, samples, e2eSampleRate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decision := recognizer.Decide(matches)
+			if decision.NoMatch {
+				t.Fatalf("no match: %s", decision.Reason)
+			}
+			if got := decision.Match.SongTitle; got != titles[tc.seed] {
+				t.Fatalf("recognised %q, want %q", got, titles[tc.seed])
+			}
+			if len(matches) > 1 {
+				ratio := float64(matches[0].AlignedHashes) / float64(max(matches[1].AlignedHashes, 1))
+				if ratio < margin {
+					t.Errorf("best song is %.2fx the runner-up, want at least %.2fx", ratio, margin)
+				}
+			}
+			if got := decision.Match.SpeedFactor; math.Abs(got-tc.speed) > 0.02 {
+				t.Errorf("speed = %.4f, want %g", got, tc.speed)
+			}
+		})
+	}
+}
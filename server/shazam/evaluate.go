@@ -0,0 +1,266 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"song-recognition/utils"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// EvaluationOptions configures an Evaluator.
+type EvaluationOptions struct {
+	// ClipSeconds is the length of the clips cut from every song.
+	ClipSeconds float64
+	// ClipsPerSong is the number of clips cut from every song.
+	ClipsPerSong int
+	// Degradations are applied to every clip in turn.
+	Degradations []Degradation
+	// Decision is applied to the matches of every clip.
+	Decision DecisionOptions
+	// Seed makes clip positions and noise reproducible.
+	Seed int64
+}
+
+// DefaultEvaluationOptions returns three 5 s clips per song, queried clean and
+// under every default degradation.
+func DefaultEvaluationOptions() EvaluationOptions {
+	return EvaluationOptions{
+		ClipSeconds:  5,
+		ClipsPerSong: 3,
+		Degradations: DefaultDegradations(),
+		Decision:     DefaultDecisionOptions(),
+		Seed:         1,
+	}
+}
+
+// EvaluationReport summarises the accuracy of recognition under each
+// degradation.
+type EvaluationReport struct {
+	ClipSeconds float64         `json:"clipSeconds"`
+	Songs       int             `json:"songs"`
+	Negatives   int             `json:"negatives"`
+	Results     []EvaluationRow `json:"results"`
+}
+
+// EvaluationRow holds the results of one degradation.
+type EvaluationRow struct {
+	Degradation string `json:"degradation"`
+	Clips       int    `json:"clips"` // library clips queried
+	// Top1 is the fraction of library clips for which the decision accepted
+	// the right song.
+	Top1 float64 `json:"top1"`
+	// Top5 is the fraction of library clips whose song is among the first
+	// five matches, accepted or not.
+	Top5 float64 `json:"top5"`
+	// WrongMatchRate is the fraction of library clips for which the decision
+	// accepted another song.
+	WrongMatchRate float64 `json:"wrongMatchRate"`
+	NegativeClips  int     `json:"negativeClips"` // non-library clips queried
+	// FalsePositiveRate is the fraction of non-library clips for which the
+	// decision accepted a song.
+	FalsePositiveRate float64 `json:"falsePositiveRate"`
+	// Query latency percentiles, in milliseconds.
+	LatencyP50Ms float64 `json:"latencyP50Ms"`
+	LatencyP90Ms float64 `json:"latencyP90Ms"`
+	LatencyP99Ms float64 `json:"latencyP99Ms"`
+}
+
+// evaluationStats accumulates the results of one degradation.
+type evaluationStats struct {
+	clips, top1, top5, wrong int
+	negatives, falsePositive int
+	latencies                []time.Duration
+}
+
+// Evaluator measures recognition accuracy on the indexed library. Songs are
+// added one at a time so only one is held in memory; clips are cut from them
+// at random, degraded and queried, and the expected answer is compared with
+// the matches.
+type Evaluator struct {
+	opts      EvaluationOptions
+	rng       *rand.Rand
+	stats     []evaluationStats
+	songs     int
+	negatives int
+
+	// query looks up a clip with the recognizer, unless replaced in tests.
+	query func(ctx context.Context, samples []float64, sampleRate int) ([]Match, error)
+}
+
+// NewEvaluator returns an evaluator querying recognizer. Matches are judged
+// with opts.Decision; the recognizer must return at least five matches for
+// top-5 accuracy to be meaningful.
+func NewEvaluator(recognizer *Recognizer, opts EvaluationOptions) (*Evaluator, error) {
+	if opts.ClipSeconds <= 0 || opts.ClipsPerSong <= 0 {
+		return nil, errors.New("clip length and clips per song must be positive")
+	}
+	if len(opts.Degradations) == 0 {
+		return nil, errors.New("no degradations to evaluate")
+	}
+
+	return &Evaluator{
+		opts:  opts,
+		rng:   rand.New(rand.NewSource(opts.Seed)),
+		stats: make([]evaluationStats, len(opts.Degradations)),
+		query: func(ctx context.Context, samples []float64, sampleRate int) ([]Match, error) {
+			matches, _, err := recognizer.FindMatches(ctx, samples, sampleRate)
+			return matches, err
+		},
+	}, nil
+}
+
+// AddSong evaluates clips of a library song, which the index knows by title
+// and artist.
+func (e *Evaluator) AddSong(ctx context.Context, title, artist string, samples []float64, sampleRate int) error {
+	e.songs++
+	return e.evaluate(ctx, utils.GenerateSongKey(title, artist), samples, sampleRate)
+}
+
+// AddNonLibrary evaluates clips of audio that is not in the index; every
+// accepted match is a false positive.
+func (e *Evaluator) AddNonLibrary(ctx context.Context, samples []float64, sampleRate int) error {
+	e.negatives++
+	return e.evaluate(ctx, "", samples, sampleRate)
+}
+
+// evaluate queries the clips of a recording under every degradation. The
+// same clips are used for all degradations so that they can be compared.
+func (e *Evaluator) evaluate(ctx context.Context, songKey string, samples []float64, sampleRate int) error {
+	clipLen := min(int(e.opts.ClipSeconds*float64(sampleRate)), len(samples))
+	if clipLen == 0 {
+		return errors.New("recording is empty")
+	}
+
+	for c := 0; c < e.opts.ClipsPerSong; c++ {
+		start := e.rng.Intn(len(samples) - clipLen + 1)
+		clip := samples[start : start+clipLen]
+
+		for i, degradation := range e.opts.Degradations {
+			degraded, err := degradation.Apply(clip, sampleRate, e.rng)
+			if err != nil {
+				return fmt.Errorf("failed to apply %s: %v", degradation.Name, err)
+			}
+
+			queryStart := time.Now()
+			matches, err := e.query(ctx, degraded, sampleRate)
+			if err != nil {
+				return fmt.Errorf("failed to query clip at %.1fs (%s): %v", float64(start)/float64(sampleRate), degradation.Name, err)
+			}
+			e.record(&e.stats[i], songKey, matches, time.Since(queryStart))
+		}
+	}
+
+	return nil
+}
+
+func (e *Evaluator) record(stats *evaluationStats, songKey string, matches []Match, latency time.Duration) {
+	stats.latencies = append(stats.latencies, latency)
+	decision := Decide(matches, e.opts.Decision)
+
+	if songKey == "" {
+		stats.negatives++
+		if !decision.NoMatch {
+			stats.falsePositive++
+		}
+		return
+	}
+
+	stats.clips++
+	isSong := func(m Match) bool { return utils.GenerateSongKey(m.SongTitle, m.SongArtist) == songKey }
+	if !decision.NoMatch {
+		if isSong(*decision.Match) {
+			stats.top1++
+		} else {
+			stats.wrong++
+		}
+	}
+	for _, match := range matches[:min(len(matches), 5)] {
+		if isSong(match) {
+			stats.top5++
+			break
+		}
+	}
+}
+
+// Report returns the results so far.
+func (e *Evaluator) Report() EvaluationReport {
+	report := EvaluationReport{
+		ClipSeconds: e.opts.ClipSeconds,
+		Songs:       e.songs,
+		Negatives:   e.negatives,
+		Results:     make([]EvaluationRow, len(e.stats)),
+	}
+
+	rate := func(n, total int) float64 {
+		if total == 0 {
+			return 0
+		}
+		return float64(n) / float64(total)
+	}
+
+	for i, stats := range e.stats {
+		latencies := append([]time.Duration(nil), stats.latencies...)
+		sort.Slice(latencies, func(a, b int) bool { return latencies[a] < latencies[b] })
+
+		report.Results[i] = EvaluationRow{
+			Degradation:       e.opts.Degradations[i].Name,
+			Clips:             stats.clips,
+			Top1:              rate(stats.top1, stats.clips),
+			Top5:              rate(stats.top5, stats.clips),
+			WrongMatchRate:    rate(stats.wrong, stats.clips),
+			NegativeClips:     stats.negatives,
+			FalsePositiveRate: rate(stats.falsePositive, stats.negatives),
+			LatencyP50Ms:      latencyPercentile(latencies, 0.50),
+			LatencyP90Ms:      latencyPercentile(latencies, 0.90),
+			LatencyP99Ms:      latencyPercentile(latencies, 0.99),
+		}
+	}
+
+	return report
+}
+
+// latencyPercentile returns the nearest-rank percentile p of sorted
+// latencies, in milliseconds.
+func latencyPercentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p*float64(len(sorted))+0.999999) - 1
+	rank = max(0, min(rank, len(sorted)-1))
+	return float64(sorted[rank]) / float64(time.Millisecond)
+}
+
+// WriteEvaluationTable writes the report as an aligned text table.
+func WriteEvaluationTable(w io.Writer, report EvaluationReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%d songs, %d non-library recordings, %gs clips\n\n", report.Songs, report.Negatives, report.ClipSeconds)
+	fmt.Fprintln(tw, "DEGRADATION\tCLIPS\tTOP-1\tTOP-5\tWRONG\tFALSE POS.\tP50\tP90\tP99")
+
+	for _, row := range report.Results {
+		falsePositives := "-"
+		if row.NegativeClips > 0 {
+			falsePositives = fmt.Sprintf("%.1f%%", row.FalsePositiveRate*100)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%.1f%%\t%.1f%%\t%s\t%.0fms\t%.0fms\t%.0fms\n",
+			row.Degradation, row.Clips, row.Top1*100, row.Top5*100, row.WrongMatchRate*100,
+			falsePositives, row.LatencyP50Ms, row.LatencyP90Ms, row.LatencyP99Ms)
+	}
+
+	return tw.Flush()
+}
+
+// WriteEvaluationJSON writes the report as indented JSON.
+func WriteEvaluationJSON(w io.Writer, report EvaluationReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
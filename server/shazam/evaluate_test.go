@@ -0,0 +1,134 @@
+package shazam
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDegradations(t *testing.T) {
+	const sampleRate = 22050
+	rng := rand.New(rand.NewSource(1))
+	tone := func(freq float64) []float64 { return sineWave(freq, sampleRate, 2) }
+	// steady skips the filters' start-up transient.
+	steady := func(x []float64) []float64 { return x[sampleRate/2:] }
+
+	clip := tone(440)
+	noisy, err := Noise(10).Apply(clip, sampleRate, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noise := make([]float64, len(clip))
+	for i := range clip {
+		noise[i] = noisy[i] - clip[i]
+	}
+	if snr := 20 * math.Log10(rms(clip)/rms(noise)); math.Abs(snr-10) > 0.5 {
+		t.Errorf("noise:10 gave an SNR of %.1f dB", snr)
+	}
+
+	for _, tc := range []struct {
+		degradation Degradation
+		freq        float64
+		minGain     float64
+		maxGain     float64
+	}{
+		{LowPass(1000), 200, 0.95, 1.05},
+		{LowPass(1000), 4000, 0, 0.01},
+		{PhoneBand(), 1000, 0.9, 1.1},
+		{PhoneBand(), 100, 0, 0.15},
+		{PhoneBand(), 8000, 0, 0.01},
+		{Gain(-20), 440, 0.099, 0.101},
+	} {
+		in := tone(tc.freq)
+		out, err := tc.degradation.Apply(in, sampleRate, rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gain := rms(steady(out)) / rms(steady(in)); gain < tc.minGain || gain > tc.maxGain {
+			t.Errorf("%s at %g Hz: gain %.3f, want %g–%g", tc.degradation.Name, tc.freq, gain, tc.minGain, tc.maxGain)
+		}
+	}
+
+	sped, err := Speed(1.25).Apply(clip, sampleRate, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := float64(len(clip)) / 1.25; math.Abs(float64(len(sped))-want) > 2 {
+		t.Errorf("speed:1.25 returned %d samples, want %.0f", len(sped), want)
+	}
+	if clip[100] != tone(440)[100] {
+		t.Error("Apply modified its input")
+	}
+
+	// Above the Nyquist frequency the filter is unstable.
+	if _, err := LowPass(float64(sampleRate)).Apply(clip, sampleRate, rng); err == nil {
+		t.Errorf("lowpass:%d applied to a %d Hz clip", sampleRate, sampleRate)
+	}
+}
+
+func TestParseDegradations(t *testing.T) {
+	degradations, err := ParseDegradations("clean, phone+noise:10 ,speed:0.97,mp3:64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, d := range degradations {
+		names = append(names, d.Name)
+	}
+	if got, want := strings.Join(names, ","), "clean,phone+noise:10,speed:0.97,mp3:64"; got != want {
+		t.Errorf("names = %q, want %q", got, want)
+	}
+
+	for _, list := range []string{"noise", "hiss:3", "gain:loud", "speed:0", "lowpass:-1"} {
+		if _, err := ParseDegradations(list); err == nil {
+			t.Errorf("ParseDegradations(%q) succeeded", list)
+		}
+	}
+}
+
+func TestEvaluator(t *testing.T) {
+	ctx := context.Background()
+	opts := DefaultEvaluationOptions()
+	opts.ClipsPerSong = 2
+	opts.Degradations = []Degradation{Clean(), Gain(-6)}
+	e, err := NewEvaluator(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every query confidently recognises song A.
+	e.query = func(_ context.Context, samples []float64, sampleRate int) ([]Match, error) {
+		return []Match{{SongTitle: "A", SongArtist: "X", AlignedHashes: 100, ZScore: 20, Confidence: 1}}, nil
+	}
+
+	const sampleRate = 8000
+	for _, title := range []string{"A", "B"} {
+		if err := e.AddSong(ctx, title, "X", sineWave(440, sampleRate, 20), sampleRate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.AddNonLibrary(ctx, sineWave(440, sampleRate, 3), sampleRate); err != nil {
+		t.Fatal(err)
+	}
+
+	report := e.Report()
+	if report.Songs != 2 || report.Negatives != 1 || len(report.Results) != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	for _, row := range report.Results {
+		if row.Clips != 4 || row.Top1 != 0.5 || row.Top5 != 0.5 || row.WrongMatchRate != 0.5 ||
+			row.NegativeClips != 2 || row.FalsePositiveRate != 1 {
+			t.Errorf("row = %+v", row)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteEvaluationTable(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "gain:-6") || !strings.Contains(buf.String(), "50.0%") {
+		t.Errorf("table:\n%s", buf.String())
+	}
+}
@@ -2,10 +2,14 @@ package shazam
 
 import (
 	"math"
+	"math/bits"
+	"math/cmplx"
+	"sync"
 )
 
 // FFT computes the Fast Fourier Transform (FFT) of the input data,
 // converting the signal from the time domain to the frequency domain.
+// It returns the full, two-sided spectrum of len(input) bins.
 // For better understanding, refer to this video: https://www.youtube.com/watch?v=spUNpyF58BY
 func FFT(input []float64) []complex128 {
 	complexArray := make([]complex128, len(input))
@@ -14,32 +18,247 @@ func FFT(input []float64) []complex128 {
 	}
 
 	fftResult := make([]complex128, len(complexArray))
-	copy(fftResult, complexArray)
-	return recursiveFFT(fftResult)
+	NewFFTPlan(len(input)).Transform(fftResult, complexArray)
+	return fftResult
+}
+
+// FFTPlan computes transforms of one fixed length. The twiddle factors,
+// bit-reversal permutation and Bluestein chirp are computed once per length
+// and shared between plans, so creating a plan for a length that has been
+// seen before is cheap. Power-of-two lengths use an iterative radix-2
+// transform; any other length goes through Bluestein's algorithm.
+//
+// A plan owns scratch buffers and is therefore not safe for concurrent use.
+// Create one plan per goroutine.
+type FFTPlan struct {
+	n       int
+	tables  *fftTables
+	half    *FFTPlan // n/2 plan used by RealTransform when n is even
+	scratch []complex128
+	chirp   []complex128 // Bluestein work buffer of length tables.m
+}
+
+// fftTables holds the immutable, shareable part of a plan.
+type fftTables struct {
+	n        int
+	twiddles []complex128 // e^{-2πik/n} for k in [0, n/2]
+	bitRev   []int        // bit-reversal permutation, power-of-two n only
+
+	// Bluestein (non-power-of-two n only).
+	m         int          // power-of-two convolution length >= 2n-1
+	w         []complex128 // chirp e^{-iπk²/n}
+	chirpFFT  []complex128 // FFT of the conjugate chirp filter
+	convTable *fftTables   // radix-2 tables for length m
 }
 
-func recursiveFFT(complexArray []complex128) []complex128 {
-	N := len(complexArray)
-	if N <= 1 {
-		return complexArray
+var fftTableCache sync.Map // int -> *fftTables
+
+// NewFFTPlan returns a plan for transforms of length n.
+func NewFFTPlan(n int) *FFTPlan {
+	if n < 0 {
+		n = 0
 	}
 
-	even := make([]complex128, N/2)
-	odd := make([]complex128, N/2)
-	for i := 0; i < N/2; i++ {
-		even[i] = complexArray[2*i]
-		odd[i] = complexArray[2*i+1]
+	plan := &FFTPlan{n: n, tables: getFFTTables(n)}
+	if plan.tables.convTable != nil {
+		plan.chirp = make([]complex128, plan.tables.m)
 	}
+	return plan
+}
 
-	even = recursiveFFT(even)
-	odd = recursiveFFT(odd)
+// Len returns the transform length of the plan.
+func (p *FFTPlan) Len() int {
+	return p.n
+}
 
-	fftResult := make([]complex128, N)
-	for k := 0; k < N/2; k++ {
-		t := complex(math.Cos(-2*math.Pi*float64(k)/float64(N)), math.Sin(-2*math.Pi*float64(k)/float64(N)))
-		fftResult[k] = even[k] + t*odd[k]
-		fftResult[k+N/2] = even[k] - t*odd[k]
+// Transform computes the forward DFT of src into dst. Both slices must have
+// length p.Len(); they may be the same slice.
+func (p *FFTPlan) Transform(dst, src []complex128) {
+	if len(dst) != p.n || len(src) != p.n {
+		panic("shazam: FFTPlan.Transform length mismatch")
+	}
+	if p.n == 0 {
+		return
+	}
+	if &dst[0] != &src[0] {
+		copy(dst, src)
 	}
 
-	return fftResult
+	if p.tables.convTable != nil {
+		p.bluestein(dst)
+		return
+	}
+	radix2(dst, p.tables)
+}
+
+// RealTransform computes the first n/2+1 bins of the DFT of the real signal
+// src, which are all the information the spectrum of a real signal carries.
+// dst must have length p.Len()/2+1 and src length p.Len().
+//
+// Even lengths are computed with a half-size complex transform: even and odd
+// samples are packed into the real and imaginary parts, transformed, and the
+// two interleaved spectra are separated afterwards.
+func (p *FFTPlan) RealTransform(dst []complex128, src []float64) {
+	n := p.n
+	if len(src) != n || len(dst) != n/2+1 {
+		panic("shazam: FFTPlan.RealTransform length mismatch")
+	}
+	if n == 0 {
+		return
+	}
+
+	if n%2 != 0 || n < 4 {
+		if p.scratch == nil {
+			p.scratch = make([]complex128, n)
+		}
+		for i, v := range src {
+			p.scratch[i] = complex(v, 0)
+		}
+		p.Transform(p.scratch, p.scratch)
+		copy(dst, p.scratch[:n/2+1])
+		return
+	}
+
+	half := n / 2
+	if p.half == nil {
+		p.half = NewFFTPlan(half)
+		p.scratch = make([]complex128, half)
+	}
+
+	z := p.scratch
+	for i := 0; i < half; i++ {
+		z[i] = complex(src[2*i], src[2*i+1])
+	}
+	p.half.Transform(z, z)
+
+	tw := p.tables.twiddles
+	for k := 0; k <= half; k++ {
+		zk := z[k%half]
+		zc := cmplx.Conj(z[(half-k)%half])
+
+		even := (zk + zc) * 0.5
+		odd := (zk - zc) * complex(0, -0.5)
+		dst[k] = even + tw[k]*odd
+	}
+}
+
+// radix2 performs an in-place iterative Cooley-Tukey transform.
+func radix2(data []complex128, t *fftTables) {
+	n := t.n
+	if n <= 1 {
+		return
+	}
+
+	for i, j := range t.bitRev {
+		if i < j {
+			data[i], data[j] = data[j], data[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		halfSize := size >> 1
+		step := n / size
+		for start := 0; start < n; start += size {
+			k := 0
+			for j := start; j < start+halfSize; j++ {
+				v := t.twiddles[k] * data[j+halfSize]
+				data[j+halfSize] = data[j] - v
+				data[j] += v
+				k += step
+			}
+		}
+	}
+}
+
+// bluestein computes an arbitrary-length DFT in place by expressing it as a
+// circular convolution with a chirp, evaluated with power-of-two transforms.
+func (p *FFTPlan) bluestein(data []complex128) {
+	t := p.tables
+	a := p.chirp
+
+	for k := 0; k < t.n; k++ {
+		a[k] = data[k] * t.w[k]
+	}
+	for k := t.n; k < t.m; k++ {
+		a[k] = 0
+	}
+
+	radix2(a, t.convTable)
+	for k := range a {
+		a[k] *= t.chirpFFT[k]
+	}
+
+	// Inverse transform via conjugation: ifft(x) = conj(fft(conj(x))) / m.
+	for k := range a {
+		a[k] = cmplx.Conj(a[k])
+	}
+	radix2(a, t.convTable)
+
+	scale := 1 / float64(t.m)
+	for k := 0; k < t.n; k++ {
+		data[k] = cmplx.Conj(a[k]) * complex(scale, 0) * t.w[k]
+	}
+}
+
+func getFFTTables(n int) *fftTables {
+	if cached, ok := fftTableCache.Load(n); ok {
+		return cached.(*fftTables)
+	}
+
+	t := &fftTables{n: n, twiddles: makeTwiddles(n)}
+	if isPowerOfTwo(n) || n <= 1 {
+		t.bitRev = makeBitReversal(n)
+	} else {
+		t.m = 1 << bits.Len(uint(2*n-2))
+		t.convTable = getFFTTables(t.m)
+
+		t.w = make([]complex128, n)
+		for k := 0; k < n; k++ {
+			// k² mod 2n keeps the angle small for large k.
+			kk := (k * k) % (2 * n)
+			sin, cos := math.Sincos(-math.Pi * float64(kk) / float64(n))
+			t.w[k] = complex(cos, sin)
+		}
+
+		t.chirpFFT = make([]complex128, t.m)
+		t.chirpFFT[0] = cmplx.Conj(t.w[0])
+		for k := 1; k < n; k++ {
+			t.chirpFFT[k] = cmplx.Conj(t.w[k])
+			t.chirpFFT[t.m-k] = cmplx.Conj(t.w[k])
+		}
+		radix2(t.chirpFFT, t.convTable)
+	}
+
+	actual, _ := fftTableCache.LoadOrStore(n, t)
+	return actual.(*fftTables)
+}
+
+func makeTwiddles(n int) []complex128 {
+	if n == 0 {
+		return nil
+	}
+
+	twiddles := make([]complex128, n/2+1)
+	for k := range twiddles {
+		sin, cos := math.Sincos(-2 * math.Pi * float64(k) / float64(n))
+		twiddles[k] = complex(cos, sin)
+	}
+	return twiddles
+}
+
+func makeBitReversal(n int) []int {
+	if n <= 1 {
+		return nil
+	}
+
+	shift := bits.UintSize - bits.Len(uint(n-1))
+	rev := make([]int, n)
+	for i := range rev {
+		rev[i] = int(bits.Reverse(uint(i)) >> shift)
+	}
+	return rev
+}
+
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
 }
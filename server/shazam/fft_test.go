@@ -0,0 +1,155 @@
+package shazam
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+)
+
+// recursiveFFT is the original radix-2 implementation, kept as a reference
+// the plan-based transform must agree with.
+func recursiveFFT(x []complex128) []complex128 {
+	n := len(x)
+	if n <= 1 {
+		return x
+	}
+
+	even := make([]complex128, n/2)
+	odd := make([]complex128, n/2)
+	for i := 0; i < n/2; i++ {
+		even[i] = x[2*i]
+		odd[i] = x[2*i+1]
+	}
+	even = recursiveFFT(even)
+	odd = recursiveFFT(odd)
+
+	out := make([]complex128, n)
+	for k := 0; k < n/2; k++ {
+		t := cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(n))) * odd[k]
+		out[k] = even[k] + t
+		out[k+n/2] = even[k] - t
+	}
+	return out
+}
+
+func naiveDFT(x []complex128) []complex128 {
+	n := len(x)
+	out := make([]complex128, n)
+	for k := 0; k < n; k++ {
+		var sum complex128
+		for j := 0; j < n; j++ {
+			angle := -2 * math.Pi * float64((j*k)%n) / float64(n)
+			sum += x[j] * cmplx.Exp(complex(0, angle))
+		}
+		out[k] = sum
+	}
+	return out
+}
+
+func randomSignal(n int, seed int64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = rng.Float64()*2 - 1
+	}
+	return x
+}
+
+func toComplex(x []float64) []complex128 {
+	out := make([]complex128, len(x))
+	for i, v := range x {
+		out[i] = complex(v, 0)
+	}
+	return out
+}
+
+func assertSpectraClose(t *testing.T, got, want []complex128, tol float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("length mismatch: got %d, want %d", len(got), len(want))
+	}
+	for k := range want {
+		if d := cmplx.Abs(got[k] - want[k]); d > tol*(1+cmplx.Abs(want[k])) {
+			t.Fatalf("bin %d: got %v, want %v (|diff| = %g)", k, got[k], want[k], d)
+		}
+	}
+}
+
+func TestFFTMatchesRecursiveReference(t *testing.T) {
+	for _, n := range []int{1, 2, 4, 8, 64, 1024, 4096} {
+		x := randomSignal(n, int64(n))
+		assertSpectraClose(t, FFT(x), recursiveFFT(toComplex(x)), 1e-9)
+	}
+}
+
+func TestFFTNonPowerOfTwo(t *testing.T) {
+	for _, n := range []int{3, 5, 12, 100, 441, 1000} {
+		x := randomSignal(n, int64(n))
+		assertSpectraClose(t, FFT(x), naiveDFT(toComplex(x)), 1e-9)
+	}
+}
+
+func TestRealTransformMatchesComplex(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 4, 6, 10, 100, 1024} {
+		x := randomSignal(n, int64(n)+7)
+		want := naiveDFT(toComplex(x))[:n/2+1]
+
+		got := make([]complex128, n/2+1)
+		plan := NewFFTPlan(n)
+		plan.RealTransform(got, x)
+		assertSpectraClose(t, got, want, 1e-9)
+
+		// Reusing the plan must not leak state between calls.
+		plan.RealTransform(got, x)
+		assertSpectraClose(t, got, want, 1e-9)
+	}
+}
+
+func BenchmarkRecursiveFFT1024(b *testing.B) {
+	x := toComplex(randomSignal(testCfg.WindowSize, 1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		recursiveFFT(x)
+	}
+}
+
+func BenchmarkFFTPlanTransform1024(b *testing.B) {
+	x := toComplex(randomSignal(testCfg.WindowSize, 1))
+	dst := make([]complex128, testCfg.WindowSize)
+	plan := NewFFTPlan(testCfg.WindowSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		plan.Transform(dst, x)
+	}
+}
+
+func BenchmarkFFTPlanRealTransform1024(b *testing.B) {
+	x := randomSignal(testCfg.WindowSize, 1)
+	dst := make([]complex128, testCfg.WindowSize/2+1)
+	plan := NewFFTPlan(testCfg.WindowSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		plan.RealTransform(dst, x)
+	}
+}
+
+func BenchmarkFFTPlanBluestein1000(b *testing.B) {
+	x := toComplex(randomSignal(1000, 1))
+	dst := make([]complex128, 1000)
+	plan := NewFFTPlan(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		plan.Transform(dst, x)
+	}
+}
+
+func BenchmarkSpectrogram(b *testing.B) {
+	x := randomSignal(44100*10, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Spectrogram(x, 44100, DefaultConfig()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
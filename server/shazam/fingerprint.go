@@ -3,34 +3,39 @@ package shazam
 import (
 	"fmt"
 	"song-recognition/models"
-	"song-recognition/utils"
 	"song-recognition/wav"
 )
 
-const (
-	maxFreqBits    = 9
-	maxDeltaBits   = 14
-	targetZoneSize = 5
-)
-
 // Fingerprint generates fingerprints from a list of peaks and stores them in an array.
-// Each fingerprint consists of an address and a couple.
-// The address is a hash. The couple contains the anchor time and the song ID.
-func Fingerprint(peaks []Peak, songID uint32) map[uint32]models.Couple {
-	fingerprints := map[uint32]models.Couple{}
+// Each fingerprint consists of an address, the anchor time and the song ID.
+// The address is a hash. Every occurrence is kept, so an address that repeats
+// (choruses, loops) appears once per anchor time. cfg.Hashing selects how
+// peaks are combined into addresses.
+func Fingerprint(peaks []Peak, songID uint32, cfg FingerprintConfig) []models.Fingerprint {
+	var fingerprints []models.Fingerprint
 
 	for i, anchor := range peaks {
-		for j := i + 1; j < len(peaks) && j <= i+targetZoneSize; j++ {
-			target := peaks[j]
+		targets := peaks[i+1 : min(i+1+cfg.TargetZoneSize, len(peaks))]
+		fingerprints = appendAnchorFingerprints(fingerprints, anchor, targets, songID, cfg)
+	}
+
+	return fingerprints
+}
 
-			address := createAddress(anchor, target)
-			anchorTimeMs := uint32(anchor.Time * 1000)
+// appendAnchorFingerprints appends the fingerprints of one anchor, given the
+// peaks of its target zone.
+func appendAnchorFingerprints(fingerprints []models.Fingerprint, anchor Peak, targets []Peak, songID uint32, cfg FingerprintConfig) []models.Fingerprint {
+	if cfg.Hashing == HashTriplets {
+		return appendTripletFingerprints(fingerprints, anchor, targets, songID, cfg)
+	}
 
-			fingerprints[address] = models.Couple{
-				AnchorTimeMs: anchorTimeMs,
-				SongID:       songID,
-			}
-		}
+	anchorTimeMs := uint32(anchor.Time * 1000)
+	for _, target := range targets {
+		fingerprints = append(fingerprints, models.Fingerprint{
+			Address:      createAddress(anchor, target, cfg),
+			AnchorTimeMs: anchorTimeMs,
+			SongID:       songID,
+		})
 	}
 
 	return fingerprints
@@ -40,24 +45,28 @@ func Fingerprint(peaks []Peak, songID uint32) map[uint32]models.Couple {
 // The address is a 32-bit integer where certain bits represent the frequency of
 // the anchor and target points, and other bits represent the time difference (delta time)
 // between them. This function combines these components into a single address (a hash).
-func createAddress(anchor, target Peak) uint32 {
-	anchorFreqBin := uint32(anchor.Freq / 10) // Scale down to fit in 9 bits
+func createAddress(anchor, target Peak, cfg FingerprintConfig) uint32 {
+	anchorFreqBin := uint32(anchor.Freq / 10) // Scale down to fit in cfg.FreqBits (9 bits by default)
 	targetFreqBin := uint32(target.Freq / 10)
 
 	deltaMsRaw := uint32((target.Time - anchor.Time) * 1000)
 
 	// Mask to fit within bit constraints
-	anchorFreqBits := anchorFreqBin & ((1 << maxFreqBits) - 1) // 9 bits
-	targetFreqBits := targetFreqBin & ((1 << maxFreqBits) - 1) // 9 bits
-	deltaBits := deltaMsRaw & ((1 << maxDeltaBits) - 1)        // 14 bits (max ~16 seconds)
+	freqMask := uint32(1)<<cfg.FreqBits - 1
+	deltaMask := uint32(1)<<cfg.DeltaBits - 1
+	anchorFreqBits := anchorFreqBin & freqMask
+	targetFreqBits := targetFreqBin & freqMask
+	deltaBits := deltaMsRaw & deltaMask // 14 bits by default (max ~16 seconds)
 
 	// Combine into 32-bit address
-	address := (anchorFreqBits << 23) | (targetFreqBits << 14) | deltaBits
+	address := (anchorFreqBits << (cfg.FreqBits + cfg.DeltaBits)) | (targetFreqBits << cfg.DeltaBits) | deltaBits
 
 	return address
 }
 
-func FingerprintAudio(songFilePath string, songID uint32) (map[uint32]models.Couple, error) {
+// FingerprintAudio converts the file to WAV and fingerprints every channel
+// with the parameters of cfg.
+func FingerprintAudio(songFilePath string, songID uint32, cfg FingerprintConfig) ([]models.Fingerprint, error) {
 	wavFilePath, err := wav.ConvertToWAV(songFilePath)
 	if err != nil {
 		return nil, fmt.Errorf("error converting input file to WAV: %v", err)
@@ -68,25 +77,43 @@ func FingerprintAudio(songFilePath string, songID uint32) (map[uint32]models.Cou
 		return nil, fmt.Errorf("error reading WAV info: %v", err)
 	}
 
-	fingerprint := make(map[uint32]models.Couple)
-
-	spectro, err := Spectrogram(wavInfo.LeftChannelSamples, wavInfo.SampleRate)
+	spectro, err := Spectrogram(wavInfo.LeftChannelSamples, wavInfo.SampleRate, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("error creating spectrogram: %v", err)
 	}
 
-	peaks := ExtractPeaks(spectro, wavInfo.Duration, wavInfo.SampleRate)
-	utils.ExtendMap(fingerprint, Fingerprint(peaks, songID))
+	peaks := ExtractPeaksWith(spectro, cfg)
+	fingerprint := Fingerprint(peaks, songID, cfg)
 
 	if wavInfo.Channels == 2 {
-		spectro, err = Spectrogram(wavInfo.RightChannelSamples, wavInfo.SampleRate)
+		spectro, err = Spectrogram(wavInfo.RightChannelSamples, wavInfo.SampleRate, cfg)
 		if err != nil {
 			return nil, fmt.Errorf("error creating spectrogram for right channel: %v", err)
 		}
 
-		peaks = ExtractPeaks(spectro, wavInfo.Duration, wavInfo.SampleRate)
-		utils.ExtendMap(fingerprint, Fingerprint(peaks, songID))
+		peaks = ExtractPeaksWith(spectro, cfg)
+		fingerprint = MergeFingerprints(fingerprint, Fingerprint(peaks, songID, cfg))
 	}
 
 	return fingerprint, nil
 }
+
+// MergeFingerprints appends the occurrences of src to dst, skipping the ones
+// dst already holds with the same address, anchor time and song. It is used
+// to combine the fingerprints of several channels of one recording.
+func MergeFingerprints(dst, src []models.Fingerprint) []models.Fingerprint {
+	seen := make(map[models.Fingerprint]struct{}, len(dst))
+	for _, fp := range dst {
+		seen[fp] = struct{}{}
+	}
+
+	for _, fp := range src {
+		if _, ok := seen[fp]; ok {
+			continue
+		}
+		seen[fp] = struct{}{}
+		dst = append(dst, fp)
+	}
+
+	return dst
+}
@@ -0,0 +1,44 @@
+package shazam
+
+import (
+	"song-recognition/models"
+	"testing"
+)
+
+func TestFingerprintKeepsRepeatedAddresses(t *testing.T) {
+	// The same two-note motif played twice, four seconds apart.
+	motif := []Peak{{Freq: 440, Time: 0}, {Freq: 660, Time: 0.5}}
+	var peaks []Peak
+	for _, offset := range []float64{0, 4} {
+		for _, p := range motif {
+			peaks = append(peaks, Peak{Freq: p.Freq, Time: p.Time + offset})
+		}
+	}
+
+	fingerprints := Fingerprint(peaks, 7, testCfg)
+	address := createAddress(motif[0], motif[1], testCfg)
+
+	var anchorTimes []uint32
+	for _, fp := range fingerprints {
+		if fp.SongID != 7 {
+			t.Fatalf("fingerprint has song ID %d, want 7", fp.SongID)
+		}
+		if fp.Address == address {
+			anchorTimes = append(anchorTimes, fp.AnchorTimeMs)
+		}
+	}
+
+	if len(anchorTimes) != 2 || anchorTimes[0] != 0 || anchorTimes[1] != 4000 {
+		t.Fatalf("occurrences of the motif address = %v, want [0 4000]", anchorTimes)
+	}
+}
+
+func TestMergeFingerprintsSkipsExactDuplicates(t *testing.T) {
+	left := []models.Fingerprint{{Address: 1, AnchorTimeMs: 10, SongID: 3}, {Address: 2, AnchorTimeMs: 20, SongID: 3}}
+	right := []models.Fingerprint{{Address: 1, AnchorTimeMs: 10, SongID: 3}, {Address: 1, AnchorTimeMs: 30, SongID: 3}}
+
+	merged := MergeFingerprints(left, right)
+	if len(merged) != 3 {
+		t.Fatalf("got %d fingerprints, want 3: %v", len(merged), merged)
+	}
+}
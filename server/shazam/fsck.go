@@ -0,0 +1,333 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"song-recognition/db"
+	"song-recognition/utils"
+	"song-recognition/wav"
+	"sort"
+	"strings"
+)
+
+// IndexReport lists the inconsistencies CheckIndex found between the songs,
+// their fingerprints and the songs directory.
+type IndexReport struct {
+	// SongsDir is the songs directory that was checked.
+	SongsDir string
+	// Songs is the number of registered songs.
+	Songs int
+	// OrphanFingerprints counts the fingerprints of song IDs that are not
+	// registered, by song ID. They come from songs deleted before deletion
+	// removed fingerprints, and keep voting for songs that cannot be shown.
+	OrphanFingerprints map[uint32]int
+	// EmptySongs are registered songs without fingerprints, left by an
+	// indexing run that failed halfway. They are never recognised.
+	EmptySongs []db.Song
+	// DuplicateSongs groups songs sharing a title and artist or a YouTube
+	// ID. The first song of each group is the one repairs keep: the one
+	// with the most fingerprints, then the oldest.
+	DuplicateSongs [][]db.Song
+	// MissingFiles are songs whose recorded audio file does not exist.
+	MissingFiles []db.Song
+	// ExtraFiles are WAV files in the songs directory that no song was
+	// indexed from.
+	ExtraFiles []string
+}
+
+// Clean reports whether the check found nothing to repair.
+func (r IndexReport) Clean() bool {
+	return len(r.OrphanFingerprints) == 0 && len(r.EmptySongs) == 0 && len(r.DuplicateSongs) == 0 &&
+		len(r.MissingFiles) == 0 && len(r.ExtraFiles) == 0
+}
+
+// SongFilePath returns the audio file a song was indexed from. Songs saved
+// before file paths were recorded are looked up by their file name in
+// songsDir.
+func SongFilePath(song db.Song, songsDir string) string {
+	if song.FilePath != "" {
+		return song.FilePath
+	}
+	return filepath.Join(songsDir, fmt.Sprintf("%s - %s.wav", song.Title, song.Artist))
+}
+
+// CheckIndex scans the database and songsDir for inconsistencies. The
+// FingerprintCount of the songs in the report is the number of fingerprints
+// actually stored.
+func CheckIndex(ctx context.Context, dbClient db.DBClient, songsDir string) (IndexReport, error) {
+	songIDs, err := dbClient.SongIDs(ctx)
+	if err != nil {
+		return IndexReport{}, err
+	}
+	counts, err := dbClient.FingerprintCounts(ctx)
+	if err != nil {
+		return IndexReport{}, err
+	}
+
+	report := IndexReport{SongsDir: songsDir, Songs: len(songIDs), OrphanFingerprints: make(map[uint32]int)}
+	songs := make([]db.Song, 0, len(songIDs))
+	registered := make(map[uint32]bool, len(songIDs))
+	songFiles := make(map[string]bool, len(songIDs))
+	for _, songID := range songIDs {
+		song, found, err := dbClient.GetSongByID(ctx, songID)
+		if err != nil {
+			return IndexReport{}, err
+		}
+		if !found {
+			continue // deleted while scanning
+		}
+		song.FingerprintCount = counts[songID]
+		songs = append(songs, song)
+		registered[songID] = true
+
+		if song.FingerprintCount == 0 {
+			report.EmptySongs = append(report.EmptySongs, song)
+		}
+
+		filePath := SongFilePath(song, songsDir)
+		if absPath, err := filepath.Abs(filePath); err == nil {
+			songFiles[absPath] = true
+		}
+		// Songs indexed with DELETE_SONG_FILE have no file to miss.
+		if song.FilePath != "" {
+			if _, err := os.Stat(filePath); os.IsNotExist(err) {
+				report.MissingFiles = append(report.MissingFiles, song)
+			}
+		}
+	}
+
+	for songID, count := range counts {
+		if !registered[songID] {
+			report.OrphanFingerprints[songID] = count
+		}
+	}
+
+	report.DuplicateSongs = duplicateKeys(songs)
+
+	entries, err := os.ReadDir(songsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return IndexReport{}, fmt.Errorf("error reading songs directory: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.ToLower(filepath.Ext(entry.Name())) != ".wav" {
+			continue
+		}
+		filePath := filepath.Join(songsDir, entry.Name())
+		absPath, err := filepath.Abs(filePath)
+		if err != nil {
+			return IndexReport{}, err
+		}
+		if !songFiles[absPath] {
+			report.ExtraFiles = append(report.ExtraFiles, filePath)
+		}
+	}
+
+	return report, nil
+}
+
+// duplicateKeys groups the songs sharing a key or a YouTube ID, keeper
+// first. Songs linked through a third one end up in the same group.
+func duplicateKeys(songs []db.Song) [][]db.Song {
+	parent := make([]int, len(songs)) // union-find forest over indexes
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	byKey := make(map[string]int)
+	byYTID := make(map[string]int)
+	for i, song := range songs {
+		parent[i] = i
+		key := utils.GenerateSongKey(song.Title, song.Artist)
+		if j, ok := byKey[key]; ok {
+			parent[find(i)] = find(j)
+		} else {
+			byKey[key] = i
+		}
+		if song.YouTubeID == "" {
+			continue
+		}
+		if j, ok := byYTID[song.YouTubeID]; ok {
+			parent[find(i)] = find(j)
+		} else {
+			byYTID[song.YouTubeID] = i
+		}
+	}
+
+	members := make(map[int][]db.Song)
+	for i := range songs {
+		root := find(i)
+		members[root] = append(members[root], songs[i])
+	}
+
+	var groups [][]db.Song
+	for _, group := range members {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool {
+			a, b := group[i], group[j]
+			if a.FingerprintCount != b.FingerprintCount {
+				return a.FingerprintCount > b.FingerprintCount
+			}
+			if !a.DateAdded.Equal(b.DateAdded) {
+				return a.DateAdded.Before(b.DateAdded)
+			}
+			return a.ID < b.ID
+		})
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0].ID < groups[j][0].ID })
+
+	return groups
+}
+
+// RepairOptions selects the repairs RepairIndex makes.
+type RepairOptions struct {
+	// Prune deletes orphan fingerprints, every song of a duplicate group but
+	// the first, and the songs left without fingerprints after
+	// Refingerprint.
+	Prune bool
+	// Refingerprint fingerprints songs without fingerprints again from
+	// their audio file.
+	Refingerprint bool
+	// Register indexes the extra files of the songs directory, named after
+	// their tags or their "Title - Artist.wav" file name.
+	Register bool
+}
+
+// RepairResult counts what RepairIndex changed. Errors lists the repairs
+// that failed; the others were still made.
+type RepairResult struct {
+	PrunedSongs        int
+	PrunedFingerprints int
+	Refingerprinted    int
+	Registered         int
+	Errors             []error
+}
+
+// RepairIndex fixes the inconsistencies of a report from CheckIndex.
+// Fingerprints are made with the index's config, or the environment's for an
+// empty index.
+func RepairIndex(ctx context.Context, dbClient db.DBClient, report IndexReport, opts RepairOptions) (RepairResult, error) {
+	var result RepairResult
+
+	var cfg FingerprintConfig
+	if opts.Refingerprint || opts.Register {
+		// The songs of an index without a recorded config may have been
+		// fingerprinted another way, so indexConfig refuses to add to it.
+		indexCfg, _, found, err := indexConfig(ctx, dbClient)
+		if err != nil {
+			return result, err
+		}
+		cfg = indexCfg
+		if !found {
+			if cfg, err = ConfigFromEnv(); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	pruned := make(map[uint32]bool)
+	deleteSong := func(song db.Song) {
+		if err := dbClient.DeleteSongByID(ctx, song.ID); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("error deleting song %d: %v", song.ID, err))
+			return
+		}
+		pruned[song.ID] = true
+		result.PrunedSongs++
+	}
+
+	if opts.Prune {
+		for _, group := range report.DuplicateSongs {
+			for _, song := range group[1:] {
+				deleteSong(song)
+			}
+		}
+		// DeleteSongFingerprints removes fingerprints whatever their song.
+		for songID, count := range report.OrphanFingerprints {
+			if err := dbClient.DeleteSongFingerprints(ctx, songID); err != nil {
+				result.Errors = append(result.Errors, fmt.Errorf("error deleting fingerprints of song %d: %v", songID, err))
+				continue
+			}
+			result.PrunedFingerprints += count
+		}
+	}
+
+	for _, song := range report.EmptySongs {
+		if pruned[song.ID] {
+			continue
+		}
+		if opts.Refingerprint {
+			err := refingerprint(ctx, dbClient, cfg, song, SongFilePath(song, report.SongsDir))
+			if err == nil {
+				result.Refingerprinted++
+				continue
+			}
+			result.Errors = append(result.Errors, err)
+		}
+		if opts.Prune {
+			deleteSong(song)
+		}
+	}
+
+	if opts.Register {
+		indexer := NewIndexer(dbClient, cfg)
+		for _, filePath := range report.ExtraFiles {
+			title, artist := songFileTags(filePath)
+			if artist == "" {
+				result.Errors = append(result.Errors, fmt.Errorf("no artist for %s", filePath))
+				continue
+			}
+			song := db.Song{Title: title, Artist: artist, FilePath: filePath}
+			if _, err := indexer.IndexFile(ctx, filePath, song); err != nil {
+				result.Errors = append(result.Errors, err)
+				continue
+			}
+			result.Registered++
+		}
+	}
+
+	return result, nil
+}
+
+// refingerprint stores the fingerprints of a registered song's audio file.
+func refingerprint(ctx context.Context, dbClient db.DBClient, cfg FingerprintConfig, song db.Song, filePath string) error {
+	if _, err := os.Stat(filePath); err != nil {
+		return fmt.Errorf("cannot fingerprint '%s' by '%s' again: %v", song.Title, song.Artist, err)
+	}
+	fingerprints, err := FingerprintAudio(filePath, song.ID, cfg)
+	if err != nil {
+		return fmt.Errorf("error fingerprinting '%s' by '%s': %v", song.Title, song.Artist, err)
+	}
+	if len(fingerprints) == 0 {
+		return fmt.Errorf("no fingerprints in %s", filePath)
+	}
+	return dbClient.StoreFingerprints(ctx, fingerprints)
+}
+
+// songFileTags returns the title and artist of an audio file: its tags, or
+// its "Title - Artist" file name.
+func songFileTags(filePath string) (title, artist string) {
+	name := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	if metadata, err := wav.GetMetadata(filePath); err == nil {
+		title, artist = metadata.Format.Tags["title"], metadata.Format.Tags["artist"]
+	}
+	if artist == "" {
+		if t, a, ok := strings.Cut(name, " - "); ok {
+			return strings.TrimSpace(t), strings.TrimSpace(a)
+		}
+	}
+	if title == "" {
+		title = name
+	}
+	return title, artist
+}
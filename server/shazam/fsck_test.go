@@ -0,0 +1,154 @@
+package shazam
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"song-recognition/db"
+	"song-recognition/models"
+	"song-recognition/synth"
+	"testing"
+)
+
+func TestCheckAndRepairIndex(t *testing.T) {
+	ctx := context.Background()
+	client := db.NewMemoryClient()
+	songsDir := t.TempDir()
+
+	register := func(song db.Song, fingerprints int) uint32 {
+		t.Helper()
+		songID, err := client.RegisterSong(ctx, song)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fps := make([]models.Fingerprint, fingerprints)
+		for i := range fps {
+			fps[i] = models.Fingerprint{Address: uint32(i), AnchorTimeMs: uint32(i * 50), SongID: songID}
+		}
+		if err := client.StoreFingerprints(ctx, fps); err != nil {
+			t.Fatal(err)
+		}
+		return songID
+	}
+	writeFile := func(name string) string {
+		t.Helper()
+		filePath := filepath.Join(songsDir, name)
+		if err := os.WriteFile(filePath, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filePath
+	}
+
+	healthy := register(db.Song{Title: "Healthy", Artist: "Artist", FilePath: writeFile("Healthy - Artist.wav")}, 10)
+	// Saved before file paths were recorded: found by name.
+	register(db.Song{Title: "Legacy", Artist: "Artist"}, 10)
+	writeFile("Legacy - Artist.wav")
+	empty := register(db.Song{Title: "Empty", Artist: "Artist"}, 0)
+	original := register(db.Song{Title: "Original", Artist: "Artist", YouTubeID: "yt"}, 20)
+	copied := register(db.Song{Title: "Copy", Artist: "Artist", YouTubeID: "yt"}, 5)
+	missing := register(db.Song{Title: "Missing", Artist: "Artist", FilePath: filepath.Join(songsDir, "Missing - Artist.wav")}, 10)
+	orphan := healthy + 1 // never registered
+	if err := client.StoreFingerprints(ctx, []models.Fingerprint{{Address: 1, AnchorTimeMs: 1, SongID: orphan}}); err != nil {
+		t.Fatal(err)
+	}
+	extra := writeFile("Extra - Someone.wav")
+	writeFile("notes.txt")
+
+	report, err := CheckIndex(ctx, client, songsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Songs != 6 {
+		t.Errorf("checked %d songs, want 6", report.Songs)
+	}
+	if !reflect.DeepEqual(report.OrphanFingerprints, map[uint32]int{orphan: 1}) {
+		t.Errorf("orphans = %v", report.OrphanFingerprints)
+	}
+	if len(report.EmptySongs) != 1 || report.EmptySongs[0].ID != empty {
+		t.Errorf("empty songs = %+v, want %d", report.EmptySongs, empty)
+	}
+	if len(report.DuplicateSongs) != 1 || len(report.DuplicateSongs[0]) != 2 ||
+		report.DuplicateSongs[0][0].ID != original || report.DuplicateSongs[0][1].ID != copied {
+		t.Errorf("duplicates = %+v, want %d kept over %d", report.DuplicateSongs, original, copied)
+	}
+	if len(report.MissingFiles) != 1 || report.MissingFiles[0].ID != missing {
+		t.Errorf("missing files = %+v, want %d", report.MissingFiles, missing)
+	}
+	if !reflect.DeepEqual(report.ExtraFiles, []string{extra}) {
+		t.Errorf("extra files = %v, want %s", report.ExtraFiles, extra)
+	}
+	if report.Clean() {
+		t.Error("report is clean")
+	}
+
+	result, err := RepairIndex(ctx, client, report, RepairOptions{Prune: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) != 0 || result.PrunedSongs != 2 || result.PrunedFingerprints != 1 {
+		t.Errorf("repair = %+v, want the copy and the empty song pruned", result)
+	}
+
+	report, err = CheckIndex(ctx, client, songsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.OrphanFingerprints) != 0 || len(report.EmptySongs) != 0 || len(report.DuplicateSongs) != 0 {
+		t.Errorf("after repair: %+v", report)
+	}
+	if report.Songs != 4 || len(report.MissingFiles) != 1 || len(report.ExtraFiles) != 1 {
+		t.Errorf("after repair: %+v, want the missing and extra files left alone", report)
+	}
+}
+
+// Fingerprinting files needs ffmpeg.
+func TestRepairIndexFingerprintsFiles(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg is not installed")
+	}
+	ctx := context.Background()
+
+	client := db.NewMemoryClient()
+	songsDir := t.TempDir()
+	cfg := DefaultConfig()
+	if err := EnsureIndexConfig(ctx, client, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	const sampleRate = 44100
+	emptyFile := filepath.Join(songsDir, "Empty - Artist.wav")
+	extraFile := filepath.Join(songsDir, "Extra - Someone.wav")
+	for i, filePath := range []string{emptyFile, extraFile} {
+		if err := synth.WriteWAV(filePath, synth.Song(int64(i+1), 10, sampleRate), sampleRate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	empty, err := client.RegisterSong(ctx, db.Song{Title: "Empty", Artist: "Artist", FilePath: emptyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := CheckIndex(ctx, client, songsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := RepairIndex(ctx, client, report, RepairOptions{Refingerprint: true, Register: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) != 0 || result.Refingerprinted != 1 || result.Registered != 1 {
+		t.Fatalf("repair = %+v, want one song fingerprinted and one file registered", result)
+	}
+
+	if song, _, err := client.GetSongByID(ctx, empty); err != nil || song.FingerprintCount == 0 {
+		t.Errorf("song has %d fingerprints (%v) after repair", song.FingerprintCount, err)
+	}
+	if song, found, err := client.GetSongByKey(ctx, "Extra---Someone"); err != nil || !found || song.FilePath != extraFile {
+		t.Errorf("registered song = %+v, %v, %v", song, found, err)
+	}
+	if report, err := CheckIndex(ctx, client, songsDir); err != nil || !report.Clean() {
+		t.Errorf("after repair: %+v, %v", report, err)
+	}
+}
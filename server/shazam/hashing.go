@@ -0,0 +1,132 @@
+package shazam
+
+import (
+	"fmt"
+	"math"
+	"song-recognition/models"
+	"song-recognition/utils"
+)
+
+// HashScheme selects how peaks are combined into addresses.
+type HashScheme string
+
+const (
+	// HashPairs hashes the absolute frequencies of an anchor and a target and
+	// the time between them. It is the zero value so existing configs keep
+	// their version.
+	HashPairs HashScheme = ""
+	// HashTriplets hashes an anchor and two targets by their frequency and
+	// time ratios, which do not change when a recording is sped up, slowed
+	// down or pitch-shifted.
+	HashTriplets HashScheme = "triplet"
+)
+
+const (
+	// tripletFreqSteps is the number of frequency ratio steps per octave;
+	// a semitone is coarse enough to absorb the bin rounding of the peaks.
+	tripletFreqSteps = 12
+	// tripletTimeBits is the resolution of the quantized delta-time ratio.
+	tripletTimeBits = 5
+
+	// The ratios alone take few distinct values, so the address also holds
+	// the anchor's frequency band and the span of the triplet, both in
+	// half-octave buckets. A speed change moves both by log2(speed) octaves,
+	// so a share of the triplets lands in another bucket: about 12% of the
+	// addresses are lost at 2%, 23% at 4% and 40% at 8%. Wider buckets lose
+	// fewer but tell songs apart less well, which costs more.
+	// tripletBandBits holds the anchor band, tripletBandsPerOctave wide from
+	// tripletBandBaseHz.
+	tripletBandBits       = 4
+	tripletBandsPerOctave = 2
+	tripletBandBaseHz     = 50
+	// tripletSpanBits holds the time from the anchor to the second target,
+	// tripletSpansPerOctave per doubling of the spectrogram frame duration.
+	tripletSpanBits       = 4
+	tripletSpansPerOctave = 2
+)
+
+// HashSchemeFromEnv reads FINGERPRINT_HASHING ("pair", the default, or
+// "triplet").
+func HashSchemeFromEnv() (HashScheme, error) {
+	return ParseHashScheme(utils.GetEnv("FINGERPRINT_HASHING", "pair"))
+}
+
+// ParseHashScheme converts the name of a hashing scheme to a HashScheme.
+func ParseHashScheme(name string) (HashScheme, error) {
+	switch name {
+	case "", "pair":
+		return HashPairs, nil
+	case string(HashTriplets):
+		return HashTriplets, nil
+	default:
+		return HashPairs, fmt.Errorf("unknown hashing scheme %q (expected \"pair\" or %q)", name, HashTriplets)
+	}
+}
+
+// appendTripletFingerprints combines an anchor with each pair of peaks in its
+// target zone. The anchor time is kept as-is so matching can still align the
+// sample on the song, but the address only depends on ratios.
+func appendTripletFingerprints(fingerprints []models.Fingerprint, anchor Peak, targets []Peak, songID uint32, cfg FingerprintConfig) []models.Fingerprint {
+	if anchor.Freq <= 0 {
+		return fingerprints
+	}
+
+	anchorTimeMs := uint32(anchor.Time * 1000)
+	for j := range targets {
+		for k := j + 1; k < len(targets); k++ {
+			address, ok := createTripletAddress(anchor, targets[j], targets[k], cfg)
+			if !ok {
+				continue
+			}
+
+			fingerprints = append(fingerprints, models.Fingerprint{
+				Address:      address,
+				AnchorTimeMs: anchorTimeMs,
+				SongID:       songID,
+			})
+		}
+	}
+
+	return fingerprints
+}
+
+// createTripletAddress packs the anchor's frequency band
+// (tripletBandBits), the span of the triplet (tripletSpanBits), the
+// log-frequency ratios of both targets to the anchor (cfg.FreqBits each) and
+// the ratio of their delta times (tripletTimeBits) into an address. ok is
+// false when the triplet has no defined ratios, i.e. a target at 0 Hz or both
+// targets in the anchor's frame.
+func createTripletAddress(anchor, first, second Peak, cfg FingerprintConfig) (address uint32, ok bool) {
+	firstDelta := first.Time - anchor.Time
+	secondDelta := second.Time - anchor.Time
+	if first.Freq <= 0 || second.Freq <= 0 || secondDelta <= 0 {
+		return 0, false
+	}
+
+	freqMask := uint32(1)<<cfg.FreqBits - 1
+	freqBias := int64(1) << (cfg.FreqBits - 1) // centres negative ratios in the field
+	freqRatio := func(target Peak) uint32 {
+		steps := int64(math.Round(math.Log2(target.Freq/anchor.Freq) * tripletFreqSteps))
+		return uint32(steps+freqBias) & freqMask
+	}
+
+	timeLevels := float64(uint32(1)<<tripletTimeBits - 1)
+	timeRatio := uint32(math.Round(firstDelta / secondDelta * timeLevels))
+
+	band := logBucket(anchor.Freq/tripletBandBaseHz, tripletBandsPerOctave, tripletBandBits)
+	span := logBucket(secondDelta/cfg.frameDuration(), tripletSpansPerOctave, tripletSpanBits)
+
+	address = band<<(tripletSpanBits+2*cfg.FreqBits+tripletTimeBits) |
+		span<<(2*cfg.FreqBits+tripletTimeBits) |
+		freqRatio(first)<<(cfg.FreqBits+tripletTimeBits) |
+		freqRatio(second)<<tripletTimeBits |
+		timeRatio
+	return address, true
+}
+
+// logBucket quantizes log2(x) in steps per octave into a field of the given
+// bits, clamping values outside it.
+func logBucket(x float64, steps int, bits int) uint32 {
+	bucket := math.Floor(math.Log2(x) * float64(steps))
+	return uint32(math.Max(0, math.Min(bucket, float64(uint32(1)<<bits-1))))
+}
@@ -0,0 +1,92 @@
+package shazam
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func randomPeaks(n int, seed int64) []Peak {
+	rng := rand.New(rand.NewSource(seed))
+	peaks := make([]Peak, n)
+	var t float64
+	for i := range peaks {
+		t += 0.02 + rng.Float64()*0.08
+		peaks[i] = Peak{Freq: 100 + rng.Float64()*4000, Time: t}
+	}
+	return peaks
+}
+
+func TestTripletAddressesSurviveSpeedChange(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Hashing = HashTriplets
+
+	original := randomPeaks(300, 1)
+	// Played 6% faster: everything happens sooner and sounds higher.
+	fast := make([]Peak, len(original))
+	for i, p := range original {
+		fast[i] = Peak{Freq: p.Freq * 1.06, Time: p.Time / 1.06}
+	}
+
+	shared := func(hashing HashScheme) float64 {
+		cfg.Hashing = hashing
+		index := make(map[uint32]bool)
+		for _, fp := range Fingerprint(original, 1, cfg) {
+			index[fp.Address] = true
+		}
+		query := Fingerprint(fast, 2, cfg)
+		var hits int
+		for _, fp := range query {
+			if index[fp.Address] {
+				hits++
+			}
+		}
+		return float64(hits) / float64(len(query))
+	}
+
+	// The anchor band and the span move to the next bucket for the triplets
+	// close to a bucket edge, about a third of them at 6%; the rest keep
+	// their address.
+	if f := shared(HashTriplets); f < 0.6 {
+		t.Errorf("triplets: only %.0f%% of the hashes survive a 6%% speed-up", f*100)
+	}
+	if f := shared(HashPairs); f > 0.2 {
+		t.Errorf("pairs: %.0f%% of the hashes survive a 6%% speed-up, expected few", f*100)
+	}
+}
+
+func TestAnalyzeRelativeTimingEstimatesSpeed(t *testing.T) {
+	// The sample starts 30 s into the song and plays 4% faster.
+	var pairs [][2]uint32
+	for i := 0; i < 60; i++ {
+		sampleTime := float64(i * 150)
+		pairs = append(pairs, [2]uint32{uint32(sampleTime), uint32(30000 + 1.04*sampleTime)})
+	}
+	for i := 0; i < 20; i++ {
+		pairs = append(pairs, [2]uint32{uint32(i * 400), uint32(i * 9_000)})
+	}
+	matches := map[uint32][][2]uint32{1: pairs}
+
+	score := analyzeRelativeTiming(matches, speedCandidates(HashTriplets))[1]
+	if math.Abs(score.speed-1.04) > speedStep/2 {
+		t.Errorf("speed = %.4f, want 1.04", score.speed)
+	}
+	if score.aligned != 60 || math.Abs(score.offsetMs-30000) > 5 {
+		t.Errorf("got %d aligned at %.1f ms, want 60 at ~30000", score.aligned, score.offsetMs)
+	}
+
+	if score := analyzeRelativeTiming(matches, speedCandidates(HashPairs))[1]; score.speed != 1 || score.aligned >= 60 {
+		t.Errorf("pair hashing must align at 1× only, got %d aligned at %.4f×", score.aligned, score.speed)
+	}
+}
+
+func TestTripletConfigVersion(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Hashing = HashTriplets
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Version() == DefaultConfig().Version() {
+		t.Error("triplet hashing must change the config version")
+	}
+}
@@ -5,47 +5,75 @@ import (
 	"image/color"
 	"image/png"
 	"math"
-	"math/cmplx"
 	"os"
 )
 
-// ConvertSpectrogramToImage converts a spectrogram to a heat map image
-func SpectrogramToImage(spectrogram [][]complex128, outputPath string) error {
+// spectrogramRangeDB is the dynamic range of spectrogram images: levels this
+// far below the loudest bin or further are drawn black.
+const spectrogramRangeDB = 80
+
+// heatStops is the colour map of spectrogram images, from quiet to loud.
+var heatStops = []color.RGBA{
+	{0, 0, 0, 255},
+	{40, 10, 90, 255},
+	{150, 30, 110, 255},
+	{230, 80, 50, 255},
+	{250, 180, 40, 255},
+	{255, 255, 220, 255},
+}
+
+// SpectrogramImage renders a magnitude spectrogram as a colour heat map on a
+// decibel scale, one pixel per frame and bin. Time runs from left to right and
+// frequency from bottom to top.
+func SpectrogramImage(spectrogram [][]float64) *image.RGBA {
+	if len(spectrogram) == 0 {
+		return image.NewRGBA(image.Rect(0, 0, 0, 0))
+	}
 	numWindows := len(spectrogram)
 	numFreqBins := len(spectrogram[0])
 
-	img := image.NewGray(image.Rect(0, 0, numFreqBins, numWindows))
+	img := image.NewRGBA(image.Rect(0, 0, numWindows, numFreqBins))
 
-	// Scale the values in the spectrogram to the range [0, 255]
 	maxMagnitude := 0.0
-	for i := 0; i < numWindows; i++ {
-		for j := 0; j < numFreqBins; j++ {
-			magnitude := cmplx.Abs(spectrogram[i][j])
-			if magnitude > maxMagnitude {
-				maxMagnitude = magnitude
-			}
+	for _, frame := range spectrogram {
+		for _, magnitude := range frame {
+			maxMagnitude = math.Max(maxMagnitude, magnitude)
 		}
 	}
+	if maxMagnitude == 0 {
+		maxMagnitude = 1
+	}
 
-	// Convert spectrogram values to pixel intensities
-	for i := 0; i < numWindows; i++ {
-		for j := 0; j < numFreqBins; j++ {
-			magnitude := cmplx.Abs(spectrogram[i][j])
-			intensity := uint8(math.Floor(255 * (magnitude / maxMagnitude)))
-			img.SetGray(j, i, color.Gray{Y: intensity})
+	for i, frame := range spectrogram {
+		for j, magnitude := range frame {
+			level := 20 * math.Log10(math.Max(magnitude/maxMagnitude, 1e-12))
+			img.SetRGBA(i, numFreqBins-1-j, heatColor(1+level/spectrogramRangeDB))
 		}
 	}
 
-	file, err := os.Create(outputPath)
-	if err != nil {
-		return err
+	return img
+}
+
+// heatColor maps v in [0, 1] to the colour map; values outside are clamped.
+func heatColor(v float64) color.RGBA {
+	v = math.Max(0, math.Min(1, v)) * float64(len(heatStops)-1)
+	i := min(int(v), len(heatStops)-2)
+	t := v - float64(i)
+
+	lerp := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + t*(float64(b)-float64(a))))
 	}
-	defer file.Close()
+	from, to := heatStops[i], heatStops[i+1]
+	return color.RGBA{lerp(from.R, to.R), lerp(from.G, to.G), lerp(from.B, to.B), 255}
+}
 
-	err = png.Encode(file, img)
+// SpectrogramToImage writes the heat map of a spectrogram to a PNG file.
+func SpectrogramToImage(spectrogram [][]float64, outputPath string) error {
+	file, err := os.Create(outputPath)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	return nil
+	return png.Encode(file, SpectrogramImage(spectrogram))
 }
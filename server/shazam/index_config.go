@@ -0,0 +1,133 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	configMetaKey        = "fingerprint_config"
+	configVersionMetaKey = "fingerprint_config_version"
+)
+
+// LoadIndexConfig returns the config the index was built with and its
+// version. found is false when nothing has been indexed yet.
+func LoadIndexConfig(ctx context.Context, store Store) (cfg FingerprintConfig, version string, found bool, err error) {
+	version, found, err = store.GetMeta(ctx, configVersionMetaKey)
+	if err != nil || !found {
+		return FingerprintConfig{}, "", false, err
+	}
+
+	data, _, err := store.GetMeta(ctx, configMetaKey)
+	if err != nil {
+		return FingerprintConfig{}, "", false, err
+	}
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		return FingerprintConfig{}, "", false, fmt.Errorf("failed to decode index fingerprint config: %v", err)
+	}
+
+	return cfg, version, true, nil
+}
+
+// UnversionedIndexError is returned when an index holds songs but records no
+// fingerprint config: they were fingerprinted by a build that predates
+// configs, whose fingerprints may not be comparable with this one's.
+type UnversionedIndexError struct {
+	Songs int
+}
+
+func (e *UnversionedIndexError) Error() string {
+	return fmt.Sprintf(
+		"the index holds %d songs but records no fingerprint config; re-index them, or run 'migrate adopt-config' if they were fingerprinted with the current settings",
+		e.Songs)
+}
+
+// indexConfig is LoadIndexConfig, but returns an *UnversionedIndexError for
+// an index that holds songs without a recorded config.
+func indexConfig(ctx context.Context, store Store) (cfg FingerprintConfig, version string, found bool, err error) {
+	cfg, version, found, err = LoadIndexConfig(ctx, store)
+	if err != nil || found {
+		return cfg, version, found, err
+	}
+
+	songs, err := store.TotalSongs(ctx)
+	if err != nil {
+		return FingerprintConfig{}, "", false, err
+	}
+	if songs > 0 {
+		return FingerprintConfig{}, "", false, &UnversionedIndexError{Songs: songs}
+	}
+	return FingerprintConfig{}, "", false, nil
+}
+
+// EnsureIndexConfig records cfg as the config of an empty index, or checks
+// that it matches the one the index was built with. Call it before storing
+// fingerprints.
+func EnsureIndexConfig(ctx context.Context, store Store, cfg FingerprintConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid fingerprint config: %v", err)
+	}
+
+	_, version, found, err := indexConfig(ctx, store)
+	if err != nil {
+		return err
+	}
+	if found {
+		if version != cfg.Version() {
+			return &ConfigMismatchError{QueryVersion: cfg.Version(), IndexVersion: version}
+		}
+		return nil
+	}
+	return recordIndexConfig(ctx, store, cfg)
+}
+
+// AdoptIndexConfig records cfg as the config of an index that holds songs but
+// no config, vouching that they were fingerprinted with it. It checks cfg
+// against an index that already records a config, like EnsureIndexConfig.
+func AdoptIndexConfig(ctx context.Context, store Store, cfg FingerprintConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid fingerprint config: %v", err)
+	}
+
+	_, version, found, err := LoadIndexConfig(ctx, store)
+	if err != nil {
+		return err
+	}
+	if found {
+		if version != cfg.Version() {
+			return &ConfigMismatchError{QueryVersion: cfg.Version(), IndexVersion: version}
+		}
+		return nil
+	}
+	return recordIndexConfig(ctx, store, cfg)
+}
+
+func recordIndexConfig(ctx context.Context, store Store, cfg FingerprintConfig) error {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := store.SetMeta(ctx, configMetaKey, string(data)); err != nil {
+		return err
+	}
+	return store.SetMeta(ctx, configVersionMetaKey, cfg.Version())
+}
+
+// CheckIndexConfig returns a *ConfigMismatchError when fingerprints built with
+// the given config version cannot be compared with the index. An empty index
+// without a recorded config accepts any version; one holding songs returns an
+// *UnversionedIndexError.
+func CheckIndexConfig(ctx context.Context, store Store, version string) error {
+	_, indexVersion, found, err := indexConfig(ctx, store)
+	if err != nil {
+		return err
+	}
+	if found && version != indexVersion {
+		return &ConfigMismatchError{QueryVersion: version, IndexVersion: indexVersion}
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Kinds of segments reported by a Monitor.
+const (
+	SegmentSong    = "song"    // a library song was recognised
+	SegmentUnknown = "unknown" // audio that matched nothing
+	SegmentGap     = "gap"     // silence
+)
+
+// MonitorOptions configures a Monitor.
+type MonitorOptions struct {
+	// WindowSeconds is the length of the most recent audio sent with every
+	// query.
+	WindowSeconds float64
+	// IntervalSeconds is the time between two queries.
+	IntervalSeconds float64
+	// Debounce is the number of consecutive queries that must agree before a
+	// change of segment is reported.
+	Debounce int
+	// SilenceDB is the RMS level, in dBFS, below which a window is a gap.
+	SilenceDB float64
+	// Decision is applied to the matches of every query.
+	Decision DecisionOptions
+}
+
+// DefaultMonitorOptions returns a 10 s window queried every 5 s, with changes
+// confirmed by two queries.
+func DefaultMonitorOptions() MonitorOptions {
+	return MonitorOptions{
+		WindowSeconds:   10,
+		IntervalSeconds: 5,
+		Debounce:        2,
+		SilenceDB:       -50,
+		Decision:        DefaultDecisionOptions(),
+	}
+}
+
+// MonitorEvent reports that a segment started or ended.
+type MonitorEvent struct {
+	Event string    `json:"event"` // "started" or "ended"
+	Kind  string    `json:"kind"`  // SegmentSong, SegmentUnknown or SegmentGap
+	Time  time.Time `json:"time"`
+	// Position is the time since the start of the stream, in seconds.
+	Position float64 `json:"position"`
+
+	SongID     uint32 `json:"songID,omitempty"`
+	SongTitle  string `json:"title,omitempty"`
+	SongArtist string `json:"artist,omitempty"`
+	YouTubeID  string `json:"youtubeID,omitempty"`
+	// SongOffsetMs is the position in the song when it started playing.
+	SongOffsetMs float64 `json:"songOffsetMs,omitempty"`
+	Confidence   float64 `json:"confidence,omitempty"`
+	// Duration is the length of an ended segment, in seconds.
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// observation is the result of one query.
+type observation struct {
+	kind  string
+	match Match
+	start int64 // sample position of the start of the queried window
+}
+
+func (o observation) sameSegment(other observation) bool {
+	return o.kind == other.kind && (o.kind != SegmentSong || o.match.SongID == other.match.SongID)
+}
+
+// Monitor recognises what plays on a continuous mono stream. It keeps a
+// rolling window of recent audio, queries a Recognizer every IntervalSeconds and turns the answers into debounced start and end events.
+type Monitor struct {
+	opts       MonitorOptions
+	sampleRate int
+	startTime  time.Time
+
+	window      []float64 // recent audio, cut to windowLen before each query
+	windowLen   int
+	intervalLen int
+	position    int64 // samples received
+	nextQuery   int64 // position of the next query
+
+	current    *observation // segment being reported, nil before the first
+	currentEnd int64        // end of the last window agreeing with current
+	candidate  observation  // segment waiting for confirmation
+	streak     int          // consecutive queries agreeing with candidate
+
+	// query looks up a window of audio with the recognizer, unless replaced
+	// in tests.
+	query func(ctx context.Context, samples []float64) ([]Match, error)
+}
+
+// NewMonitor returns a monitor for a stream at sampleRate whose first sample
+// was played at startTime; event times are derived from it.
+func NewMonitor(recognizer *Recognizer, sampleRate int, startTime time.Time, opts MonitorOptions) (*Monitor, error) {
+	if opts.WindowSeconds <= 0 || opts.IntervalSeconds <= 0 {
+		return nil, errors.New("window and interval must be positive")
+	}
+	if sampleRate <= 0 {
+		return nil, errors.New("sample rate must be positive")
+	}
+
+	m := &Monitor{
+		opts:        opts,
+		sampleRate:  sampleRate,
+		startTime:   startTime,
+		windowLen:   int(opts.WindowSeconds * float64(sampleRate)),
+		intervalLen: max(int(opts.IntervalSeconds*float64(sampleRate)), 1),
+	}
+	m.nextQuery = int64(m.windowLen)
+	m.query = func(ctx context.Context, samples []float64) ([]Match, error) {
+		matches, _, err := recognizer.FindMatches(ctx, samples, m.sampleRate)
+		return matches, err
+	}
+	return m, nil
+}
+
+// Push adds samples to the stream and returns the events of the queries they
+// triggered.
+func (m *Monitor) Push(ctx context.Context, samples []float64) ([]MonitorEvent, error) {
+	var events []MonitorEvent
+
+	for len(samples) > 0 {
+		n := min(int64(len(samples)), m.nextQuery-m.position)
+		m.window = append(m.window, samples[:n]...)
+		m.position += n
+		samples = samples[n:]
+
+		if m.position == m.nextQuery {
+			// The window grows by one interval between queries and is cut
+			// back to its length before each.
+			if drop := len(m.window) - m.windowLen; drop > 0 {
+				m.window = append(m.window[:0], m.window[drop:]...)
+			}
+			m.nextQuery += int64(m.intervalLen)
+			obs, err := m.observe(ctx)
+			if err != nil {
+				return events, err
+			}
+			events = append(events, m.update(obs)...)
+		}
+	}
+
+	return events, nil
+}
+
+// Close ends the stream and reports the end of the current segment.
+func (m *Monitor) Close() []MonitorEvent {
+	if m.current == nil {
+		return nil
+	}
+	event := m.endEvent(*m.current, m.position)
+	m.current = nil
+	return []MonitorEvent{event}
+}
+
+// observe classifies the current window.
+func (m *Monitor) observe(ctx context.Context) (observation, error) {
+	obs := observation{kind: SegmentUnknown, start: m.position - int64(len(m.window))}
+
+	if rms(m.window) < math.Pow(10, m.opts.SilenceDB/20) {
+		obs.kind = SegmentGap
+		return obs, nil
+	}
+
+	matches, err := m.query(ctx, m.window)
+	if err != nil {
+		return obs, fmt.Errorf("failed to query window at %.1fs: %v", m.seconds(obs.start), err)
+	}
+	if decision := Decide(matches, m.opts.Decision); !decision.NoMatch {
+		obs.kind = SegmentSong
+		obs.match = *decision.Match
+	}
+	return obs, nil
+}
+
+// update feeds an observation to the debouncer. A new segment is reported
+// once Debounce consecutive observations agree on it. The change is placed at
+// the start of the first of their windows, or at the end of the last window
+// of the previous segment if that is later.
+func (m *Monitor) update(obs observation) []MonitorEvent {
+	if m.current != nil && obs.sameSegment(*m.current) {
+		m.currentEnd = m.position
+		m.streak = 0
+		return nil
+	}
+
+	if m.streak > 0 && obs.sameSegment(m.candidate) {
+		m.streak++
+	} else {
+		m.candidate = obs
+		m.streak = 1
+	}
+	if m.streak < max(m.opts.Debounce, 1) {
+		return nil
+	}
+
+	var events []MonitorEvent
+	start := max(m.candidate.start, 0)
+	if m.current != nil {
+		start = max(start, m.currentEnd)
+		events = append(events, m.endEvent(*m.current, start))
+	}
+
+	next := m.candidate
+	next.start = start
+	m.current = &next
+	m.currentEnd = m.position
+	m.streak = 0
+
+	started := m.event("started", next, start)
+	if next.kind == SegmentSong {
+		started.SongOffsetMs = next.match.OffsetMs
+		started.Confidence = next.match.Confidence
+	}
+	return append(events, started)
+}
+
+func (m *Monitor) endEvent(segment observation, end int64) MonitorEvent {
+	event := m.event("ended", segment, end)
+	event.Duration = m.seconds(end - segment.start)
+	return event
+}
+
+func (m *Monitor) event(name string, segment observation, position int64) MonitorEvent {
+	event := MonitorEvent{
+		Event:    name,
+		Kind:     segment.kind,
+		Time:     m.startTime.Add(time.Duration(m.seconds(position) * float64(time.Second))),
+		Position: m.seconds(position),
+	}
+	if segment.kind == SegmentSong {
+		event.SongID = segment.match.SongID
+		event.SongTitle = segment.match.SongTitle
+		event.SongArtist = segment.match.SongArtist
+		event.YouTubeID = segment.match.YouTubeID
+	}
+	return event
+}
+
+func (m *Monitor) seconds(samples int64) float64 {
+	return float64(samples) / float64(m.sampleRate)
+}
+
+// MonitorPCM runs a Monitor over interleaved signed 16-bit little-endian PCM
+// read from r, mixing the channels down to mono, and calls emit for every
+// event until r is exhausted. The end of the current segment is reported
+// even when reading fails. An error from emit stops monitoring.
+func MonitorPCM(ctx context.Context, recognizer *Recognizer, r io.Reader, sampleRate, channels int, startTime time.Time, opts MonitorOptions, emit func(MonitorEvent) error) error {
+	if channels < 1 {
+		return errors.New("number of channels must be positive")
+	}
+
+	monitor, err := NewMonitor(recognizer, sampleRate, startTime, opts)
+	if err != nil {
+		return err
+	}
+
+	emitAll := func(events []MonitorEvent) error {
+		for _, event := range events {
+			if err := emit(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	frameBytes := 2 * channels
+	buf := make([]byte, pcmChunkFrames*frameBytes)
+	samples := make([]float64, 0, pcmChunkFrames)
+	pending := 0 // bytes of an incomplete frame carried over to the next read
+
+	for {
+		n, readErr := io.ReadAtLeast(r, buf[pending:], frameBytes-pending)
+		n += pending
+		frames := n / frameBytes
+
+		samples = samples[:0]
+		for i := 0; i < frames; i++ {
+			var sum float64
+			for c := 0; c < channels; c++ {
+				sum += float64(int16(binary.LittleEndian.Uint16(buf[i*frameBytes+2*c:])))
+			}
+			samples = append(samples, sum/float64(channels)/32768)
+		}
+		pending = copy(buf, buf[frames*frameBytes:n])
+
+		events, err := monitor.Push(ctx, samples)
+		if err := emitAll(events); err != nil {
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			// The stream is over either way; report the current segment.
+			if err := emitAll(monitor.Close()); err != nil {
+				return err
+			}
+			return fmt.Errorf("error reading PCM stream: %v", readErr)
+		}
+	}
+
+	return emitAll(monitor.Close())
+}
+
+// rms returns the root mean square of x.
+func rms(x []float64) float64 {
+	if len(x) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range x {
+		sum += v * v
+	}
+	return math.Sqrt(sum / float64(len(x)))
+}
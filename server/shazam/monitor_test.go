@@ -0,0 +1,80 @@
+package shazam
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMonitorReportsDebouncedSegments(t *testing.T) {
+	ctx := context.Background()
+	const rate = 1000
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// 30 s of song 1, 15 s of silence, then 35 s of unrecognised audio. The
+	// fake index recognises windows made only of the song's level.
+	var stream []float64
+	for _, part := range []struct {
+		level   float64
+		seconds int
+	}{{0.5, 30}, {0, 15}, {0.3, 35}} {
+		for i := 0; i < part.seconds*rate; i++ {
+			stream = append(stream, part.level)
+		}
+	}
+
+	m, err := NewMonitor(nil, rate, start, DefaultMonitorOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.query = func(_ context.Context, samples []float64) ([]Match, error) {
+		for _, v := range samples {
+			if v != 0.5 {
+				return nil, nil
+			}
+		}
+		return []Match{{SongID: 1, AlignedHashes: 100, ZScore: 50, OffsetMs: 1000}}, nil
+	}
+
+	var events []MonitorEvent
+	for i := 0; i < len(stream); i += 777 {
+		got, err := m.Push(ctx, stream[i:min(i+777, len(stream))])
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, got...)
+	}
+	events = append(events, m.Close()...)
+
+	want := []struct {
+		event, kind string
+		position    float64
+	}{
+		{"started", SegmentSong, 0},
+		{"ended", SegmentSong, 30},
+		{"started", SegmentGap, 30},
+		{"ended", SegmentGap, 45},
+		{"started", SegmentUnknown, 45},
+		{"ended", SegmentUnknown, 80},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Event != w.event || e.Kind != w.kind || math.Abs(e.Position-w.position) > 1e-9 {
+			t.Errorf("event %d = %s %s at %.1fs, want %s %s at %.1fs", i, e.Event, e.Kind, e.Position, w.event, w.kind, w.position)
+		}
+		if !e.Time.Equal(start.Add(time.Duration(w.position * float64(time.Second)))) {
+			t.Errorf("event %d at %v, want %.1fs after start", i, e.Time, w.position)
+		}
+	}
+
+	if e := events[0]; e.SongID != 1 || e.SongOffsetMs != 1000 {
+		t.Errorf("song start = %+v", e)
+	}
+	if e := events[1]; e.Duration != 30 {
+		t.Errorf("song lasted %.1fs, want 30", e.Duration)
+	}
+}
@@ -0,0 +1,342 @@
+package shazam
+
+import (
+	"fmt"
+	"math"
+	"song-recognition/utils"
+	"sort"
+)
+
+// PeakPicker names a peak extraction strategy.
+type PeakPicker string
+
+const (
+	// PeakPickerBands keeps the strongest bin of each fixed frequency band per
+	// frame when it exceeds the average of the band maxima (ExtractPeaks).
+	PeakPickerBands PeakPicker = "bands"
+	// PeakPickerLMX keeps local maxima of a time×frequency neighbourhood with
+	// an adaptive threshold and a density target (ExtractPeaksLMX).
+	PeakPickerLMX PeakPicker = "lmx"
+)
+
+// PeakOptions selects and tunes the peak extractor. The neighbourhood,
+// threshold and density fields only apply to PeakPickerLMX.
+type PeakOptions struct {
+	Picker PeakPicker `json:"picker"`
+
+	// TimeRadius and FreqRadius are the half-sizes, in frames and bins, of the
+	// neighbourhood a peak must dominate.
+	TimeRadius int `json:"timeRadius,omitempty"`
+	FreqRadius int `json:"freqRadius,omitempty"`
+
+	// ThresholdDB is how far a peak must rise above the local noise floor,
+	// estimated as the mean log magnitude of the surrounding frames.
+	ThresholdDB float64 `json:"thresholdDB,omitempty"`
+
+	// PeaksPerSecond caps the density: within each second of audio only the
+	// most prominent peaks are kept.
+	PeaksPerSecond int `json:"peaksPerSecond,omitempty"`
+}
+
+// DefaultPeakOptions returns the options for the band picker, the historical
+// behaviour.
+func DefaultPeakOptions() PeakOptions {
+	return PeakOptions{Picker: PeakPickerBands}
+}
+
+// DefaultLMXPeakOptions returns tuned defaults for the local-maximum picker.
+func DefaultLMXPeakOptions() PeakOptions {
+	return PeakOptions{
+		Picker:         PeakPickerLMX,
+		TimeRadius:     3,
+		FreqRadius:     10,
+		ThresholdDB:    6,
+		PeaksPerSecond: 30,
+	}
+}
+
+// PeakOptionsFromEnv returns the default options for the picker named by the
+// PEAK_PICKER environment variable ("bands" or "lmx", default "bands").
+func PeakOptionsFromEnv() (PeakOptions, error) {
+	switch picker := PeakPicker(utils.GetEnv("PEAK_PICKER", string(PeakPickerBands))); picker {
+	case PeakPickerBands:
+		return DefaultPeakOptions(), nil
+	case PeakPickerLMX:
+		return DefaultLMXPeakOptions(), nil
+	default:
+		return PeakOptions{}, fmt.Errorf("unknown peak picker %q (expected %q or %q)", picker, PeakPickerBands, PeakPickerLMX)
+	}
+}
+
+// ExtractPeaksWith runs the extractor selected by cfg.Peaks.
+func ExtractPeaksWith(spectrogram [][]float64, cfg FingerprintConfig) []Peak {
+	switch cfg.Peaks.Picker {
+	case PeakPickerLMX:
+		return ExtractPeaksLMX(spectrogram, cfg)
+	default:
+		return ExtractPeaks(spectrogram, cfg)
+	}
+}
+
+// ExtractPeaksLMX finds points that are the strict maximum of their
+// (2*TimeRadius+1)×(2*FreqRadius+1) neighbourhood in the log-magnitude
+// spectrogram and rise at least ThresholdDB above the local noise floor.
+// Candidates are then thinned to PeaksPerSecond per second of audio, keeping
+// the most prominent ones. Peaks are returned ordered by time, then frequency.
+// The neighbourhood and density settings are taken from cfg.Peaks.
+func ExtractPeaksLMX(spectrogram [][]float64, cfg FingerprintConfig) []Peak {
+	if len(spectrogram) == 0 {
+		return []Peak{}
+	}
+
+	picker := newLMXPicker(cfg)
+	var peaks []Peak
+	for _, frame := range spectrogram {
+		peaks = append(peaks, picker.push(frame)...)
+	}
+	return append(peaks, picker.flush()...)
+}
+
+// peakStream picks peaks from spectrogram frames pushed one at a time, in
+// the same order and with the same result as the batch extractors.
+type peakStream interface {
+	// push adds the next magnitude frame and returns the peaks it completed.
+	push(frame []float64) []Peak
+	// flush returns the peaks still waiting for look-ahead.
+	flush() []Peak
+	// firstUndecided returns the earliest frame that may still yield a peak.
+	firstUndecided() int
+}
+
+// newPeakStream returns the streaming form of the extractor selected by
+// cfg.Peaks.
+func newPeakStream(cfg FingerprintConfig) peakStream {
+	if cfg.Peaks.Picker == PeakPickerLMX {
+		return newLMXPicker(cfg)
+	}
+	return &bandPicker{cfg: cfg}
+}
+
+// bandPicker is the streaming form of ExtractPeaks; frames are independent.
+type bandPicker struct {
+	cfg  FingerprintConfig
+	next int // index of the next frame
+}
+
+func (p *bandPicker) push(frame []float64) []Peak {
+	peaks := bandPeaks(p.next, frame, p.cfg)
+	p.next++
+	return peaks
+}
+
+func (p *bandPicker) flush() []Peak {
+	return nil
+}
+
+func (p *bandPicker) firstUndecided() int {
+	return p.next
+}
+
+// lmxPicker implements ExtractPeaksLMX one frame at a time. It keeps only the
+// frames that are still inside some neighbourhood and the candidates of the
+// current density block, so memory does not grow with the input length.
+type lmxPicker struct {
+	opts           PeakOptions
+	frameDuration  float64
+	freqResolution float64
+	framesPerBlock int
+
+	frames    [][]float64 // log-magnitude frames, frames[0] is frame first
+	floors    []float64   // mean log magnitude of each buffered frame
+	freqMaxes [][]float64 // per-frame max over ±FreqRadius bins
+	first     int         // index of frames[0]
+	next      int         // index of the next frame to decide
+
+	block      int // index of the density block being filled
+	candidates []lmxCandidate
+}
+
+type lmxCandidate struct {
+	frame, bin int
+	prominence float64
+}
+
+func newLMXPicker(cfg FingerprintConfig) *lmxPicker {
+	return &lmxPicker{
+		opts:           cfg.Peaks,
+		frameDuration:  cfg.frameDuration(),
+		freqResolution: cfg.freqResolution(),
+		framesPerBlock: max(int(math.Round(1/cfg.frameDuration())), 1),
+	}
+}
+
+// push adds the next magnitude frame and returns the peaks of any density
+// block that became complete.
+func (p *lmxPicker) push(frame []float64) []Peak {
+	frame = logMagnitude(frame)
+	p.frames = append(p.frames, frame)
+	p.freqMaxes = append(p.freqMaxes, slidingMax(frame, p.opts.FreqRadius))
+
+	var sum float64
+	for _, v := range frame {
+		sum += v
+	}
+	p.floors = append(p.floors, sum/float64(max(len(frame), 1)))
+
+	var peaks []Peak
+	for p.next+p.opts.TimeRadius < p.first+len(p.frames) {
+		peaks = append(peaks, p.decide(p.next)...)
+		p.next++
+	}
+	p.trim()
+	return peaks
+}
+
+// flush decides the frames still waiting for look-ahead and returns the
+// remaining peaks.
+func (p *lmxPicker) flush() []Peak {
+	var peaks []Peak
+	for p.next < p.first+len(p.frames) {
+		peaks = append(peaks, p.decide(p.next)...)
+		p.next++
+	}
+	return append(peaks, p.emitBlock()...)
+}
+
+// firstUndecided returns the first frame of the density block being filled;
+// its candidates are only emitted once the block is complete.
+func (p *lmxPicker) firstUndecided() int {
+	return p.block * p.framesPerBlock
+}
+
+// decide collects the candidates of frame t; its whole neighbourhood must be
+// buffered (or lie outside the spectrogram).
+func (p *lmxPicker) decide(t int) []Peak {
+	var peaks []Peak
+	if block := t / p.framesPerBlock; block != p.block {
+		peaks = p.emitBlock()
+		p.block = block
+	}
+
+	lo := max(t-p.opts.TimeRadius, p.first)
+	hi := min(t+p.opts.TimeRadius, p.first+len(p.frames)-1)
+
+	var floor float64
+	for i := lo; i <= hi; i++ {
+		floor += p.floors[i-p.first]
+	}
+	floor /= float64(hi - lo + 1)
+
+	frame := p.frames[t-p.first]
+	for bin := 1; bin < len(frame); bin++ {
+		mag := frame[bin]
+		if mag-floor < p.opts.ThresholdDB {
+			continue
+		}
+
+		isMax := true
+		for i := lo; i <= hi && isMax; i++ {
+			if p.freqMaxes[i-p.first][bin] > mag {
+				isMax = false
+			}
+		}
+		if isMax && p.hasEarlierTie(t, bin, lo, hi, mag) {
+			isMax = false
+		}
+		if isMax {
+			p.candidates = append(p.candidates, lmxCandidate{t, bin, mag - floor})
+		}
+	}
+
+	return peaks
+}
+
+// hasEarlierTie breaks plateaus: among equal values in a neighbourhood only
+// the first one in (time, frequency) order is a peak.
+func (p *lmxPicker) hasEarlierTie(t, bin, lo, hi int, mag float64) bool {
+	r := p.opts.FreqRadius
+	for i := lo; i <= t; i++ {
+		frame := p.frames[i-p.first]
+		end := min(bin+r, len(frame)-1)
+		if i == t {
+			end = bin - 1
+		}
+		for f := max(bin-r, 0); f <= end; f++ {
+			if frame[f] == mag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// emitBlock returns the most prominent candidates of the current block.
+func (p *lmxPicker) emitBlock() []Peak {
+	if len(p.candidates) == 0 {
+		return nil
+	}
+
+	kept := p.candidates
+	if p.opts.PeaksPerSecond > 0 && len(kept) > p.opts.PeaksPerSecond {
+		sort.SliceStable(kept, func(i, j int) bool {
+			return kept[i].prominence > kept[j].prominence
+		})
+		kept = kept[:p.opts.PeaksPerSecond]
+		sort.SliceStable(kept, func(i, j int) bool {
+			if kept[i].frame != kept[j].frame {
+				return kept[i].frame < kept[j].frame
+			}
+			return kept[i].bin < kept[j].bin
+		})
+	}
+
+	peaks := make([]Peak, len(kept))
+	for i, c := range kept {
+		peaks[i] = Peak{Time: float64(c.frame) * p.frameDuration, Freq: float64(c.bin) * p.freqResolution}
+	}
+	p.candidates = p.candidates[:0]
+	return peaks
+}
+
+// trim drops frames that can no longer be part of an undecided neighbourhood.
+func (p *lmxPicker) trim() {
+	keepFrom := p.next - p.opts.TimeRadius
+	if drop := keepFrom - p.first; drop > 0 {
+		p.frames = p.frames[drop:]
+		p.floors = p.floors[drop:]
+		p.freqMaxes = p.freqMaxes[drop:]
+		p.first += drop
+	}
+}
+
+// logMagnitude converts a magnitude frame to decibels.
+func logMagnitude(frame []float64) []float64 {
+	out := make([]float64, len(frame))
+	for i, mag := range frame {
+		out[i] = 20 * math.Log10(mag+1e-10)
+	}
+	return out
+}
+
+// slidingMax returns, for every index, the maximum of x over [i-r, i+r].
+func slidingMax(x []float64, r int) []float64 {
+	out := make([]float64, len(x))
+	var deque []int
+	for i := 0; i < len(x)+r; i++ {
+		if i < len(x) {
+			for len(deque) > 0 && x[deque[len(deque)-1]] <= x[i] {
+				deque = deque[:len(deque)-1]
+			}
+			deque = append(deque, i)
+		}
+		center := i - r
+		if center < 0 {
+			continue
+		}
+		for deque[0] < center-r {
+			deque = deque[1:]
+		}
+		out[center] = x[deque[0]]
+	}
+	return out
+}
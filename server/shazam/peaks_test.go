@@ -0,0 +1,107 @@
+package shazam
+
+import (
+	"math"
+	"testing"
+)
+
+// toneSpectrogram builds a synthetic magnitude spectrogram with a constant
+// noise floor and a sustained tone in bin toneBin.
+func toneSpectrogram(frames, toneBin int) [][]float64 {
+	spectro := make([][]float64, frames)
+	for i := range spectro {
+		frame := make([]float64, testCfg.WindowSize/2)
+		for j := range frame {
+			frame[j] = 0.01 * (1 + 0.1*math.Sin(float64(i*j)))
+		}
+		frame[toneBin] = 1 + 0.01*float64(i%3)
+		spectro[i] = frame
+	}
+	return spectro
+}
+
+func TestExtractPeaksLMXSuppressesSustainedNotes(t *testing.T) {
+	spectro := toneSpectrogram(200, 100)
+	opts := DefaultLMXPeakOptions()
+
+	peaks := ExtractPeaksLMX(spectro, lmxConfig(opts))
+	if len(peaks) == 0 {
+		t.Fatal("expected peaks on the tone")
+	}
+
+	frameDuration := float64(testCfg.HopSize) / float64(testCfg.SampleRate)
+	for i := 1; i < len(peaks); i++ {
+		gap := (peaks[i].Time - peaks[i-1].Time) / frameDuration
+		if peaks[i].Freq == peaks[i-1].Freq && gap < float64(opts.TimeRadius) {
+			t.Fatalf("peaks %d and %d on the same bin are only %.1f frames apart", i-1, i, gap)
+		}
+	}
+
+	// The band picker reports the tone in every frame.
+	if bands := ExtractPeaks(spectro, testCfg); len(bands) <= len(peaks) {
+		t.Errorf("expected LMX (%d peaks) to be sparser than bands (%d peaks)", len(peaks), len(bands))
+	}
+}
+
+func TestExtractPeaksLMXDensity(t *testing.T) {
+	spectro := make([][]float64, 430) // ~20 s
+	for i := range spectro {
+		frame := make([]float64, testCfg.WindowSize/2)
+		for j := range frame {
+			frame[j] = 0.001
+		}
+		// A dense grid of isolated spikes, far more than the density allows.
+		for j := 5 + (i%5)*3; j < len(frame); j += 25 {
+			frame[j] = 1
+		}
+		spectro[i] = frame
+	}
+
+	opts := DefaultLMXPeakOptions()
+	opts.TimeRadius, opts.FreqRadius = 1, 2
+	peaks := ExtractPeaksLMX(spectro, lmxConfig(opts))
+
+	framesPerBlock := int(math.Round(float64(testCfg.SampleRate) / float64(testCfg.HopSize)))
+	counts := map[int]int{}
+	for _, p := range peaks {
+		frame := int(math.Round(p.Time * float64(testCfg.SampleRate) / float64(testCfg.HopSize)))
+		counts[frame/framesPerBlock]++
+	}
+	for block, n := range counts {
+		if n > opts.PeaksPerSecond {
+			t.Errorf("block %d has %d peaks, want at most %d", block, n, opts.PeaksPerSecond)
+		}
+	}
+}
+
+func TestExtractPeaksLMXPlateau(t *testing.T) {
+	spectro := make([][]float64, 20)
+	for i := range spectro {
+		spectro[i] = make([]float64, testCfg.WindowSize/2)
+		for j := range spectro[i] {
+			spectro[i][j] = 0.001
+		}
+	}
+	// A flat 3×3 plateau must produce a single peak.
+	for i := 8; i < 11; i++ {
+		for j := 40; j < 43; j++ {
+			spectro[i][j] = 1
+		}
+	}
+
+	peaks := ExtractPeaksLMX(spectro, lmxConfig(DefaultLMXPeakOptions()))
+	if len(peaks) != 1 {
+		t.Fatalf("got %d peaks on a plateau, want 1: %v", len(peaks), peaks)
+	}
+}
+
+func TestSlidingMax(t *testing.T) {
+	x := []float64{1, 3, 2, 5, 4, 0, 1}
+	want := []float64{3, 3, 5, 5, 5, 4, 1}
+	got := slidingMax(x, 1)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("slidingMax = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"song-recognition/db"
+	"song-recognition/models"
+	"song-recognition/utils"
+	"sync/atomic"
+	"time"
+)
+
+// Store is the part of the database used to index and recognise songs.
+// db.DBClient implements it; a single client may be shared by any number of
+// Recognizers and Indexers for the lifetime of the program.
+type Store interface {
+	GetCouples(ctx context.Context, addresses []uint32) (map[uint32][]models.Couple, error)
+	GetSongByID(ctx context.Context, songID uint32) (db.Song, bool, error)
+	RegisterSong(ctx context.Context, song db.Song) (uint32, error)
+	StoreFingerprints(ctx context.Context, fingerprints []models.Fingerprint) error
+	DeleteSongByID(ctx context.Context, songID uint32) error
+	TotalSongs(ctx context.Context) (int, error)
+	GetMeta(ctx context.Context, key string) (string, bool, error)
+	SetMeta(ctx context.Context, key, value string) error
+}
+
+// RecognizerOptions configures a Recognizer.
+type RecognizerOptions struct {
+	// Decision is applied by Recognizer.Decide.
+	Decision DecisionOptions
+	// MaxMatches caps the number of matches a query returns; 0 returns all.
+	MaxMatches int
+}
+
+// DefaultRecognizerOptions returns the default decision thresholds and the top
+// 20 matches.
+func DefaultRecognizerOptions() RecognizerOptions {
+	return RecognizerOptions{
+		Decision:   DefaultDecisionOptions(),
+		MaxMatches: 20,
+	}
+}
+
+// Recognizer matches recordings against the songs of a store. It is safe for
+// concurrent use when the store is.
+type Recognizer struct {
+	store Store
+	cfg   FingerprintConfig
+	opts  RecognizerOptions
+
+	// index caches the config recorded in the store, which never changes
+	// once recorded.
+	index atomic.Pointer[recordedConfig]
+}
+
+// recordedConfig is a config recorded in an index and its version.
+type recordedConfig struct {
+	cfg     FingerprintConfig
+	version string
+}
+
+// NewRecognizer returns a recognizer querying store. cfg must be the config
+// the store was indexed with. The store's recorded config is loaded by the
+// first query that finds one; later queries only compare versions with it.
+func NewRecognizer(store Store, cfg FingerprintConfig, opts RecognizerOptions) *Recognizer {
+	return &Recognizer{store: store, cfg: cfg, opts: opts}
+}
+
+// Config returns the config recordings are fingerprinted with.
+func (r *Recognizer) Config() FingerprintConfig {
+	return r.cfg
+}
+
+// FindMatches analyzes the audio sample to find matching songs in the store.
+func (r *Recognizer) FindMatches(ctx context.Context, audioSample []float64, sampleRate int) ([]Match, time.Duration, error) {
+	startTime := time.Now()
+
+	spectrogram, err := Spectrogram(audioSample, sampleRate, r.cfg)
+	if err != nil {
+		return nil, time.Since(startTime), fmt.Errorf("failed to get spectrogram of samples: %v", err)
+	}
+
+	peaks := ExtractPeaksWith(spectrogram, r.cfg)
+	sampleFingerprint := Fingerprint(peaks, utils.GenerateUniqueID(), r.cfg)
+
+	matches, _, err := r.FindMatchesFGP(ctx, sampleFingerprint, r.cfg.Version())
+	if err != nil {
+		return nil, time.Since(startTime), err
+	}
+
+	return matches, time.Since(startTime), nil
+}
+
+// FindMatchesFGP uses the sample fingerprint to find matching songs in the store.
+// Every occurrence of an address in the sample votes with every occurrence
+// of that address in the store. configVersion is the version of the config
+// the sample was fingerprinted with; a *ConfigMismatchError is returned when
+// it differs from the index's.
+func (r *Recognizer) FindMatchesFGP(ctx context.Context, sampleFingerprint []models.Fingerprint, configVersion string) ([]Match, time.Duration, error) {
+	startTime := time.Now()
+
+	indexCfg, indexVersion, found, err := r.indexConfig(ctx)
+	if err != nil {
+		return nil, time.Since(startTime), err
+	}
+	if found && configVersion != indexVersion {
+		return nil, time.Since(startTime), &ConfigMismatchError{QueryVersion: configVersion, IndexVersion: indexVersion}
+	}
+
+	matches, err := matchFingerprints(ctx, r.store, sampleFingerprint, indexCfg.Hashing)
+	if err != nil {
+		return nil, time.Since(startTime), err
+	}
+	if r.opts.MaxMatches > 0 && len(matches) > r.opts.MaxMatches {
+		matches = matches[:r.opts.MaxMatches]
+	}
+
+	return matches, time.Since(startTime), nil
+}
+
+// indexConfig returns the config recorded in the store, loading and
+// validating it on first use. An index without a recorded config is checked
+// again on every call, until songs are indexed into it.
+func (r *Recognizer) indexConfig(ctx context.Context) (FingerprintConfig, string, bool, error) {
+	if recorded := r.index.Load(); recorded != nil {
+		return recorded.cfg, recorded.version, true, nil
+	}
+
+	cfg, version, found, err := indexConfig(ctx, r.store)
+	if err != nil || !found {
+		return FingerprintConfig{}, "", false, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return FingerprintConfig{}, "", false, fmt.Errorf("invalid index fingerprint config: %v", err)
+	}
+	r.index.Store(&recordedConfig{cfg: cfg, version: version})
+	return cfg, version, true, nil
+}
+
+// Decide applies the recognizer's decision thresholds to the matches of a
+// query.
+func (r *Recognizer) Decide(matches []Match) Decision {
+	return Decide(matches, r.opts.Decision)
+}
+
+// Indexer adds songs to a store.
+type Indexer struct {
+	store Store
+	cfg   FingerprintConfig
+}
+
+// NewIndexer returns an indexer fingerprinting songs with cfg into store. The
+// config is recorded in an empty store and checked against a filled one.
+func NewIndexer(store Store, cfg FingerprintConfig) *Indexer {
+	return &Indexer{store: store, cfg: cfg}
+}
+
+// IndexFile registers a song and stores the fingerprints of its audio file,
+// and returns the song's ID. song.FilePath defaults to songFilePath and
+// song.Artists to song.Artist. The song
+// is unregistered again when its fingerprints cannot be stored.
+func (ix *Indexer) IndexFile(ctx context.Context, songFilePath string, song db.Song) (uint32, error) {
+	if song.FilePath == "" {
+		song.FilePath = songFilePath
+	}
+	return ix.index(ctx, song, func(songID uint32) ([]models.Fingerprint, error) {
+		return FingerprintAudio(songFilePath, songID, ix.cfg)
+	})
+}
+
+// IndexSamples is IndexFile for mono samples already in memory. song.Duration
+// defaults to the length of the samples.
+func (ix *Indexer) IndexSamples(ctx context.Context, samples []float64, sampleRate int, song db.Song) (uint32, error) {
+	if song.Duration == 0 && sampleRate > 0 {
+		song.Duration = int(math.Round(float64(len(samples)) / float64(sampleRate)))
+	}
+	return ix.index(ctx, song, func(songID uint32) ([]models.Fingerprint, error) {
+		spectrogram, err := Spectrogram(samples, sampleRate, ix.cfg)
+		if err != nil {
+			return nil, err
+		}
+		return Fingerprint(ExtractPeaksWith(spectrogram, ix.cfg), songID, ix.cfg), nil
+	})
+}
+
+func (ix *Indexer) index(ctx context.Context, song db.Song, fingerprint func(songID uint32) ([]models.Fingerprint, error)) (uint32, error) {
+	if err := EnsureIndexConfig(ctx, ix.store, ix.cfg); err != nil {
+		return 0, err
+	}
+
+	if len(song.Artists) == 0 && song.Artist != "" {
+		song.Artists = []string{song.Artist}
+	}
+
+	songID, err := ix.store.RegisterSong(ctx, song)
+	if err != nil {
+		return 0, fmt.Errorf("error registering song '%s' by '%s': %v", song.Title, song.Artist, err)
+	}
+
+	fingerprints, err := fingerprint(songID)
+	if err != nil {
+		return 0, unregisterSong(ctx, ix.store, songID, fmt.Errorf("error generating fingerprint for %s by %s: %v", song.Title, song.Artist, err))
+	}
+
+	if err := ix.store.StoreFingerprints(ctx, fingerprints); err != nil {
+		return 0, unregisterSong(ctx, ix.store, songID, fmt.Errorf("error storing fingerprint: %v", err))
+	}
+
+	return songID, nil
+}
+
+// unregisterSong deletes a song whose fingerprints could not be stored and
+// returns err, with the deletion's own error if it failed too: the song is
+// then never recognised until 'fsck -prune' removes it. The deletion runs
+// even when ctx is done, as that is often why the fingerprints were lost.
+func unregisterSong(ctx context.Context, store Store, songID uint32, err error) error {
+	if deleteErr := store.DeleteSongByID(context.WithoutCancel(ctx), songID); deleteErr != nil {
+		return fmt.Errorf("%v; song %d is left without fingerprints, as deleting it failed: %v", err, songID, deleteErr)
+	}
+	return err
+}
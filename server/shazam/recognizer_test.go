@@ -0,0 +1,204 @@
+package shazam
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"song-recognition/db"
+	"song-recognition/models"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRecognizerSharesStore(t *testing.T) {
+	ctx := context.Background()
+	client, err := db.NewSQLiteClient(filepath.Join(t.TempDir(), "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	cfg := DefaultConfig()
+	if err := EnsureIndexConfig(ctx, client, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every song shares the sample's addresses, so all of them match.
+	rng := rand.New(rand.NewSource(1))
+	sample := make([]models.Fingerprint, 200)
+	for i := range sample {
+		sample[i] = models.Fingerprint{Address: rng.Uint32(), AnchorTimeMs: uint32(i * 50)}
+	}
+	var songIDs []uint32
+	for s := 0; s < 5; s++ {
+		songID, err := client.RegisterSong(ctx, db.Song{Title: "Song", Artist: string(rune('A' + s))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		songIDs = append(songIDs, songID)
+		fps := make([]models.Fingerprint, len(sample))
+		for i, fp := range sample {
+			fps[i] = models.Fingerprint{Address: fp.Address, AnchorTimeMs: fp.AnchorTimeMs + 1000, SongID: songID}
+		}
+		if err := client.StoreFingerprints(ctx, fps); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recognizer := NewRecognizer(client, cfg, RecognizerOptions{Decision: DefaultDecisionOptions(), MaxMatches: 3})
+	matches, _, err := recognizer.FindMatchesFGP(ctx, sample, cfg.Version())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 3 {
+		t.Fatalf("got %d matches, want 3", len(matches))
+	}
+	// The songs tie, so the lowest IDs come first.
+	sort.Slice(songIDs, func(i, j int) bool { return songIDs[i] < songIDs[j] })
+	for i, match := range matches {
+		if match.SongID != songIDs[i] {
+			t.Errorf("match %d is song %d, want %d", i, match.SongID, songIDs[i])
+		}
+	}
+
+	// A second recognizer over the same client sees the same index.
+	all := NewRecognizer(client, cfg, RecognizerOptions{})
+	if matches, _, err := all.FindMatchesFGP(ctx, sample, cfg.Version()); err != nil || len(matches) != 5 {
+		t.Errorf("got %d matches (%v), want 5", len(matches), err)
+	}
+
+	var mismatch *ConfigMismatchError
+	if _, _, err := recognizer.FindMatchesFGP(ctx, sample, "other"); !errors.As(err, &mismatch) {
+		t.Errorf("got %v, want a config mismatch", err)
+	}
+}
+
+func TestIndexerUnregistersFailedSong(t *testing.T) {
+	ctx := context.Background()
+	client, err := db.NewSQLiteClient(filepath.Join(t.TempDir(), "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	indexer := NewIndexer(client, DefaultConfig())
+	if _, err := indexer.IndexFile(ctx, filepath.Join(t.TempDir(), "missing.wav"), db.Song{Title: "Missing", Artist: "Artist"}); err == nil {
+		t.Fatal("indexed a missing file")
+	}
+
+	total, err := client.TotalSongs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 0 {
+		t.Errorf("%d songs left registered, want 0", total)
+	}
+}
+
+// failingStore fails to store fingerprints and to delete songs.
+type failingStore struct {
+	Store
+}
+
+func (failingStore) StoreFingerprints(context.Context, []models.Fingerprint) error {
+	return errors.New("disk full")
+}
+
+func (failingStore) DeleteSongByID(context.Context, uint32) error {
+	return errors.New("connection lost")
+}
+
+func TestIndexerReportsFailedUnregister(t *testing.T) {
+	ctx := context.Background()
+	indexer := NewIndexer(failingStore{db.NewMemoryClient()}, DefaultConfig())
+	samples := make([]float64, 44100)
+	for i := range samples {
+		samples[i] = math.Sin(float64(i) * 0.1)
+	}
+
+	_, err := indexer.IndexSamples(ctx, samples, 44100, db.Song{Title: "Title", Artist: "Artist"})
+	if err == nil || !strings.Contains(err.Error(), "disk full") || !strings.Contains(err.Error(), "connection lost") {
+		t.Errorf("got %v, want both the storing and the deleting error", err)
+	}
+}
+
+// songLookupFailingStore fails to look songs up.
+type songLookupFailingStore struct {
+	Store
+}
+
+func (songLookupFailingStore) GetSongByID(context.Context, uint32) (db.Song, bool, error) {
+	return db.Song{}, false, errors.New("connection lost")
+}
+
+// A query whose songs cannot be read fails rather than matching nothing.
+func TestRecognizerReportsFailedSongLookup(t *testing.T) {
+	ctx := context.Background()
+	client := db.NewMemoryClient()
+	cfg := DefaultConfig()
+	if err := EnsureIndexConfig(ctx, client, cfg); err != nil {
+		t.Fatal(err)
+	}
+	songID, err := client.RegisterSong(ctx, db.Song{Title: "Title", Artist: "Artist"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := []models.Fingerprint{{Address: 1, AnchorTimeMs: 0}}
+	if err := client.StoreFingerprints(ctx, []models.Fingerprint{{Address: 1, AnchorTimeMs: 500, SongID: songID}}); err != nil {
+		t.Fatal(err)
+	}
+
+	recognizer := NewRecognizer(songLookupFailingStore{client}, cfg, RecognizerOptions{})
+	if _, _, err := recognizer.FindMatchesFGP(ctx, sample, cfg.Version()); err == nil || !strings.Contains(err.Error(), "connection lost") {
+		t.Errorf("got %v, want the lookup error", err)
+	}
+}
+
+// metaCountingStore counts the meta reads of a store.
+type metaCountingStore struct {
+	Store
+	reads int
+}
+
+func (s *metaCountingStore) GetMeta(ctx context.Context, key string) (string, bool, error) {
+	s.reads++
+	return s.Store.GetMeta(ctx, key)
+}
+
+func TestRecognizerLoadsIndexConfigOnce(t *testing.T) {
+	ctx := context.Background()
+	store := &metaCountingStore{Store: db.NewMemoryClient()}
+	cfg := DefaultConfig()
+	recognizer := NewRecognizer(store, cfg, RecognizerOptions{})
+	sample := []models.Fingerprint{{Address: 1, AnchorTimeMs: 0}}
+
+	// An empty index is checked again by every query.
+	for i := 0; i < 2; i++ {
+		if _, _, err := recognizer.FindMatchesFGP(ctx, sample, cfg.Version()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.reads != 2 {
+		t.Errorf("%d meta reads for 2 queries on an empty index, want 2", store.reads)
+	}
+
+	if err := EnsureIndexConfig(ctx, store, cfg); err != nil {
+		t.Fatal(err)
+	}
+	store.reads = 0
+	for i := 0; i < 3; i++ {
+		if _, _, err := recognizer.FindMatchesFGP(ctx, sample, cfg.Version()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var mismatch *ConfigMismatchError
+	if _, _, err := recognizer.FindMatchesFGP(ctx, sample, "other"); !errors.As(err, &mismatch) {
+		t.Errorf("got %v, want a config mismatch", err)
+	}
+	if store.reads != 2 {
+		t.Errorf("%d meta reads for 4 queries, want the 2 of the first", store.reads)
+	}
+}
@@ -0,0 +1,214 @@
+package shazam
+
+import (
+	"errors"
+	"math"
+)
+
+// resamplerZeroCrossings is the number of sinc zero crossings kept on each
+// side of the interpolation kernel. More crossings give a steeper transition
+// band at the cost of more taps per output sample.
+const resamplerZeroCrossings = 16
+
+// resamplerRolloff places the anti-aliasing cutoff slightly below the
+// Nyquist frequency of the lower of the two rates.
+const resamplerRolloff = 0.95
+
+// Resampler converts a signal between two sample rates using a polyphase
+// windowed-sinc filter. The conversion ratio is reduced to up/down with the
+// greatest common divisor of the two rates, so any pair of integer rates is
+// handled exactly (44.1 kHz and 48 kHz inputs land on the same output grid).
+//
+// A Resampler is stateful: input may be pushed in chunks of any size with
+// Process and the output is identical to resampling the concatenated input in
+// one call. Flush drains the samples still waiting for look-ahead.
+type Resampler struct {
+	up, down  int
+	halfTaps  int
+	cutoff    float64     // normalised to the input Nyquist frequency
+	phases    [][]float64 // lazily built kernel for each of the up phases
+	buf       []float64   // pending input, buf[0] is input sample bufStart
+	bufStart  int64
+	consumed  int64 // total number of input samples received
+	nextOut   int64 // index of the next output sample
+	flushed   bool
+	outLength int64 // expected output length, set by Flush
+}
+
+// NewResampler returns a resampler from fromRate to toRate. cutoffHz sets the
+// anti-aliasing cutoff; values <= 0 or above the Nyquist frequency of the
+// lower rate fall back to just below that Nyquist frequency.
+func NewResampler(fromRate, toRate int, cutoffHz float64) (*Resampler, error) {
+	if fromRate <= 0 || toRate <= 0 {
+		return nil, errors.New("sample rates must be positive")
+	}
+
+	g := gcd(fromRate, toRate)
+	up, down := toRate/g, fromRate/g
+
+	nyquist := float64(min(fromRate, toRate)) / 2
+	if cutoffHz <= 0 || cutoffHz > nyquist*resamplerRolloff {
+		cutoffHz = nyquist * resamplerRolloff
+	}
+	cutoff := cutoffHz / (float64(fromRate) / 2)
+
+	halfTaps := int(math.Ceil(resamplerZeroCrossings / cutoff))
+	if up == down {
+		halfTaps = 0
+	}
+
+	return &Resampler{
+		up:       up,
+		down:     down,
+		halfTaps: halfTaps,
+		cutoff:   cutoff,
+		phases:   make([][]float64, up),
+	}, nil
+}
+
+// Process consumes input and returns every output sample that can be
+// computed so far. The returned slice is newly allocated.
+func (r *Resampler) Process(input []float64) []float64 {
+	if r.flushed {
+		return nil
+	}
+
+	r.buf = append(r.buf, input...)
+	r.consumed += int64(len(input))
+
+	return r.drain(r.consumed)
+}
+
+// Flush pads the input with silence, returns the remaining output samples and
+// marks the resampler as finished.
+func (r *Resampler) Flush() []float64 {
+	if r.flushed {
+		return nil
+	}
+	r.flushed = true
+	r.outLength = (r.consumed*int64(r.up) + int64(r.down) - 1) / int64(r.down)
+
+	r.buf = append(r.buf, make([]float64, r.halfTaps)...)
+	return r.drain(r.consumed + int64(r.halfTaps))
+}
+
+// drain computes outputs while the input needed by their kernels is
+// available, i.e. while the right edge of the kernel is below available.
+func (r *Resampler) drain(available int64) []float64 {
+	if r.up == r.down {
+		out := append([]float64(nil), r.buf...)
+		r.nextOut += int64(len(r.buf))
+		r.bufStart += int64(len(r.buf))
+		r.buf = r.buf[:0]
+		return out
+	}
+
+	var out []float64
+	taps := 2 * r.halfTaps
+
+	for {
+		if r.flushed && r.nextOut >= r.outLength {
+			break
+		}
+
+		pos := r.nextOut * int64(r.down)
+		base := pos / int64(r.up)
+		phase := int(pos % int64(r.up))
+
+		first := base - int64(r.halfTaps) + 1
+		if first+int64(taps) > available {
+			break
+		}
+
+		kernel := r.kernel(phase)
+		var sum float64
+		for j, h := range kernel {
+			idx := first + int64(j) - r.bufStart
+			if idx >= 0 {
+				sum += h * r.buf[idx]
+			}
+		}
+		out = append(out, sum)
+		r.nextOut++
+	}
+
+	// Drop input that no future output can reach.
+	nextBase := r.nextOut * int64(r.down) / int64(r.up)
+	keepFrom := nextBase - int64(r.halfTaps) + 1
+	if drop := keepFrom - r.bufStart; drop > 0 {
+		if drop > int64(len(r.buf)) {
+			drop = int64(len(r.buf))
+		}
+		r.buf = append(r.buf[:0], r.buf[drop:]...)
+		r.bufStart += drop
+	}
+
+	return out
+}
+
+// kernel returns the filter taps for the given polyphase branch. Tap j
+// weights input sample base-halfTaps+1+j for an output located phase/up
+// samples after base.
+func (r *Resampler) kernel(phase int) []float64 {
+	if k := r.phases[phase]; k != nil {
+		return k
+	}
+
+	taps := 2 * r.halfTaps
+	frac := float64(phase) / float64(r.up)
+	halfWidth := float64(r.halfTaps)
+
+	k := make([]float64, taps)
+	var sum float64
+	for j := range k {
+		x := frac + float64(r.halfTaps-1-j) // distance from output to input sample
+		if math.Abs(x) >= halfWidth {
+			continue
+		}
+		k[j] = r.cutoff * sinc(r.cutoff*x) * blackman(x/halfWidth)
+		sum += k[j]
+	}
+
+	// Normalise each branch to unity gain at DC.
+	if sum != 0 {
+		for j := range k {
+			k[j] /= sum
+		}
+	}
+
+	r.phases[phase] = k
+	return k
+}
+
+// Resample converts input from fromRate to toRate with a windowed-sinc
+// polyphase filter. It is equivalent to feeding the whole input to a
+// Resampler and flushing it.
+func Resample(input []float64, fromRate, toRate int, cutoffHz float64) ([]float64, error) {
+	r, err := NewResampler(fromRate, toRate, cutoffHz)
+	if err != nil {
+		return nil, err
+	}
+
+	out := r.Process(input)
+	return append(out, r.Flush()...), nil
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// blackman evaluates a Blackman window centred on zero for x in [-1, 1].
+func blackman(x float64) float64 {
+	theta := math.Pi * (x + 1)
+	return 0.42 - 0.5*math.Cos(theta) + 0.08*math.Cos(2*theta)
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,104 @@
+package shazam
+
+import (
+	"math"
+	"testing"
+)
+
+func sineWave(freq float64, sampleRate int, seconds float64) []float64 {
+	n := int(seconds * float64(sampleRate))
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = math.Sin(2 * math.Pi * freq * float64(i) / float64(sampleRate))
+	}
+	return x
+}
+
+func TestResampleOutputLength(t *testing.T) {
+	for _, rate := range []int{8000, 22050, 44100, 48000, 96000} {
+		x := make([]float64, rate) // one second
+		y, err := Resample(x, rate, testCfg.SampleRate, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(y) != testCfg.SampleRate {
+			t.Errorf("%d Hz: got %d samples, want %d", rate, len(y), testCfg.SampleRate)
+		}
+	}
+}
+
+func TestResampleChunkedMatchesBatch(t *testing.T) {
+	x := randomSignal(48000, 3)
+	want, err := Resample(x, 48000, testCfg.SampleRate, testCfg.MaxFreq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewResampler(48000, testCfg.SampleRate, testCfg.MaxFreq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []float64
+	for start, size := 0, 1; start < len(x); size = size*3 + 1 {
+		end := min(start+size, len(x))
+		got = append(got, r.Process(x[start:end])...)
+		start = end
+	}
+	got = append(got, r.Flush()...)
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestResamplePassbandAndStopband(t *testing.T) {
+	for _, rate := range []int{44100, 48000} {
+		pass, _ := Resample(sineWave(1000, rate, 1), rate, testCfg.SampleRate, testCfg.MaxFreq)
+		if g := rms(pass[1000:len(pass)-1000]) * math.Sqrt2; math.Abs(g-1) > 0.01 {
+			t.Errorf("%d Hz: 1 kHz tone gain %.4f, want ~1", rate, g)
+		}
+
+		// 8 kHz is above the analysis Nyquist frequency and would alias to
+		// ~3 kHz without filtering.
+		stop, _ := Resample(sineWave(8000, rate, 1), rate, testCfg.SampleRate, testCfg.MaxFreq)
+		if g := rms(stop[1000:len(stop)-1000]) * math.Sqrt2; g > 1e-3 {
+			t.Errorf("%d Hz: 8 kHz tone leaked with gain %.5f", rate, g)
+		}
+	}
+}
+
+func TestSpectrogramIndependentOfInputRate(t *testing.T) {
+	peakBin := func(rate int) int {
+		spectro, err := Spectrogram(sineWave(1500, rate, 2), rate, DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame := spectro[len(spectro)/2]
+		best := 0
+		for i, mag := range frame {
+			if mag > frame[best] {
+				best = i
+			}
+		}
+		return best
+	}
+
+	if a, b := peakBin(44100), peakBin(48000); a != b {
+		t.Errorf("1.5 kHz tone lands in bin %d at 44.1 kHz but bin %d at 48 kHz", a, b)
+	}
+}
+
+func BenchmarkResample48kTo11025(b *testing.B) {
+	x := randomSignal(48000*10, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Resample(x, 48000, testCfg.SampleRate, testCfg.MaxFreq); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -4,170 +4,322 @@
 package shazam
 
 import (
+	"context"
 	"fmt"
-	"song-recognition/db"
+	"math"
+	"song-recognition/models"
 	"song-recognition/utils"
 	"sort"
 	"time"
 )
 
+// Match is a song whose hashes line up with the sample.
 type Match struct {
 	SongID     uint32
 	SongTitle  string
 	SongArtist string
 	YouTubeID  string
-	Timestamp  uint32
-	Score      float64
+	// Metadata of the song, empty where unknown.
+	Album            string
+	Artists          []string
+	Duration         int // length of the song, in seconds
+	SpotifyID        string
+	ISRC             string
+	FilePath         string `json:"-"` // only meaningful on the server
+	DateAdded        time.Time
+	FingerprintCount int
+	// Timestamp is the position in the song, in milliseconds, where the sample
+	// starts (OffsetMs rounded and clamped at zero).
+	Timestamp uint32
+	// Score is the number of aligned hashes; use Confidence to compare
+	// matches across queries.
+	Score float64
+
+	// OffsetMs is the song position at the start of the sample, refined below
+	// the 100 ms histogram bucket. It is negative when the sample starts
+	// before the song.
+	OffsetMs float64
+	// AlignedHashes is the number of sample hashes agreeing with OffsetMs.
+	AlignedHashes int
+	// MatchedFraction is AlignedHashes relative to the number of hashes in the
+	// sample.
+	MatchedFraction float64
+	// Confidence is a 0–1 estimate that the match is correct.
+	Confidence float64
+	// ZScore is how far the winning offset bucket rises above the background
+	// of the song's offset histogram, in standard deviations.
+	ZScore float64
+	// SpeedFactor is how much faster than the original the sample plays, e.g.
+	// 1.05 for a track sped up by 5%. It is always 1 unless the index uses
+	// HashTriplets.
+	SpeedFactor float64
 }
 
-// FindMatches analyzes the audio sample to find matching songs in the database.
-func FindMatches(audioSample []float64, audioDuration float64, sampleRate int) ([]Match, time.Duration, error) {
-	startTime := time.Now()
-
-	spectrogram, err := Spectrogram(audioSample, sampleRate)
-	if err != nil {
-		return nil, time.Since(startTime), fmt.Errorf("failed to get spectrogram of samples: %v", err)
-	}
-
-	peaks := ExtractPeaks(spectrogram, audioDuration, sampleRate)
-	// peaks := ExtractPeaksLMX(spectrogram, true)
-	sampleFingerprint := Fingerprint(peaks, utils.GenerateUniqueID())
-
-	sampleFingerprintMap := make(map[uint32]uint32)
-	for address, couple := range sampleFingerprint {
-		sampleFingerprintMap[address] = couple.AnchorTimeMs
-	}
-
-	matches, _, _ := FindMatchesFGP(sampleFingerprintMap)
-
-	return matches, time.Since(startTime), nil
-}
+const (
+	// offsetBucketMs is the width of the offset histogram buckets.
+	offsetBucketMs = 100
+	// offsetToleranceMs is how far from the refined offset a hash may be and
+	// still count as aligned.
+	offsetToleranceMs = 50
+
+	// confidenceHashScale and confidenceFractionScale calibrate Confidence:
+	// ~20 aligned hashes or 10% of the sample hashes each bring a factor
+	// close to its maximum.
+	confidenceHashScale     = 20.0
+	confidenceFractionScale = 0.1
+
+	// maxSpeedDeviation and speedStep define the grid of speed factors
+	// searched when the index is tempo-invariant: 0.90× to 1.10× in 0.25%
+	// steps, which keeps the alignment error of a 20 s sample within
+	// offsetToleranceMs.
+	maxSpeedDeviation = 0.10
+	speedStep         = 0.0025
+
+	// maxCandidateSongs caps the songs whose offsets are analysed to those
+	// sharing the most hashes with the sample. A song cannot align more
+	// hashes than it shares, and the speed search is costly per song.
+	maxCandidateSongs = 50
+)
 
-// FindMatchesFGP uses the sample fingerprint to find matching songs in the database.
-func FindMatchesFGP(sampleFingerprint map[uint32]uint32) ([]Match, time.Duration, error) {
-	startTime := time.Now()
+// matchFingerprints looks up the sample's addresses in the index and ranks
+// the songs by the number of aligned hashes. hashing is the scheme of the
+// index and decides whether speed factors are searched.
+func matchFingerprints(ctx context.Context, store Store, sampleFingerprint []models.Fingerprint, hashing HashScheme) ([]Match, error) {
 	logger := utils.GetLogger()
 
+	sampleTimes := make(map[uint32][]uint32) // address -> sample anchor times
 	addresses := make([]uint32, 0, len(sampleFingerprint))
-	for address := range sampleFingerprint {
-		addresses = append(addresses, address)
-	}
-
-	db, err := db.NewDBClient()
-	if err != nil {
-		return nil, time.Since(startTime), err
+	for _, fingerprint := range sampleFingerprint {
+		if _, ok := sampleTimes[fingerprint.Address]; !ok {
+			addresses = append(addresses, fingerprint.Address)
+		}
+		sampleTimes[fingerprint.Address] = append(sampleTimes[fingerprint.Address], fingerprint.AnchorTimeMs)
 	}
-	defer db.Close()
 
-	m, err := db.GetCouples(addresses)
+	m, err := store.GetCouples(ctx, addresses)
 	if err != nil {
-		return nil, time.Since(startTime), err
+		return nil, err
 	}
 
-	matches := map[uint32][][2]uint32{}        // songID -> [(sampleTime, dbTime)]
-	timestamps := map[uint32]uint32{}          // songID -> earliest timestamp
-	targetZones := map[uint32]map[uint32]int{} // songID -> timestamp -> count
+	matches := map[uint32][][2]uint32{} // songID -> [(sampleTime, dbTime)]
 
 	for address, couples := range m {
 		for _, couple := range couples {
-			matches[couple.SongID] = append(
-				matches[couple.SongID],
-				[2]uint32{sampleFingerprint[address], couple.AnchorTimeMs},
-			)
-
-			if existingTime, ok := timestamps[couple.SongID]; !ok || couple.AnchorTimeMs < existingTime {
-				timestamps[couple.SongID] = couple.AnchorTimeMs
+			for _, sampleTime := range sampleTimes[address] {
+				matches[couple.SongID] = append(
+					matches[couple.SongID],
+					[2]uint32{sampleTime, couple.AnchorTimeMs},
+				)
 			}
-
-			if _, ok := targetZones[couple.SongID]; !ok {
-				targetZones[couple.SongID] = make(map[uint32]int)
-			}
-			targetZones[couple.SongID][couple.AnchorTimeMs]++
 		}
 	}
 
-	// matches = filterMatches(10, matches, targetZones)
-
-	scores := analyzeRelativeTiming(matches)
+	pruneCandidates(matches, maxCandidateSongs)
+	scores := analyzeRelativeTiming(matches, speedCandidates(hashing))
 
 	var matchList []Match
 
-	for songID, points := range scores {
-		song, songExists, err := db.GetSongByID(songID)
+	for songID, score := range scores {
+		song, songExists, err := store.GetSongByID(ctx, songID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get song by ID (%v): %v", songID, err)
+		}
 		if !songExists {
 			logger.Info(fmt.Sprintf("song with ID (%v) doesn't exist", songID))
 			continue
 		}
-		if err != nil {
-			logger.Info(fmt.Sprintf("failed to get song by ID (%v): %v", songID, err))
-			continue
-		}
 
-		match := Match{songID, song.Title, song.Artist, song.YouTubeID, timestamps[songID], points}
+		fraction := math.Min(1, float64(score.aligned)/float64(max(len(sampleFingerprint), 1)))
+		match := Match{
+			SongID:           songID,
+			SongTitle:        song.Title,
+			SongArtist:       song.Artist,
+			YouTubeID:        song.YouTubeID,
+			Album:            song.Album,
+			Artists:          song.Artists,
+			Duration:         song.Duration,
+			SpotifyID:        song.SpotifyID,
+			ISRC:             song.ISRC,
+			FilePath:         song.FilePath,
+			DateAdded:        song.DateAdded,
+			FingerprintCount: song.FingerprintCount,
+			Timestamp:        uint32(math.Max(0, math.Round(score.offsetMs))),
+			Score:            float64(score.aligned),
+			OffsetMs:         score.offsetMs,
+			AlignedHashes:    score.aligned,
+			MatchedFraction:  fraction,
+			Confidence:       matchConfidence(score.aligned, fraction),
+			ZScore:           score.zScore,
+			SpeedFactor:      score.speed,
+		}
 		matchList = append(matchList, match)
 	}
 
+	// Ties are broken so that the ranking does not follow the map order.
 	sort.Slice(matchList, func(i, j int) bool {
-		return matchList[i].Score > matchList[j].Score
+		a, b := matchList[i], matchList[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Confidence != b.Confidence {
+			return a.Confidence > b.Confidence
+		}
+		return a.SongID < b.SongID
 	})
 
-	return matchList, time.Since(startTime), nil
+	return matchList, nil
 }
 
-// filterMatches filters out matches that don't have enough
-// target zones to meet the specified threshold
-func filterMatches(
-	threshold int,
-	matches map[uint32][][2]uint32,
-	targetZones map[uint32]map[uint32]int) map[uint32][][2]uint32 {
-
-	// Filter out non target zones.
-	// When a target zone has less than `targetZoneSize` anchor times, it is not considered a target zone.
-	for songID, anchorTimes := range targetZones {
-		for anchorTime, count := range anchorTimes {
-			if count < targetZoneSize {
-				delete(targetZones[songID], anchorTime)
-			}
-		}
+// pruneCandidates keeps the limit songs of matches with the most votes, the
+// lowest IDs breaking ties.
+func pruneCandidates(matches map[uint32][][2]uint32, limit int) {
+	if len(matches) <= limit {
+		return
 	}
 
-	filteredMatches := map[uint32][][2]uint32{}
-	for songID, zones := range targetZones {
-		if len(zones) >= threshold {
-			filteredMatches[songID] = matches[songID]
+	songIDs := make([]uint32, 0, len(matches))
+	for songID := range matches {
+		songIDs = append(songIDs, songID)
+	}
+	sort.Slice(songIDs, func(i, j int) bool {
+		a, b := len(matches[songIDs[i]]), len(matches[songIDs[j]])
+		if a != b {
+			return a > b
 		}
+		return songIDs[i] < songIDs[j]
+	})
+	for _, songID := range songIDs[limit:] {
+		delete(matches, songID)
+	}
+}
+
+// timingScore summarises how well the hashes a song shares with the sample
+// line up in time.
+type timingScore struct {
+	aligned  int     // hashes agreeing with offsetMs
+	offsetMs float64 // song position at the start of the sample
+	zScore   float64 // significance of the winning bucket, see offsetZScore
+	speed    float64 // speed factor the offsets were computed with
+	spread   float64 // mean distance of the aligned offsets from offsetMs
+}
+
+// speedCandidates returns the speed factors to try when aligning a sample
+// fingerprinted with the given scheme. Only triplet hashes survive a change
+// of speed, so pair hashes are aligned at 1× alone.
+func speedCandidates(hashing HashScheme) []float64 {
+	if hashing != HashTriplets {
+		return []float64{1}
 	}
 
-	return filteredMatches
+	steps := int(math.Round(maxSpeedDeviation / speedStep))
+	speeds := make([]float64, 0, 2*steps+1)
+	speeds = append(speeds, 1) // first, so ties favour the original speed
+	for i := 1; i <= steps; i++ {
+		speeds = append(speeds, 1+float64(i)*speedStep, 1-float64(i)*speedStep)
+	}
+	return speeds
 }
 
 // analyzeRelativeTiming calculates a score for each song based on the
 // consistency of time offsets between the sample and database.
-func analyzeRelativeTiming(matches map[uint32][][2]uint32) map[uint32]float64 {
-	scores := make(map[uint32]float64)
-
+// For every candidate speed s, the offset of a hash is dbTime - s*sampleTime.
+// Offsets are histogrammed in 100 ms buckets; the winning bucket and its
+// neighbours are then refined to the median offset, and the hashes within
+// offsetToleranceMs of it are counted as aligned. The speed aligning the most
+// hashes wins; among equals, the one aligning them most tightly.
+func analyzeRelativeTiming(matches map[uint32][][2]uint32, speeds []float64) map[uint32]timingScore {
+	scores := make(map[uint32]timingScore)
+
+	offsets := []float64{}
 	for songID, times := range matches {
-		offsetCounts := make(map[int32]int)
+		var best timingScore
+		for _, speed := range speeds {
+			offsets = offsets[:0]
+			for _, timePair := range times {
+				sampleTime := float64(timePair[0])
+				dbTime := float64(timePair[1])
+				offsets = append(offsets, dbTime-speed*sampleTime)
+			}
+
+			score := scoreOffsets(offsets, speed)
+			if score.aligned > best.aligned || (score.aligned == best.aligned && score.spread < best.spread) {
+				best = score
+			}
+		}
+		scores[songID] = best
+	}
+
+	return scores
+}
 
-		for _, timePair := range times {
-			sampleTime := int32(timePair[0])
-			dbTime := int32(timePair[1])
-			offset := dbTime - sampleTime
+// scoreOffsets finds the dominant offset among the votes of one song.
+func scoreOffsets(offsets []float64, speed float64) timingScore {
+	offsetCounts := make(map[int64]int)
+	minOffset, maxOffset := math.Inf(1), math.Inf(-1)
 
-			// Bin offsets in 100ms buckets to allow for small timing variations
-			offsetBucket := offset / 100
-			offsetCounts[offsetBucket]++
+	for _, offset := range offsets {
+		minOffset = math.Min(minOffset, offset)
+		maxOffset = math.Max(maxOffset, offset)
+
+		// Bin offsets in 100ms buckets to allow for small timing variations
+		offsetCounts[offsetBucket(offset)]++
+	}
+
+	var bestBucket int64
+	maxCount := 0
+	for bucket, count := range offsetCounts {
+		if count > maxCount || (count == maxCount && bucket < bestBucket) {
+			bestBucket, maxCount = bucket, count
 		}
+	}
 
-		maxCount := 0
-		for _, count := range offsetCounts {
-			if count > maxCount {
-				maxCount = count
-			}
+	var window []float64
+	for _, offset := range offsets {
+		if b := offsetBucket(offset); b >= bestBucket-1 && b <= bestBucket+1 {
+			window = append(window, offset)
+		}
+	}
+	sort.Float64s(window)
+	median := window[len(window)/2]
+
+	var alignedOffsets []float64
+	var sum float64
+	for _, offset := range window {
+		if math.Abs(offset-median) <= offsetToleranceMs {
+			alignedOffsets = append(alignedOffsets, offset)
+			sum += offset
 		}
+	}
+	aligned := len(alignedOffsets)
+	mean := sum / float64(aligned)
 
-		scores[songID] = float64(maxCount)
+	var spread float64
+	for _, offset := range alignedOffsets {
+		spread += math.Abs(offset - mean)
 	}
 
-	return scores
+	return timingScore{
+		aligned:  aligned,
+		offsetMs: mean,
+		zScore:   offsetZScore(aligned, len(offsets), minOffset, maxOffset),
+		speed:    speed,
+		spread:   spread / float64(aligned),
+	}
+}
+
+// matchConfidence maps the number of aligned hashes and the fraction of the
+// sample they represent to a 0–1 confidence. Both factors saturate, so a long
+// clean sample and a short noisy one can both reach high confidence, while a
+// handful of chance alignments stays close to zero.
+func matchConfidence(aligned int, fraction float64) float64 {
+	hashFactor := 1 - math.Exp(-float64(aligned)/confidenceHashScale)
+	fractionFactor := 1 - math.Exp(-fraction/confidenceFractionScale)
+	return hashFactor * fractionFactor
+}
+
+// offsetBucket returns the histogram bucket of an offset. It rounds towards
+// negative infinity, so that negative offsets get buckets of the same width
+// as positive ones.
+func offsetBucket(offsetMs float64) int64 {
+	return int64(math.Floor(offsetMs / offsetBucketMs))
 }
@@ -0,0 +1,83 @@
+package shazam
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeRelativeTimingRefinesOffset(t *testing.T) {
+	// Forty hashes aligned at an offset of 92 340 ms with ±10 ms jitter, which
+	// straddles two 100 ms buckets, plus scattered noise.
+	var pairs [][2]uint32
+	for i := 0; i < 40; i++ {
+		sampleTime := uint32(i * 250)
+		jitter := uint32(i%21) - 10
+		pairs = append(pairs, [2]uint32{sampleTime, sampleTime + 92340 + jitter})
+	}
+	for i := 0; i < 10; i++ {
+		pairs = append(pairs, [2]uint32{uint32(i * 700), uint32(i * 13_000)})
+	}
+
+	score := analyzeRelativeTiming(map[uint32][][2]uint32{1: pairs}, speedCandidates(HashPairs))[1]
+	if score.aligned != 40 {
+		t.Errorf("aligned = %d, want 40", score.aligned)
+	}
+	if math.Abs(score.offsetMs-92340) > 5 {
+		t.Errorf("offset = %.1f ms, want ~92340", score.offsetMs)
+	}
+}
+
+func TestAnalyzeRelativeTimingNegativeOffset(t *testing.T) {
+	// The sample starts 1.5 s before the song.
+	var pairs [][2]uint32
+	for i := 0; i < 20; i++ {
+		pairs = append(pairs, [2]uint32{uint32(1500 + i*100), uint32(i * 100)})
+	}
+
+	score := analyzeRelativeTiming(map[uint32][][2]uint32{1: pairs}, speedCandidates(HashPairs))[1]
+	if score.aligned != 20 || score.offsetMs != -1500 {
+		t.Errorf("got %d aligned at %.1f ms, want 20 at -1500", score.aligned, score.offsetMs)
+	}
+}
+
+func TestMatchConfidence(t *testing.T) {
+	if c := matchConfidence(3, 0.005); c > 0.05 {
+		t.Errorf("chance alignment has confidence %.3f", c)
+	}
+	if c := matchConfidence(300, 0.4); c < 0.95 {
+		t.Errorf("strong match has confidence %.3f", c)
+	}
+	if matchConfidence(50, 0.1) >= matchConfidence(100, 0.1) {
+		t.Error("confidence must grow with the number of aligned hashes")
+	}
+}
+
+func TestPruneCandidatesKeepsMostVotes(t *testing.T) {
+	matches := map[uint32][][2]uint32{}
+	for songID := uint32(1); songID <= 10; songID++ {
+		matches[songID] = make([][2]uint32, songID%5)
+	}
+
+	pruneCandidates(matches, 3)
+	if len(matches) != 3 {
+		t.Fatalf("kept %d songs, want 3", len(matches))
+	}
+	for _, songID := range []uint32{4, 9, 3} {
+		if _, ok := matches[songID]; !ok {
+			t.Errorf("song %d with %d votes was pruned", songID, songID%5)
+		}
+	}
+}
+
+// Matches are sent to browsers, which must not learn the server's paths.
+func TestMatchJSONOmitsFilePath(t *testing.T) {
+	data, err := json.Marshal(Match{SongTitle: "Title", FilePath: "/srv/songs/Title.wav"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "/srv/songs") {
+		t.Errorf("file path in %s", data)
+	}
+}
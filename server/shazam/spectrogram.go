@@ -1,32 +1,45 @@
 package shazam
 
 import (
-	"errors"
 	"fmt"
 	"math"
 	"math/cmplx"
 )
 
-const (
-	dspRatio   = 4
-	windowSize = 1024
-	maxFreq    = 5000.0         // 5kHz
-	hopSize    = windowSize / 2 // 50% overlap for better time-frequency resolution
-	windowType = "hanning"      // choices: "hanning" or "hamming"
-)
-
-func Spectrogram(sample []float64, sampleRate int) ([][]float64, error) {
-	filteredSample := LowPassFilter(maxFreq, float64(sampleRate), sample)
-
-	downsampledSample, err := Downsample(filteredSample, sampleRate, sampleRate/dspRatio)
+// Spectrogram resamples the input to the analysis rate of cfg and computes
+// its short-time magnitude spectrum, one frame every cfg.HopSize samples.
+func Spectrogram(sample []float64, sampleRate int, cfg FingerprintConfig) ([][]float64, error) {
+	downsampledSample, err := Resample(sample, sampleRate, cfg.SampleRate, cfg.MaxFreq)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't downsample audio sample: %v", err)
+		return nil, fmt.Errorf("couldn't resample audio sample: %v", err)
 	}
 
+	spectrogram := newSTFT(cfg).push(downsampledSample)
+	if spectrogram == nil {
+		spectrogram = make([][]float64, 0)
+	}
+	return spectrogram, nil
+}
+
+// stft computes magnitude frames from samples at the analysis rate. Samples
+// may be pushed in chunks of any size; the frames are the same as for the
+// concatenated input.
+type stft struct {
+	window    []float64
+	hopSize   int
+	plan      *FFTPlan
+	frame     []float64
+	fftResult []complex128
+	pending   []float64 // samples not yet covered by a complete frame
+}
+
+func newSTFT(cfg FingerprintConfig) *stft {
+	windowSize := cfg.WindowSize
+
 	window := make([]float64, windowSize)
 	for i := range window {
 		theta := 2 * math.Pi * float64(i) / float64(windowSize-1)
-		switch windowType {
+		switch cfg.WindowType {
 		case "hamming":
 			window[i] = 0.54 - 0.46*math.Cos(theta)
 		default: // Hanning window
@@ -34,89 +47,55 @@ func Spectrogram(sample []float64, sampleRate int) ([][]float64, error) {
 		}
 	}
 
-	// Initialize spectrogram slice
-	spectrogram := make([][]float64, 0)
+	return &stft{
+		window:    window,
+		hopSize:   cfg.HopSize,
+		plan:      NewFFTPlan(windowSize),
+		frame:     make([]float64, windowSize),
+		fftResult: make([]complex128, windowSize/2+1),
+	}
+}
 
-	// Perform STFT
-	for start := 0; start+windowSize <= len(downsampledSample); start += hopSize {
-		end := start + windowSize
+// push appends samples and returns the magnitude frames completed by them.
+func (s *stft) push(samples []float64) [][]float64 {
+	data := samples
+	if len(s.pending) > 0 {
+		data = append(s.pending, samples...)
+	}
+
+	windowSize := len(s.window)
+	var spectrogram [][]float64
 
-		frame := make([]float64, windowSize)
-		copy(frame, downsampledSample[start:end])
+	// Perform STFT
+	start := 0
+	for ; start+windowSize <= len(data); start += s.hopSize {
+		copy(s.frame, data[start:start+windowSize])
 
 		// Apply window
-		for j := range window {
-			frame[j] *= window[j]
+		for j := range s.window {
+			s.frame[j] *= s.window[j]
 		}
 
 		// Perform FFT
-		fftResult := FFT(frame)
+		s.plan.RealTransform(s.fftResult, s.frame)
 
 		// Convert complex spectrum to magnitude spectrum
-		magnitude := make([]float64, len(fftResult)/2)
+		magnitude := make([]float64, windowSize/2)
 		for j := range magnitude {
-			magnitude[j] = cmplx.Abs(fftResult[j])
+			magnitude[j] = cmplx.Abs(s.fftResult[j])
 		}
 
 		spectrogram = append(spectrogram, magnitude)
 	}
 
-	return spectrogram, nil
-}
-
-// LowPassFilter is a first-order low-pass filter that attenuates high
-// frequencies above the cutoffFrequency.
-// It uses the transfer function H(s) = 1 / (1 + sRC), where RC is the time constant.
-func LowPassFilter(cutoffFrequency, sampleRate float64, input []float64) []float64 {
-	rc := 1.0 / (2 * math.Pi * cutoffFrequency)
-	dt := 1.0 / sampleRate
-	alpha := dt / (rc + dt)
-
-	filteredSignal := make([]float64, len(input))
-	var prevOutput float64 = 0
-
-	for i, x := range input {
-		if i == 0 {
-			filteredSignal[i] = x * alpha
-		} else {
-
-			filteredSignal[i] = alpha*x + (1-alpha)*prevOutput
-		}
-		prevOutput = filteredSignal[i]
+	// Keep the samples the next frames still need.
+	if start < len(data) {
+		s.pending = append(s.pending[:0:0], data[start:]...)
+	} else {
+		s.pending = nil
 	}
-	return filteredSignal
-}
 
-// Downsample downsamples the input audio from originalSampleRate to targetSampleRate
-func Downsample(input []float64, originalSampleRate, targetSampleRate int) ([]float64, error) {
-	if targetSampleRate <= 0 || originalSampleRate <= 0 {
-		return nil, errors.New("sample rates must be positive")
-	}
-	if targetSampleRate > originalSampleRate {
-		return nil, errors.New("target sample rate must be less than or equal to original sample rate")
-	}
-
-	ratio := originalSampleRate / targetSampleRate
-	if ratio <= 0 {
-		return nil, errors.New("invalid ratio calculated from sample rates")
-	}
-
-	var resampled []float64
-	for i := 0; i < len(input); i += ratio {
-		end := i + ratio
-		if end > len(input) {
-			end = len(input)
-		}
-
-		sum := 0.0
-		for j := i; j < end; j++ {
-			sum += input[j]
-		}
-		avg := sum / float64(end-i)
-		resampled = append(resampled, avg)
-	}
-
-	return resampled, nil
+	return spectrogram
 }
 
 // Peak represents a significant point in the spectrogram.
@@ -126,65 +105,71 @@ type Peak struct {
 }
 
 // ExtractPeaks analyzes a spectrogram and extracts significant peaks in the frequency domain over time.
-func ExtractPeaks(spectrogram [][]float64, audioDuration float64, sampleRate int) []Peak {
+// It keeps the strongest bin of each of cfg.Bands per frame when it exceeds the
+// average of the band maxima. Frame times and bin frequencies are derived from
+// the analysis rate, so the result does not depend on the rate of the original recording.
+func ExtractPeaks(spectrogram [][]float64, cfg FingerprintConfig) []Peak {
 	if len(spectrogram) < 1 {
 		return []Peak{}
 	}
 
+	var peaks []Peak
+	for frameIdx, frame := range spectrogram {
+		peaks = append(peaks, bandPeaks(frameIdx, frame, cfg)...)
+	}
+
+	return peaks
+}
+
+// bandPeaks returns the band peaks of a single spectrogram frame.
+func bandPeaks(frameIdx int, frame []float64, cfg FingerprintConfig) []Peak {
 	type maxies struct {
 		maxMag  float64
 		freqIdx int
 	}
 
-	bands := []struct{ min, max int }{
-		{0, 10}, {10, 20}, {20, 40}, {40, 80}, {80, 160}, {160, 512},
-	}
-
 	var peaks []Peak
-	frameDuration := audioDuration / float64(len(spectrogram))
+	frameDuration := cfg.frameDuration()
 
 	// Calculate frequency resolution (Hz per bin)
-	effectiveSampleRate := float64(sampleRate) / float64(dspRatio)
-	freqResolution := effectiveSampleRate / float64(windowSize)
-
-	for frameIdx, frame := range spectrogram {
-		var maxMags []float64
-		var freqIndices []int
-
-		binBandMaxies := []maxies{}
-		for _, band := range bands {
-			var maxx maxies
-			var maxMag float64
-			for idx, mag := range frame[band.min:band.max] {
-				if mag > maxMag {
-					maxMag = mag
-					freqIdx := band.min + idx
-					maxx = maxies{mag, freqIdx}
-				}
+	freqResolution := cfg.freqResolution()
+
+	var maxMags []float64
+	var freqIndices []int
+
+	binBandMaxies := []maxies{}
+	for _, band := range cfg.Bands {
+		var maxx maxies
+		var maxMag float64
+		for idx, mag := range frame[band.Min:band.Max] {
+			if mag > maxMag {
+				maxMag = mag
+				freqIdx := band.Min + idx
+				maxx = maxies{mag, freqIdx}
 			}
-			binBandMaxies = append(binBandMaxies, maxx)
 		}
+		binBandMaxies = append(binBandMaxies, maxx)
+	}
 
-		for _, value := range binBandMaxies {
-			maxMags = append(maxMags, value.maxMag)
-			freqIndices = append(freqIndices, value.freqIdx)
-		}
+	for _, value := range binBandMaxies {
+		maxMags = append(maxMags, value.maxMag)
+		freqIndices = append(freqIndices, value.freqIdx)
+	}
 
-		// Calculate the average magnitude
-		var maxMagsSum float64
-		for _, max := range maxMags {
-			maxMagsSum += max
-		}
-		avg := maxMagsSum / float64(len(maxMags))
+	// Calculate the average magnitude
+	var maxMagsSum float64
+	for _, max := range maxMags {
+		maxMagsSum += max
+	}
+	avg := maxMagsSum / float64(len(maxMags))
 
-		// Add peaks that exceed the average magnitude
-		for i, value := range maxMags {
-			if value > avg {
-				peakTime := float64(frameIdx) * frameDuration
-				peakFreq := float64(freqIndices[i]) * freqResolution
+	// Add peaks that exceed the average magnitude
+	for i, value := range maxMags {
+		if value > avg {
+			peakTime := float64(frameIdx) * frameDuration
+			peakFreq := float64(freqIndices[i]) * freqResolution
 
-				peaks = append(peaks, Peak{Time: peakTime, Freq: peakFreq})
-			}
+			peaks = append(peaks, Peak{Time: peakTime, Freq: peakFreq})
 		}
 	}
 
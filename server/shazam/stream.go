@@ -0,0 +1,225 @@
+package shazam
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"song-recognition/models"
+)
+
+// StreamFingerprinter fingerprints a mono signal pushed in chunks of any
+// size. Fingerprints are returned as soon as the target zone of their anchor
+// is complete, and are the same as Fingerprint produces for the spectrogram
+// of the whole signal, so streamed and batch fingerprints share an index.
+// Memory use does not grow with the length of the input.
+type StreamFingerprinter struct {
+	cfg       FingerprintConfig
+	songID    uint32
+	resampler *Resampler
+	stft      *stft
+	picker    peakStream
+	peaks     []Peak // anchors whose target zone is not complete yet
+	flushed   bool
+}
+
+// NewStreamFingerprinter returns a fingerprinter for samples at sampleRate.
+// Anchor times are relative to the first pushed sample.
+func NewStreamFingerprinter(sampleRate int, songID uint32, cfg FingerprintConfig) (*StreamFingerprinter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid fingerprint config: %v", err)
+	}
+
+	resampler, err := NewResampler(sampleRate, cfg.SampleRate, cfg.MaxFreq)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create resampler: %v", err)
+	}
+
+	return &StreamFingerprinter{
+		cfg:       cfg,
+		songID:    songID,
+		resampler: resampler,
+		stft:      newSTFT(cfg),
+		picker:    newPeakStream(cfg),
+	}, nil
+}
+
+// Push consumes samples and returns the fingerprints they completed.
+func (f *StreamFingerprinter) Push(samples []float64) []models.Fingerprint {
+	if f.flushed {
+		return nil
+	}
+	return f.addFrames(nil, f.stft.push(f.resampler.Process(samples)))
+}
+
+// Flush ends the stream and returns the remaining fingerprints; anchors near
+// the end get the shortened target zones of the batch path. Push and Flush
+// return nothing afterwards.
+func (f *StreamFingerprinter) Flush() []models.Fingerprint {
+	if f.flushed {
+		return nil
+	}
+	f.flushed = true
+
+	fingerprints := f.addFrames(nil, f.stft.push(f.resampler.Flush()))
+	fingerprints = f.addPeaks(fingerprints, f.picker.flush())
+	for i, anchor := range f.peaks {
+		fingerprints = appendAnchorFingerprints(fingerprints, anchor, f.peaks[i+1:], f.songID, f.cfg)
+	}
+	f.peaks = nil
+
+	return fingerprints
+}
+
+func (f *StreamFingerprinter) addFrames(fingerprints []models.Fingerprint, frames [][]float64) []models.Fingerprint {
+	for _, frame := range frames {
+		fingerprints = f.addPeaks(fingerprints, f.picker.push(frame))
+	}
+	return fingerprints
+}
+
+// addPeaks queues peaks and fingerprints every anchor whose target zone they
+// completed.
+func (f *StreamFingerprinter) addPeaks(fingerprints []models.Fingerprint, peaks []Peak) []models.Fingerprint {
+	if len(peaks) == 0 {
+		return fingerprints
+	}
+	f.peaks = append(f.peaks, peaks...)
+
+	zone := f.cfg.TargetZoneSize
+	done := 0
+	for ; done+zone < len(f.peaks); done++ {
+		fingerprints = appendAnchorFingerprints(fingerprints, f.peaks[done], f.peaks[done+1:done+1+zone], f.songID, f.cfg)
+	}
+	f.peaks = append(f.peaks[:0], f.peaks[done:]...)
+
+	return fingerprints
+}
+
+// horizonMs returns a time no future fingerprint will be anchored before.
+func (f *StreamFingerprinter) horizonMs() uint32 {
+	if len(f.peaks) > 0 {
+		return uint32(f.peaks[0].Time * 1000)
+	}
+	return uint32(float64(f.picker.firstUndecided()) * f.cfg.frameDuration() * 1000)
+}
+
+// pcmChunkFrames is the number of sample frames FingerprintPCM reads at once.
+const pcmChunkFrames = 4096
+
+// FingerprintPCM reads interleaved signed 16-bit little-endian PCM from r
+// until EOF and calls emit with fingerprints as they become available. Like
+// FingerprintAudio, every channel is fingerprinted and fingerprints already
+// produced by another channel are skipped. An error from emit stops reading
+// and is returned.
+func FingerprintPCM(r io.Reader, sampleRate, channels int, songID uint32, cfg FingerprintConfig, emit func([]models.Fingerprint) error) error {
+	if channels < 1 {
+		return errors.New("number of channels must be positive")
+	}
+
+	streams := make([]*StreamFingerprinter, channels)
+	for c := range streams {
+		stream, err := NewStreamFingerprinter(sampleRate, songID, cfg)
+		if err != nil {
+			return err
+		}
+		streams[c] = stream
+	}
+	merger := newChannelMerger(channels)
+
+	frameBytes := 2 * channels
+	buf := make([]byte, pcmChunkFrames*frameBytes)
+	samples := make([][]float64, channels)
+	pending := 0 // bytes of an incomplete frame carried over to the next read
+
+	for {
+		n, readErr := io.ReadAtLeast(r, buf[pending:], frameBytes-pending)
+		n += pending
+		frames := n / frameBytes
+
+		for c := range samples {
+			samples[c] = samples[c][:0]
+		}
+		for i := 0; i < frames; i++ {
+			for c := range samples {
+				v := int16(binary.LittleEndian.Uint16(buf[i*frameBytes+2*c:]))
+				samples[c] = append(samples[c], float64(v)/32768)
+			}
+		}
+		pending = copy(buf, buf[frames*frameBytes:n])
+
+		var batch []models.Fingerprint
+		for c, stream := range streams {
+			batch = merger.add(batch, stream.Push(samples[c]))
+		}
+		merger.forget(streams)
+		if len(batch) > 0 {
+			if err := emit(batch); err != nil {
+				return err
+			}
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return fmt.Errorf("error reading PCM stream: %v", readErr)
+		}
+	}
+
+	var batch []models.Fingerprint
+	for _, stream := range streams {
+		batch = merger.add(batch, stream.Flush())
+	}
+	if len(batch) > 0 {
+		return emit(batch)
+	}
+	return nil
+}
+
+// channelMerger drops fingerprints that another channel already produced.
+// A single channel is passed through unchanged, as in the batch path.
+type channelMerger struct {
+	seen map[models.Fingerprint]struct{} // nil for a single channel
+}
+
+func newChannelMerger(channels int) *channelMerger {
+	if channels == 1 {
+		return &channelMerger{}
+	}
+	return &channelMerger{seen: make(map[models.Fingerprint]struct{})}
+}
+
+func (m *channelMerger) add(dst []models.Fingerprint, fingerprints []models.Fingerprint) []models.Fingerprint {
+	if m.seen == nil {
+		return append(dst, fingerprints...)
+	}
+
+	for _, fp := range fingerprints {
+		if _, ok := m.seen[fp]; ok {
+			continue
+		}
+		m.seen[fp] = struct{}{}
+		dst = append(dst, fp)
+	}
+	return dst
+}
+
+// forget drops occurrences anchored before every stream's horizon, which no
+// channel can produce again, so memory stays bounded.
+func (m *channelMerger) forget(streams []*StreamFingerprinter) {
+	if m.seen == nil {
+		return
+	}
+
+	horizon := uint32(math.MaxUint32)
+	for _, stream := range streams {
+		horizon = min(horizon, stream.horizonMs())
+	}
+	for fp := range m.seen {
+		if fp.AnchorTimeMs < horizon {
+			delete(m.seen, fp)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package shazam
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"reflect"
+	"song-recognition/models"
+	"testing"
+)
+
+// melody returns a signal of random tones over noise, which gives both
+// pickers plenty of peaks.
+func melody(sampleRate int, seconds float64, seed int64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	x := randomSignal(int(seconds*float64(sampleRate)), seed)
+	noteLen := sampleRate / 4
+	for start := 0; start < len(x); start += noteLen {
+		freq := 200 + rng.Float64()*3000
+		for i := start; i < min(start+noteLen, len(x)); i++ {
+			x[i] = 0.05*x[i] + 0.8*math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate))
+		}
+	}
+	return x
+}
+
+func batchFingerprint(t *testing.T, x []float64, sampleRate int, cfg FingerprintConfig) []models.Fingerprint {
+	t.Helper()
+	spectro, err := Spectrogram(x, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Fingerprint(ExtractPeaksWith(spectro, cfg), 7, cfg)
+}
+
+func TestStreamFingerprinterMatchesBatch(t *testing.T) {
+	triplets := DefaultConfig()
+	triplets.Hashing = HashTriplets
+
+	configs := map[string]FingerprintConfig{
+		"bands":    DefaultConfig(),
+		"lmx":      lmxConfig(DefaultLMXPeakOptions()),
+		"triplets": triplets,
+	}
+
+	x := melody(44100, 6, 1)
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			want := batchFingerprint(t, x, 44100, cfg)
+
+			stream, err := NewStreamFingerprinter(44100, 7, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []models.Fingerprint
+			for start, size := 0, 1; start < len(x); size = size*2 + 3 {
+				end := min(start+size, len(x))
+				got = append(got, stream.Push(x[start:end])...)
+				start = end
+			}
+			got = append(got, stream.Flush()...)
+
+			if len(want) == 0 {
+				t.Fatal("batch path produced no fingerprints")
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("streamed %d fingerprints differ from the %d of the batch path", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestStreamFingerprinterEmitsEarly(t *testing.T) {
+	x := melody(44100, 10, 2)
+	stream, err := NewStreamFingerprinter(44100, 7, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fps := stream.Push(x[:len(x)/2]); len(fps) == 0 {
+		t.Fatal("no fingerprints before the end of the stream")
+	}
+	if n := len(stream.peaks); n > DefaultConfig().TargetZoneSize+len(DefaultConfig().Bands) {
+		t.Errorf("%d peaks buffered", n)
+	}
+}
+
+func TestFingerprintPCMStereo(t *testing.T) {
+	cfg := DefaultConfig()
+	left, right := melody(22050, 5, 3), melody(22050, 5, 4)
+	copy(right[:len(right)/2], left) // identical first half to exercise deduplication
+
+	var pcm bytes.Buffer
+	quantize := func(v float64) int16 { return int16(math.Round(v * 16384)) }
+	for i := range left {
+		binary.Write(&pcm, binary.LittleEndian, [2]int16{quantize(left[i]), quantize(right[i])})
+	}
+	for i := range left {
+		left[i] = float64(quantize(left[i])) / 32768
+		right[i] = float64(quantize(right[i])) / 32768
+	}
+
+	want := MergeFingerprints(batchFingerprint(t, left, 22050, cfg), batchFingerprint(t, right, 22050, cfg))
+
+	var got []models.Fingerprint
+	// An odd read size splits sample frames across reads.
+	err := FingerprintPCM(&oddReader{r: &pcm}, 22050, 2, 7, cfg, func(fps []models.Fingerprint) error {
+		got = append(got, fps...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d fingerprints, want %d", len(got), len(want))
+	}
+	wantSet := make(map[models.Fingerprint]bool, len(want))
+	for _, fp := range want {
+		wantSet[fp] = true
+	}
+	for _, fp := range got {
+		if !wantSet[fp] {
+			t.Fatalf("unexpected fingerprint %+v", fp)
+		}
+	}
+}
+
+// oddReader returns at most 1001 bytes per read.
+type oddReader struct {
+	r *bytes.Buffer
+}
+
+func (o *oddReader) Read(p []byte) (int, error) {
+	return o.r.Read(p[:min(len(p), 1001)])
+}
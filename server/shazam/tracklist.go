@@ -0,0 +1,234 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// TracklistOptions configures how a long recording is split into queries and
+// how their results are joined into a timeline.
+type TracklistOptions struct {
+	// SegmentSeconds is the length of each query.
+	SegmentSeconds float64
+	// HopSeconds is the distance between the starts of two queries; a hop
+	// shorter than the segment makes them overlap.
+	HopSeconds float64
+	// MaxGapSeconds is the longest stretch without a detection that may
+	// separate two detections of the same song before they are treated as
+	// two plays.
+	MaxGapSeconds float64
+	// OffsetToleranceMs is how far the song position of a detection may stray
+	// from the one predicted by the previous detection and still continue it.
+	OffsetToleranceMs float64
+	// MinSegments drops entries detected in fewer segments.
+	MinSegments int
+	// Decision is applied to the matches of every segment.
+	Decision DecisionOptions
+}
+
+// DefaultTracklistOptions returns 10 s segments every 5 s, tolerating one
+// missed segment inside a song.
+func DefaultTracklistOptions() TracklistOptions {
+	return TracklistOptions{
+		SegmentSeconds:    10,
+		HopSeconds:        5,
+		MaxGapSeconds:     10,
+		OffsetToleranceMs: 1000,
+		MinSegments:       1,
+		Decision:          DefaultDecisionOptions(),
+	}
+}
+
+// TracklistEntry is a stretch of the recording during which one song plays.
+type TracklistEntry struct {
+	Start      float64 `json:"start"` // seconds into the recording
+	End        float64 `json:"end"`
+	SongID     uint32  `json:"songID"`
+	SongTitle  string  `json:"title"`
+	SongArtist string  `json:"artist"`
+	YouTubeID  string  `json:"youtubeID,omitempty"`
+	// SongOffsetMs is the position in the song at Start.
+	SongOffsetMs float64 `json:"songOffsetMs"`
+	// Confidence is the mean confidence of the segments the entry covers.
+	Confidence  float64 `json:"confidence"`
+	SpeedFactor float64 `json:"speedFactor"`
+	Segments    int     `json:"segments"`
+}
+
+// detection is the accepted match of one segment.
+type detection struct {
+	start, end float64 // seconds into the recording
+	match      Match
+}
+
+// Tracklist recognises the songs played in a long recording such as a DJ mix
+// or a broadcast. It queries overlapping segments and merges consecutive
+// detections of the same song into timeline entries ordered by start time.
+func Tracklist(ctx context.Context, recognizer *Recognizer, samples []float64, sampleRate int, opts TracklistOptions) ([]TracklistEntry, error) {
+	if opts.SegmentSeconds <= 0 || opts.HopSeconds <= 0 {
+		return nil, fmt.Errorf("segment and hop length must be positive")
+	}
+
+	segmentLen := int(opts.SegmentSeconds * float64(sampleRate))
+	hopLen := int(opts.HopSeconds * float64(sampleRate))
+	if segmentLen < 1 || hopLen < 1 {
+		return nil, fmt.Errorf("segment and hop length must be at least one sample (%g s at %d Hz)", 1/float64(sampleRate), sampleRate)
+	}
+
+	var detections []detection
+	for start := 0; start < len(samples); start += hopLen {
+		end := min(start+segmentLen, len(samples))
+		// A trailing segment shorter than a hop is already covered by the
+		// previous one.
+		if start > 0 && end-start < hopLen {
+			break
+		}
+
+		matches, _, err := recognizer.FindMatches(ctx, samples[start:end], sampleRate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to match segment at %.1fs: %v", float64(start)/float64(sampleRate), err)
+		}
+
+		decision := Decide(matches, opts.Decision)
+		if decision.NoMatch {
+			continue
+		}
+		detections = append(detections, detection{
+			start: float64(start) / float64(sampleRate),
+			end:   float64(end) / float64(sampleRate),
+			match: *decision.Match,
+		})
+	}
+
+	return mergeDetections(detections, opts), nil
+}
+
+// mergeDetections joins detections of the same song whose song positions
+// advance with the recording. Where two entries overlap, the earlier one is
+// cut at the start of the later.
+func mergeDetections(detections []detection, opts TracklistOptions) []TracklistEntry {
+	var entries []TracklistEntry
+	var last detection // last detection merged into entries[len(entries)-1]
+
+	for _, d := range detections {
+		if n := len(entries); n > 0 {
+			entry := &entries[n-1]
+			elapsedMs := (d.start - last.start) * 1000 * last.match.SpeedFactor
+			expectedOffset := last.match.OffsetMs + elapsedMs
+
+			if d.match.SongID == entry.SongID &&
+				d.start-last.end <= opts.MaxGapSeconds &&
+				math.Abs(d.match.OffsetMs-expectedOffset) <= opts.OffsetToleranceMs {
+				entry.End = d.end
+				entry.Confidence += d.match.Confidence
+				entry.Segments++
+				last = d
+				continue
+			}
+		}
+
+		entries = append(entries, TracklistEntry{
+			Start:        d.start,
+			End:          d.end,
+			SongID:       d.match.SongID,
+			SongTitle:    d.match.SongTitle,
+			SongArtist:   d.match.SongArtist,
+			YouTubeID:    d.match.YouTubeID,
+			SongOffsetMs: d.match.OffsetMs,
+			Confidence:   d.match.Confidence,
+			SpeedFactor:  d.match.SpeedFactor,
+			Segments:     1,
+		})
+		last = d
+	}
+
+	var timeline []TracklistEntry
+	for _, entry := range entries {
+		if entry.Segments < opts.MinSegments {
+			continue
+		}
+		entry.Confidence /= float64(entry.Segments)
+
+		if n := len(timeline); n > 0 && timeline[n-1].End > entry.Start {
+			timeline[n-1].End = entry.Start
+		}
+		timeline = append(timeline, entry)
+	}
+
+	return timeline
+}
+
+// WriteTracklistText writes one line per entry:
+// "start - end  artist - title (from song position, confidence)".
+func WriteTracklistText(w io.Writer, entries []TracklistEntry) error {
+	for _, entry := range entries {
+		line := fmt.Sprintf("%s - %s  %s - %s (from %s, %.0f%% confident",
+			formatClock(entry.Start), formatClock(entry.End), entry.SongArtist, entry.SongTitle,
+			formatClock(entry.SongOffsetMs/1000), entry.Confidence*100)
+		if math.Abs(entry.SpeedFactor-1) > 1e-9 {
+			line += fmt.Sprintf(", %.1f%% speed", entry.SpeedFactor*100)
+		}
+		if _, err := fmt.Fprintln(w, line+")"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTracklistJSON writes the entries as an indented JSON array.
+func WriteTracklistJSON(w io.Writer, entries []TracklistEntry) error {
+	if entries == nil {
+		entries = []TracklistEntry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// WriteTracklistCUE writes a cue sheet for audioFile with one track per
+// entry, so players can seek between the songs of a mix.
+func WriteTracklistCUE(w io.Writer, entries []TracklistEntry, audioFile string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "FILE %s WAVE\n", cueQuote(audioFile))
+	for i, entry := range entries {
+		fmt.Fprintf(&b, "  TRACK %02d AUDIO\n", i+1)
+		fmt.Fprintf(&b, "    TITLE %s\n", cueQuote(entry.SongTitle))
+		fmt.Fprintf(&b, "    PERFORMER %s\n", cueQuote(entry.SongArtist))
+		fmt.Fprintf(&b, "    INDEX 01 %s\n", cueTime(entry.Start))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// formatClock renders seconds as h:mm:ss, or m:ss under an hour.
+func formatClock(seconds float64) string {
+	sign := ""
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	s := int(math.Round(seconds))
+	if s >= 3600 {
+		return fmt.Sprintf("%s%d:%02d:%02d", sign, s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%s%d:%02d", sign, s/60, s%60)
+}
+
+// cueTime renders seconds as the mm:ss:ff of cue sheets (75 frames a second).
+func cueTime(seconds float64) string {
+	frames := int(math.Round(seconds * 75))
+	return fmt.Sprintf("%02d:%02d:%02d", frames/(75*60), frames/75%60, frames%75)
+}
+
+// cueQuote quotes a cue sheet string; the format has no escape sequences, so
+// double quotes are replaced.
+func cueQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
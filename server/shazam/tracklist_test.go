@@ -0,0 +1,99 @@
+package shazam
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func segmentDetection(start float64, songID uint32, offsetMs float64) detection {
+	return detection{
+		start: start,
+		end:   start + 10,
+		match: Match{SongID: songID, SongTitle: "Song", SongArtist: "Artist", OffsetMs: offsetMs, Confidence: 0.8, SpeedFactor: 1},
+	}
+}
+
+func TestMergeDetections(t *testing.T) {
+	opts := DefaultTracklistOptions()
+	detections := []detection{
+		// Song 1 from 60 s into it, with one missed segment at 10 s.
+		segmentDetection(0, 1, 60_000),
+		segmentDetection(5, 1, 65_000),
+		segmentDetection(15, 1, 75_100),
+		// Song 2 fades in while song 1's last segment still overlaps.
+		segmentDetection(20, 2, 3_000),
+		segmentDetection(25, 2, 8_000),
+		// Song 2 again, but restarted: a second play.
+		segmentDetection(30, 2, 0),
+	}
+
+	entries := mergeDetections(detections, opts)
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3: %+v", len(entries), entries)
+	}
+
+	first := entries[0]
+	if first.SongID != 1 || first.Start != 0 || first.End != 20 || first.Segments != 3 || first.SongOffsetMs != 60_000 {
+		t.Errorf("first entry = %+v", first)
+	}
+	if second := entries[1]; second.SongID != 2 || second.Start != 20 || second.End != 30 || second.Segments != 2 {
+		t.Errorf("second entry = %+v", second)
+	}
+	if third := entries[2]; third.Start != 30 || third.End != 40 {
+		t.Errorf("third entry = %+v", third)
+	}
+
+	opts.MinSegments = 2
+	if entries := mergeDetections(detections, opts); len(entries) != 2 {
+		t.Errorf("MinSegments = 2 kept %d entries, want 2", len(entries))
+	}
+}
+
+// A hop shorter than a sample would never advance.
+func TestTracklistRejectsSubsampleHop(t *testing.T) {
+	opts := DefaultTracklistOptions()
+	opts.HopSeconds = 0.00001
+	if _, err := Tracklist(context.Background(), nil, make([]float64, 44100), 44100, opts); err == nil {
+		t.Error("accepted a hop shorter than a sample")
+	}
+}
+
+func TestWriteTracklistCUE(t *testing.T) {
+	entries := []TracklistEntry{
+		{Start: 0, SongTitle: `Say "Hi"`, SongArtist: "A"},
+		{Start: 201.5, SongTitle: "B", SongArtist: "C"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTracklistCUE(&buf, entries, "mix.mp3"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `FILE "mix.mp3" WAVE
+  TRACK 01 AUDIO
+    TITLE "Say 'Hi'"
+    PERFORMER "A"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "B"
+    PERFORMER "C"
+    INDEX 01 03:21:38
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteTracklistText(t *testing.T) {
+	entries := []TracklistEntry{{Start: 3725, End: 3900, SongTitle: "B", SongArtist: "C", SongOffsetMs: 42_000, Confidence: 0.91, SpeedFactor: 1.04}}
+
+	var buf bytes.Buffer
+	if err := WriteTracklistText(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	want := "1:02:05 - 1:05:00  C - B (from 0:42, 91% confident, 104.0% speed)\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,432 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"song-recognition/db"
+	"song-recognition/models"
+	"strings"
+)
+
+const (
+	// vizFramePx and vizBinPx are the size of a spectrogram cell in pixels.
+	vizFramePx = 2
+	vizBinPx   = 1
+	// vizMinWidth keeps the labels of short recordings readable.
+	vizMinWidth = 480
+	// vizHistogramPx is the height of the offset histogram.
+	vizHistogramPx = 160
+	// vizMarginPx is the space left for the label above each panel.
+	vizMarginPx = 24
+)
+
+var (
+	vizBackground = color.NRGBA{24, 24, 24, 255}
+	vizLabel      = color.NRGBA{220, 220, 220, 255}
+	vizPeak       = color.NRGBA{80, 220, 255, 255}
+	vizAligned    = color.NRGBA{60, 255, 120, 220}
+	vizMisaligned = color.NRGBA{255, 60, 60, 140}
+	vizBar        = color.NRGBA{150, 150, 150, 255}
+)
+
+// HashPair is a hash of a recording together with the peaks it was built from.
+type HashPair struct {
+	Anchor  Peak
+	Targets []Peak // one target for HashPairs, two for HashTriplets
+	Address uint32
+}
+
+// MatchTrace explains a match: which hashes of the query were found in the
+// song, and the offset every shared hash voted for.
+type MatchTrace struct {
+	Match Match
+	// Aligned hashes agree with Match.OffsetMs; Misaligned ones only occur in
+	// the song at other offsets.
+	Aligned    []HashPair
+	Misaligned []HashPair
+	// Offsets holds the vote of every pair of a query hash and a song hash,
+	// in milliseconds, at the speed of the match.
+	Offsets []float64
+}
+
+// Visualization is the spectrogram of a recording with its peaks and, once
+// TraceMatch has run, the hashes it shares with a song of the index.
+type Visualization struct {
+	Config      FingerprintConfig
+	Spectrogram [][]float64
+	Peaks       []Peak
+	Trace       *MatchTrace
+}
+
+// Visualize computes the spectrogram and peaks of a recording the way they are
+// fingerprinted with cfg.
+func Visualize(samples []float64, sampleRate int, cfg FingerprintConfig) (*Visualization, error) {
+	spectrogram, err := Spectrogram(samples, sampleRate, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spectrogram of samples: %v", err)
+	}
+
+	return &Visualization{
+		Config:      cfg,
+		Spectrogram: spectrogram,
+		Peaks:       ExtractPeaksWith(spectrogram, cfg),
+	}, nil
+}
+
+// TraceMatch queries the index with the recording and traces its match with
+// songID, or with the best match when songID is 0. The best match is traced
+// even when Decide would reject it, to explain false matches.
+func (v *Visualization) TraceMatch(ctx context.Context, dbClient db.DBClient, songID uint32) error {
+	if err := CheckIndexConfig(ctx, dbClient, v.Config.Version()); err != nil {
+		return err
+	}
+
+	pairs := hashPairs(v.Peaks, v.Config)
+	fingerprints := make([]models.Fingerprint, len(pairs))
+	var addresses []uint32
+	seen := make(map[uint32]bool)
+	for i, pair := range pairs {
+		fingerprints[i] = models.Fingerprint{Address: pair.Address, AnchorTimeMs: uint32(pair.Anchor.Time * 1000)}
+		if !seen[pair.Address] {
+			seen[pair.Address] = true
+			addresses = append(addresses, pair.Address)
+		}
+	}
+
+	matches, err := matchFingerprints(ctx, dbClient, fingerprints, v.Config.Hashing)
+	if err != nil {
+		return err
+	}
+
+	var match *Match
+	for i := range matches {
+		if songID == 0 || matches[i].SongID == songID {
+			match = &matches[i]
+			break
+		}
+	}
+	if match == nil {
+		if songID == 0 {
+			return errors.New("no song shares hashes with the recording")
+		}
+		return fmt.Errorf("song %d shares no hashes with the recording", songID)
+	}
+
+	couples, err := dbClient.GetCouples(ctx, addresses)
+	if err != nil {
+		return err
+	}
+
+	trace := traceMatch(pairs, couples, *match)
+	v.Trace = &trace
+	return nil
+}
+
+// hashPairs returns the hashes Fingerprint builds from peaks, in the same
+// order, together with their peaks.
+func hashPairs(peaks []Peak, cfg FingerprintConfig) []HashPair {
+	var pairs []HashPair
+
+	for i, anchor := range peaks {
+		targets := peaks[i+1 : min(i+1+cfg.TargetZoneSize, len(peaks))]
+
+		if cfg.Hashing != HashTriplets {
+			for _, target := range targets {
+				pairs = append(pairs, HashPair{anchor, []Peak{target}, createAddress(anchor, target, cfg)})
+			}
+			continue
+		}
+
+		if anchor.Freq <= 0 {
+			continue
+		}
+		for j := range targets {
+			for k := j + 1; k < len(targets); k++ {
+				if address, ok := createTripletAddress(anchor, targets[j], targets[k], cfg); ok {
+					pairs = append(pairs, HashPair{anchor, []Peak{targets[j], targets[k]}, address})
+				}
+			}
+		}
+	}
+
+	return pairs
+}
+
+// traceMatch sorts the query hashes found in the matched song by whether they
+// agree with its offset, and collects their votes as analyzeRelativeTiming
+// does.
+func traceMatch(pairs []HashPair, couples map[uint32][]models.Couple, match Match) MatchTrace {
+	trace := MatchTrace{Match: match}
+
+	for _, pair := range pairs {
+		sampleTime := float64(uint32(pair.Anchor.Time * 1000))
+		found, aligned := false, false
+		for _, couple := range couples[pair.Address] {
+			if couple.SongID != match.SongID {
+				continue
+			}
+			offset := float64(couple.AnchorTimeMs) - match.SpeedFactor*sampleTime
+			trace.Offsets = append(trace.Offsets, offset)
+			found = true
+			aligned = aligned || math.Abs(offset-match.OffsetMs) <= offsetToleranceMs
+		}
+
+		switch {
+		case aligned:
+			trace.Aligned = append(trace.Aligned, pair)
+		case found:
+			trace.Misaligned = append(trace.Misaligned, pair)
+		}
+	}
+
+	return trace
+}
+
+// WritePNG renders the visualization as a PNG image. PNG output has no text
+// labels; use WriteSVG for an annotated image.
+func (v *Visualization) WritePNG(w io.Writer) error {
+	l := v.layout()
+	img := image.NewRGBA(image.Rect(0, 0, l.width, l.height))
+	v.draw(&pngCanvas{img: img}, l)
+	return png.Encode(w, img)
+}
+
+// WriteSVG renders the visualization as an SVG document. The spectrogram is
+// embedded as a PNG image under vector peaks, hashes and labels.
+func (v *Visualization) WriteSVG(w io.Writer) error {
+	l := v.layout()
+	c := &svgCanvas{}
+	fmt.Fprintf(&c.b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		l.width, l.height, l.width, l.height)
+	v.draw(c, l)
+	c.b.WriteString("</svg>\n")
+	if c.err != nil {
+		return c.err
+	}
+
+	_, err := io.WriteString(w, c.b.String())
+	return err
+}
+
+// vizLayout places the panels of a visualization.
+type vizLayout struct {
+	width, height  int
+	specTop        int
+	specHeight     int
+	histogramTop   int
+	frameWidth     float64 // pixels per spectrogram frame
+	binHeight      float64 // pixels per spectrogram bin
+	frameDuration  float64
+	freqResolution float64
+}
+
+func (v *Visualization) layout() vizLayout {
+	frames, bins := len(v.Spectrogram), v.Config.WindowSize/2
+	if frames > 0 {
+		bins = len(v.Spectrogram[0])
+	}
+
+	l := vizLayout{
+		width:          max(frames*vizFramePx, vizMinWidth),
+		specTop:        vizMarginPx,
+		specHeight:     bins * vizBinPx,
+		binHeight:      vizBinPx,
+		frameDuration:  v.Config.frameDuration(),
+		freqResolution: v.Config.freqResolution(),
+	}
+	l.frameWidth = float64(l.width) / float64(max(frames, 1))
+	l.height = l.specTop + l.specHeight
+	if v.Trace != nil {
+		l.histogramTop = l.height + vizMarginPx
+		l.height = l.histogramTop + vizHistogramPx
+	}
+	return l
+}
+
+// point returns the centre of the spectrogram cell of a peak.
+func (l vizLayout) point(p Peak) (x, y float64) {
+	x = (math.Round(p.Time/l.frameDuration) + 0.5) * l.frameWidth
+	y = float64(l.specTop+l.specHeight) - (math.Round(p.Freq/l.freqResolution)+0.5)*l.binHeight
+	return x, y
+}
+
+// canvas is the drawing surface shared by the PNG and SVG renderers.
+type canvas interface {
+	image(img *image.RGBA, x, y, w, h int)
+	rect(x, y, w, h float64, c color.NRGBA)
+	line(x0, y0, x1, y1 float64, c color.NRGBA)
+	circle(x, y, r float64, c color.NRGBA)
+	text(x, y float64, s string, c color.NRGBA)
+}
+
+func (v *Visualization) draw(c canvas, l vizLayout) {
+	c.rect(0, 0, float64(l.width), float64(l.height), vizBackground)
+
+	title := fmt.Sprintf("%d frames, %d peaks", len(v.Spectrogram), len(v.Peaks))
+	if t := v.Trace; t != nil {
+		title += fmt.Sprintf(" | %s by %s: %d aligned (green), %d misaligned (red) hashes",
+			t.Match.SongTitle, t.Match.SongArtist, len(t.Aligned), len(t.Misaligned))
+	}
+	c.text(4, float64(l.specTop)-8, title, vizLabel)
+
+	if len(v.Spectrogram) > 0 {
+		c.image(SpectrogramImage(v.Spectrogram), 0, l.specTop, l.width, l.specHeight)
+	}
+
+	if t := v.Trace; t != nil {
+		drawPairs := func(pairs []HashPair, col color.NRGBA) {
+			for _, pair := range pairs {
+				x0, y0 := l.point(pair.Anchor)
+				for _, target := range pair.Targets {
+					x1, y1 := l.point(target)
+					c.line(x0, y0, x1, y1, col)
+				}
+			}
+		}
+		drawPairs(t.Misaligned, vizMisaligned)
+		drawPairs(t.Aligned, vizAligned)
+	}
+
+	for _, peak := range v.Peaks {
+		x, y := l.point(peak)
+		c.circle(x, y, 2.5, vizPeak)
+	}
+
+	if v.Trace != nil {
+		v.drawHistogram(c, l)
+	}
+}
+
+// drawHistogram draws the offset votes of the traced song in 100 ms buckets,
+// the bucket of the match's offset highlighted.
+func (v *Visualization) drawHistogram(c canvas, l vizLayout) {
+	t := v.Trace
+	top, height := float64(l.histogramTop), float64(vizHistogramPx)
+
+	c.text(4, top-8, fmt.Sprintf("offset histogram: %d votes, peak at %s (z-score %.1f)",
+		len(t.Offsets), formatClock(t.Match.OffsetMs/1000), t.Match.ZScore), vizLabel)
+	if len(t.Offsets) == 0 {
+		return
+	}
+
+	counts := make(map[int64]int)
+	lo, hi := int64(math.MaxInt64), int64(math.MinInt64)
+	maxCount := 0
+	for _, offset := range t.Offsets {
+		b := offsetBucket(offset)
+		counts[b]++
+		lo, hi = min(lo, b), max(hi, b)
+		maxCount = max(maxCount, counts[b])
+	}
+
+	barWidth := float64(l.width) / float64(hi-lo+1)
+	best := offsetBucket(t.Match.OffsetMs)
+	for b, count := range counts {
+		col := vizBar
+		if b == best {
+			col = vizAligned
+		}
+		barHeight := float64(count) / float64(maxCount) * (height - 16)
+		c.rect(float64(b-lo)*barWidth, top+height-barHeight, math.Max(barWidth, 1), barHeight, col)
+	}
+
+	c.text(4, top+12, formatClock(float64(lo*offsetBucketMs)/1000), vizLabel)
+	c.text(float64(l.width)-48, top+12, formatClock(float64((hi+1)*offsetBucketMs)/1000), vizLabel)
+}
+
+// pngCanvas draws on an RGBA image.
+type pngCanvas struct {
+	img *image.RGBA
+}
+
+func (p *pngCanvas) image(img *image.RGBA, x, y, w, h int) {
+	src := img.Bounds()
+	for py := 0; py < h; py++ {
+		for px := 0; px < w; px++ {
+			p.img.SetRGBA(x+px, y+py, img.RGBAAt(px*src.Dx()/w, py*src.Dy()/h))
+		}
+	}
+}
+
+func (p *pngCanvas) rect(x, y, w, h float64, c color.NRGBA) {
+	r := image.Rect(int(math.Round(x)), int(math.Round(y)), int(math.Round(x+w)), int(math.Round(y+h)))
+	draw.Draw(p.img, r, image.NewUniform(c), image.Point{}, draw.Over)
+}
+
+func (p *pngCanvas) line(x0, y0, x1, y1 float64, c color.NRGBA) {
+	steps := int(math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))))
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(max(steps, 1))
+		p.blend(x0+t*(x1-x0), y0+t*(y1-y0), c)
+	}
+}
+
+func (p *pngCanvas) circle(x, y, r float64, c color.NRGBA) {
+	steps := int(math.Ceil(2 * math.Pi * r * 2))
+	for i := 0; i < steps; i++ {
+		theta := 2 * math.Pi * float64(i) / float64(steps)
+		p.blend(x+r*math.Cos(theta), y+r*math.Sin(theta), c)
+	}
+}
+
+// text is not rendered: the standard library has no fonts.
+func (p *pngCanvas) text(x, y float64, s string, c color.NRGBA) {}
+
+func (p *pngCanvas) blend(x, y float64, c color.NRGBA) {
+	pt := image.Pt(int(x), int(y))
+	if !pt.In(p.img.Bounds()) {
+		return
+	}
+	draw.Draw(p.img, image.Rectangle{pt, pt.Add(image.Pt(1, 1))}, image.NewUniform(c), image.Point{}, draw.Over)
+}
+
+// svgCanvas writes SVG elements.
+type svgCanvas struct {
+	b   strings.Builder
+	err error
+}
+
+func (s *svgCanvas) image(img *image.RGBA, x, y, w, h int) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		s.err = err
+		return
+	}
+	fmt.Fprintf(&s.b, `<image x="%d" y="%d" width="%d" height="%d" preserveAspectRatio="none" style="image-rendering:pixelated" href="data:image/png;base64,%s"/>`+"\n",
+		x, y, w, h, base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
+
+func (s *svgCanvas) rect(x, y, w, h float64, c color.NRGBA) {
+	fmt.Fprintf(&s.b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`+"\n", x, y, w, h, svgColor(c))
+}
+
+func (s *svgCanvas) line(x0, y0, x1, y1 float64, c color.NRGBA) {
+	fmt.Fprintf(&s.b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s"/>`+"\n", x0, y0, x1, y1, svgColor(c))
+}
+
+func (s *svgCanvas) circle(x, y, r float64, c color.NRGBA) {
+	fmt.Fprintf(&s.b, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="none" stroke="%s"/>`+"\n", x, y, r, svgColor(c))
+}
+
+func (s *svgCanvas) text(x, y float64, text string, c color.NRGBA) {
+	fmt.Fprintf(&s.b, `<text x="%.1f" y="%.1f" fill="%s" font-family="sans-serif" font-size="12">`, x, y, svgColor(c))
+	xml.EscapeText(&s.b, []byte(text))
+	s.b.WriteString("</text>\n")
+}
+
+func svgColor(c color.NRGBA) string {
+	return fmt.Sprintf("rgba(%d,%d,%d,%.2f)", c.R, c.G, c.B, float64(c.A)/255)
+}
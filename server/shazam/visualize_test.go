@@ -0,0 +1,100 @@
+package shazam
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"path/filepath"
+	"song-recognition/db"
+	"strings"
+	"testing"
+)
+
+func TestHashPairsMatchFingerprint(t *testing.T) {
+	peaks := randomPeaks(200, 3)
+	for _, hashing := range []HashScheme{HashPairs, HashTriplets} {
+		cfg := DefaultConfig()
+		cfg.Hashing = hashing
+
+		fingerprints := Fingerprint(peaks, 1, cfg)
+		pairs := hashPairs(peaks, cfg)
+		if len(pairs) != len(fingerprints) {
+			t.Fatalf("%q: %d pairs, want %d", hashing, len(pairs), len(fingerprints))
+		}
+		for i, pair := range pairs {
+			fp := fingerprints[i]
+			if pair.Address != fp.Address || uint32(pair.Anchor.Time*1000) != fp.AnchorTimeMs {
+				t.Fatalf("%q: pair %d = %+v, want %+v", hashing, i, pair, fp)
+			}
+		}
+	}
+}
+
+func TestVisualizeTraceMatch(t *testing.T) {
+	ctx := context.Background()
+	client, err := db.NewSQLiteClient(filepath.Join(t.TempDir(), "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	const sampleRate = 22050
+	cfg := DefaultConfig()
+	song := melody(sampleRate, 30, 7)
+
+	if err := EnsureIndexConfig(ctx, client, cfg); err != nil {
+		t.Fatal(err)
+	}
+	songID, err := client.RegisterSong(ctx, db.Song{Title: "Melody", Artist: "Synth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprints := batchFingerprint(t, song, sampleRate, cfg)
+	for i := range fingerprints {
+		fingerprints[i].SongID = songID
+	}
+	if err := client.StoreFingerprints(ctx, fingerprints); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := Visualize(song[10*sampleRate:18*sampleRate], sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.TraceMatch(ctx, client, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Trace.Aligned) == 0 || len(v.Trace.Aligned) < len(v.Trace.Misaligned) {
+		t.Errorf("%d aligned and %d misaligned hashes, want mostly aligned", len(v.Trace.Aligned), len(v.Trace.Misaligned))
+	}
+	if offset := v.Trace.Match.OffsetMs; offset < 9900 || offset > 10100 {
+		t.Errorf("offset = %.0f ms, want ~10000", offset)
+	}
+	if err := v.TraceMatch(ctx, client, songID+1); err == nil {
+		t.Error("tracing an unknown song succeeded")
+	}
+
+	var buf bytes.Buffer
+	if err := v.WritePNG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := v.layout()
+	if b := img.Bounds(); b.Dx() != l.width || b.Dy() != l.height || l.histogramTop == 0 {
+		t.Errorf("image is %v, want %dx%d with a histogram", b, l.width, l.height)
+	}
+
+	buf.Reset()
+	if err := v.WriteSVG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	for _, want := range []string{"<svg", "data:image/png;base64,", "Melody by Synth", "offset histogram", "</svg>"} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG does not contain %q", want)
+		}
+	}
+}
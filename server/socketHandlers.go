@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"song-recognition/db"
@@ -32,19 +33,10 @@ func downloadStatus(statusType, message string) string {
 	return string(jsonData)
 }
 
-func handleTotalSongs(socket socketio.Conn) {
+func handleTotalSongs(ctx context.Context, socket socketio.Conn, dbClient db.DBClient) {
 	logger := utils.GetLogger()
-	ctx := context.Background()
-
-	db, err := db.NewDBClient()
-	if err != nil {
-		err := xerrors.New(err)
-		logger.ErrorContext(ctx, "error connecting to DB", slog.Any("error", err))
-		return
-	}
-	defer db.Close()
 
-	totalSongs, err := db.TotalSongs()
+	totalSongs, err := dbClient.TotalSongs(ctx)
 	if err != nil {
 		err := xerrors.New(err)
 		logger.ErrorContext(ctx, "Log error getting total songs", slog.Any("error", err))
@@ -54,9 +46,8 @@ func handleTotalSongs(socket socketio.Conn) {
 	socket.Emit("totalSongs", totalSongs)
 }
 
-func handleSongDownload(socket socketio.Conn, spotifyURL string) {
+func handleSongDownload(ctx context.Context, socket socketio.Conn, dbClient db.DBClient, spotifyURL string) {
 	logger := utils.GetLogger()
-	ctx := context.Background()
 
 	// Handle album download
 	if strings.Contains(spotifyURL, "album") {
@@ -76,7 +67,7 @@ func handleSongDownload(socket socketio.Conn, spotifyURL string) {
 		statusMsg := fmt.Sprintf("%v songs found in album.", len(tracksInAlbum))
 		socket.Emit("downloadStatus", downloadStatus("info", statusMsg))
 
-		totalTracksDownloaded, err := spotify.DlAlbum(spotifyURL, SONGS_DIR)
+		totalTracksDownloaded, err := spotify.DlAlbum(ctx, dbClient, spotifyURL, SONGS_DIR)
 		if err != nil {
 			socket.Emit("downloadStatus", downloadStatus("error", "Couldn't to download album."))
 
@@ -106,7 +97,7 @@ func handleSongDownload(socket socketio.Conn, spotifyURL string) {
 		statusMsg := fmt.Sprintf("%v songs found in playlist.", len(tracksInPL))
 		socket.Emit("downloadStatus", downloadStatus("info", statusMsg))
 
-		totalTracksDownloaded, err := spotify.DlPlaylist(spotifyURL, SONGS_DIR)
+		totalTracksDownloaded, err := spotify.DlPlaylist(ctx, dbClient, spotifyURL, SONGS_DIR)
 		if err != nil {
 			socket.Emit("downloadStatus", downloadStatus("error", "Couldn't download playlist."))
 
@@ -134,13 +125,7 @@ func handleSongDownload(socket socketio.Conn, spotifyURL string) {
 		}
 
 		// check if track already exist
-		db, err := db.NewDBClient()
-		if err != nil {
-			fmt.Errorf("Log - error connecting to DB: %d", err)
-		}
-		defer db.Close()
-
-		song, songExists, err := db.GetSongByKey(utils.GenerateSongKey(trackInfo.Title, trackInfo.Artist))
+		song, songExists, err := dbClient.GetSongByKey(ctx, utils.GenerateSongKey(trackInfo.Title, trackInfo.Artist))
 		if err == nil {
 			if songExists {
 				statusMsg := fmt.Sprintf(
@@ -155,7 +140,7 @@ func handleSongDownload(socket socketio.Conn, spotifyURL string) {
 			logger.ErrorContext(ctx, "failed to get song by key.", slog.Any("error", err))
 		}
 
-		totalDownloads, err := spotify.DlSingleTrack(spotifyURL, SONGS_DIR)
+		totalDownloads, err := spotify.DlSingleTrack(ctx, dbClient, spotifyURL, SONGS_DIR)
 		if err != nil {
 			if len(err.Error()) <= 25 {
 				socket.Emit("downloadStatus", downloadStatus("error", err.Error()))
@@ -178,6 +163,102 @@ func handleSongDownload(socket socketio.Conn, spotifyURL string) {
 	}
 }
 
+// handleRemoveSong deletes the song described by removeData, a JSON songRef
+// with an optional "deleteFile" flag, and reports the outcome on
+// "removeStatus".
+func handleRemoveSong(ctx context.Context, socket socketio.Conn, dbClient db.DBClient, removeData string) {
+	logger := utils.GetLogger()
+
+	var data struct {
+		songRef
+		DeleteFile bool `json:"deleteFile"`
+	}
+	if err := json.Unmarshal([]byte(removeData), &data); err != nil {
+		err := xerrors.New(err)
+		logger.ErrorContext(ctx, "Failed to unmarshal remove data.", slog.Any("error", err))
+		socket.Emit("removeStatus", downloadStatus("error", "Invalid request"))
+		return
+	}
+
+	song, found, err := lookupSong(ctx, dbClient, data.songRef)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to look up song.", slog.Any("error", xerrors.New(err)))
+		socket.Emit("removeStatus", downloadStatus("error", err.Error()))
+		return
+	}
+	if !found {
+		socket.Emit("removeStatus", downloadStatus("info", fmt.Sprintf("No song with %s", data.songRef)))
+		return
+	}
+
+	if err := dbClient.DeleteSongByID(ctx, song.ID); err != nil {
+		logger.ErrorContext(ctx, "failed to remove song.", slog.Any("error", xerrors.New(err)))
+		statusMsg := fmt.Sprintf("'%s' by '%s' could not be removed", song.Title, song.Artist)
+		socket.Emit("removeStatus", downloadStatus("error", statusMsg))
+		return
+	}
+
+	statusMsg := fmt.Sprintf("'%s' by '%s' was removed", song.Title, song.Artist)
+	if data.DeleteFile {
+		if _, err := deleteSongFile(song); err != nil {
+			logger.ErrorContext(ctx, "failed to delete song file.", slog.Any("error", xerrors.New(err)))
+			statusMsg += ", but its file could not be deleted"
+		}
+	}
+	socket.Emit("removeStatus", downloadStatus("success", statusMsg))
+	handleTotalSongs(ctx, socket, dbClient)
+}
+
+// maxSongListLimit caps the songs sent on a single "songList" event.
+const maxSongListLimit = 200
+
+// handleListSongs answers a "listSongs" event, or a "searchSongs" event if
+// search is set, with a page of songs on "songList". listData is a JSON object
+// with optional "sort", "desc", "offset" and "limit" fields, plus "text" and
+// "mode" ("substring" or "fulltext") for searches.
+func handleListSongs(ctx context.Context, socket socketio.Conn, dbClient db.DBClient, search bool, listData string) {
+	logger := utils.GetLogger()
+
+	data := struct {
+		Text       string        `json:"text"`
+		Mode       db.SearchMode `json:"mode"`
+		Sort       db.SongSort   `json:"sort"`
+		Descending bool          `json:"desc"`
+		Offset     int           `json:"offset"`
+		Limit      int           `json:"limit"`
+	}{Mode: db.SearchSubstring}
+	if listData != "" {
+		if err := json.Unmarshal([]byte(listData), &data); err != nil {
+			err := xerrors.New(err)
+			logger.ErrorContext(ctx, "Failed to unmarshal song list request.", slog.Any("error", err))
+			socket.Emit("songListError", "Invalid request")
+			return
+		}
+	}
+	if data.Limit <= 0 || data.Limit > maxSongListLimit {
+		data.Limit = maxSongListLimit
+	}
+
+	query := db.SongQuery{SortBy: data.Sort, Descending: data.Descending, Offset: data.Offset, Limit: data.Limit}
+	page, err := findSongs(ctx, dbClient, search, data.Text, data.Mode, query)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to list songs.", slog.Any("error", xerrors.New(err)))
+		socket.Emit("songListError", err.Error())
+		return
+	}
+
+	// Paths on the server are of no use to the browser.
+	for i := range page.Songs {
+		page.Songs[i].FilePath = ""
+	}
+	jsonData, err := json.Marshal(page)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to marshal songs.", slog.Any("error", xerrors.New(err)))
+		return
+	}
+	socket.Emit("songList", string(jsonData))
+}
+
 // handleNewRecording saves new recorded audio snippet to a WAV file.
 func handleNewRecording(socket socketio.Conn, recordData string) {
 	logger := utils.GetLogger()
@@ -216,12 +297,12 @@ func handleNewRecording(socket socketio.Conn, recordData string) {
 	}
 }
 
-func handleNewFingerprint(socket socketio.Conn, fingerprintData string) {
+func handleNewFingerprint(ctx context.Context, socket socketio.Conn, recognizer *shazam.Recognizer, fingerprintData string) {
 	logger := utils.GetLogger()
-	ctx := context.Background()
 
 	var data struct {
-		Fingerprint map[uint32]uint32 `json:"fingerprint"`
+		Fingerprint   []models.Fingerprint `json:"fingerprint"`
+		ConfigVersion string               `json:"configVersion"`
 	}
 	if err := json.Unmarshal([]byte(fingerprintData), &data); err != nil {
 		err := xerrors.New(err)
@@ -229,17 +310,29 @@ func handleNewFingerprint(socket socketio.Conn, fingerprintData string) {
 		return
 	}
 
-	matches, _, err := shazam.FindMatchesFGP(data.Fingerprint)
+	matches, _, err := recognizer.FindMatchesFGP(ctx, data.Fingerprint, data.ConfigVersion)
 	if err != nil {
+		var mismatch *shazam.ConfigMismatchError
+		if errors.As(err, &mismatch) {
+			socket.Emit("matchError", mismatch.Error())
+			logger.Info(mismatch.Error())
+			return
+		}
+
 		err := xerrors.New(err)
 		logger.ErrorContext(ctx, "failed to get matches.", slog.Any("error", err))
+		socket.Emit("matchError", "The server could not search for matches, please try again")
+		return
 	}
 
-	jsonData, err := json.Marshal(matches)
-	if len(matches) > 10 {
-		jsonData, _ = json.Marshal(matches[:10])
+	decision := recognizer.Decide(matches)
+	if decision.NoMatch {
+		socket.Emit("noMatch", decision.Reason)
+		return
 	}
 
+	jsonData, err := json.Marshal(matches)
+
 	if err != nil {
 		err := xerrors.New(err)
 		logger.ErrorContext(ctx, "failed to marshal matches.", slog.Any("error", err))
@@ -23,7 +23,7 @@ const DELETE_SONG_FILE = false // Set true to delete the song file after fingerp
 
 var yellow = color.New(color.FgYellow)
 
-func DlSingleTrack(url, savePath string) (int, error) {
+func DlSingleTrack(ctx context.Context, dbClient db.DBClient, url, savePath string) (int, error) {
 	logger := utils.GetLogger()
 	logger.Info("Getting track info", slog.String("url", url))
 	trackInfo, err := TrackInfo(url)
@@ -34,7 +34,7 @@ func DlSingleTrack(url, savePath string) (int, error) {
 	track := []Track{*trackInfo}
 
 	logger.Info("Now downloading track")
-	totalTracksDownloaded, err := dlTrack(track, savePath)
+	totalTracksDownloaded, err := dlTrack(ctx, dbClient, track, savePath)
 	if err != nil {
 		return 0, err
 	}
@@ -42,7 +42,7 @@ func DlSingleTrack(url, savePath string) (int, error) {
 	return totalTracksDownloaded, nil
 }
 
-func DlPlaylist(url, savePath string) (int, error) {
+func DlPlaylist(ctx context.Context, dbClient db.DBClient, url, savePath string) (int, error) {
 	logger := utils.GetLogger()
 	tracks, err := PlaylistInfo(url)
 	if err != nil {
@@ -51,7 +51,7 @@ func DlPlaylist(url, savePath string) (int, error) {
 
 	time.Sleep(1 * time.Second)
 	logger.Info("Now downloading playlist")
-	totalTracksDownloaded, err := dlTrack(tracks, savePath)
+	totalTracksDownloaded, err := dlTrack(ctx, dbClient, tracks, savePath)
 	if err != nil {
 		return 0, err
 	}
@@ -59,7 +59,7 @@ func DlPlaylist(url, savePath string) (int, error) {
 	return totalTracksDownloaded, nil
 }
 
-func DlAlbum(url, savePath string) (int, error) {
+func DlAlbum(ctx context.Context, dbClient db.DBClient, url, savePath string) (int, error) {
 	logger := utils.GetLogger()
 	tracks, err := AlbumInfo(url)
 	if err != nil {
@@ -68,7 +68,7 @@ func DlAlbum(url, savePath string) (int, error) {
 
 	time.Sleep(1 * time.Second)
 	logger.Info("Now downloading album")
-	totalTracksDownloaded, err := dlTrack(tracks, savePath)
+	totalTracksDownloaded, err := dlTrack(ctx, dbClient, tracks, savePath)
 	if err != nil {
 		return 0, err
 	}
@@ -76,7 +76,7 @@ func DlAlbum(url, savePath string) (int, error) {
 	return totalTracksDownloaded, nil
 }
 
-func dlTrack(tracks []Track, path string) (int, error) {
+func dlTrack(ctx context.Context, dbClient db.DBClient, tracks []Track, path string) (int, error) {
 	var wg sync.WaitGroup
 	var downloadedTracks []string
 	var totalTracks int
@@ -85,14 +85,6 @@ func dlTrack(tracks []Track, path string) (int, error) {
 	numCPUs := runtime.NumCPU()
 	semaphore := make(chan struct{}, numCPUs)
 
-	ctx := context.Background()
-
-	db, err := db.NewDBClient()
-	if err != nil {
-		return 0, err
-	}
-	defer db.Close()
-
 	for _, t := range tracks {
 		wg.Add(1)
 		go func(track Track) {
@@ -102,16 +94,23 @@ func dlTrack(tracks []Track, path string) (int, error) {
 				<-semaphore
 			}()
 
+			// Skip the tracks not started when the download was canceled.
+			if ctx.Err() != nil {
+				return
+			}
+
 			trackCopy := &Track{
 				Album:    track.Album,
 				Artist:   track.Artist,
 				Artists:  track.Artists,
 				Duration: track.Duration,
 				Title:    track.Title,
+				ID:       track.ID,
+				ISRC:     track.ISRC,
 			}
 
 			// check if song exists
-			keyExists, err := SongKeyExists(utils.GenerateSongKey(trackCopy.Title, trackCopy.Artist))
+			keyExists, err := SongKeyExists(ctx, dbClient, utils.GenerateSongKey(trackCopy.Title, trackCopy.Artist))
 			if err != nil {
 				err := xerrors.New(err)
 				logger.ErrorContext(ctx, "error checking song existence", slog.Any("error", err))
@@ -122,7 +121,7 @@ func dlTrack(tracks []Track, path string) (int, error) {
 				return
 			}
 
-			ytID, err := getYTID(trackCopy)
+			ytID, err := getYTID(ctx, dbClient, trackCopy)
 			if ytID == "" || err != nil {
 				logMessage := fmt.Sprintf("'%s' by '%s' could not be downloaded", trackCopy.Title, trackCopy.Artist)
 				logger.ErrorContext(ctx, logMessage, slog.Any("error", xerrors.New(err)))
@@ -140,15 +139,29 @@ func dlTrack(tracks []Track, path string) (int, error) {
 				return
 			}
 
-			err = ProcessAndSaveSong(filePath, trackCopy.Title, trackCopy.Artist, ytID)
+			wavFilePath := filepath.Join(path, fileName+".wav")
+
+			song := db.Song{
+				Title:     trackCopy.Title,
+				Artist:    trackCopy.Artist,
+				YouTubeID: ytID,
+				Album:     trackCopy.Album,
+				Artists:   trackCopy.Artists,
+				Duration:  trackCopy.Duration,
+				SpotifyID: trackCopy.ID,
+				ISRC:      trackCopy.ISRC,
+			}
+			if !DELETE_SONG_FILE {
+				song.FilePath = wavFilePath
+			}
+
+			err = ProcessAndSaveSong(ctx, dbClient, filePath, song)
 			if err != nil {
 				logMessage := fmt.Sprintf("Failed to process song ('%s' by '%s')", trackCopy.Title, trackCopy.Artist)
 				logger.ErrorContext(ctx, logMessage, slog.Any("error", xerrors.New(err)))
 				return
 			}
 
-			wavFilePath := filepath.Join(path, fileName+".wav")
-
 			if err := addTags(wavFilePath, *trackCopy); err != nil {
 				logMessage := fmt.Sprintf("Error adding tags: %s", wavFilePath)
 				logger.ErrorContext(ctx, logMessage, slog.Any("error", xerrors.New(err)))
@@ -217,40 +230,27 @@ func addTags(file string, track Track) error {
 	return nil
 }
 
-func ProcessAndSaveSong(songFilePath, songTitle, songArtist, ytID string) error {
+// ProcessAndSaveSong fingerprints the audio file of a song into the database
+// and registers the song with its metadata.
+func ProcessAndSaveSong(ctx context.Context, dbClient db.DBClient, songFilePath string, song db.Song) error {
 	logger := utils.GetLogger()
-	dbclient, err := db.NewDBClient()
-	if err != nil {
-		logger.Error("Failed to create DB client", slog.Any("error", err))
-		return err
-	}
-	defer dbclient.Close()
 
-	songID, err := dbclient.RegisterSong(songTitle, songArtist, ytID)
+	cfg, err := shazam.ConfigFromEnv()
 	if err != nil {
-		logger.Error("Failed to register song", slog.Any("error", err))
-		return fmt.Errorf("error registering song '%s' by '%s': %v", songTitle, songArtist, err)
-	}
-
-	fingerprint, err := shazam.FingerprintAudio(songFilePath, songID)
-	if err != nil {
-		dbclient.DeleteSongByID(songID)
-		logger.Error("Failed to create fingerprint", slog.String("wavFilePath", songFilePath))
-		return fmt.Errorf("error generating fingerprint for %s by %s", songTitle, songArtist)
+		return err
 	}
 
-	err = dbclient.StoreFingerprints(fingerprint)
+	_, err = shazam.NewIndexer(dbClient, cfg).IndexFile(ctx, songFilePath, song)
 	if err != nil {
-		dbclient.DeleteSongByID(songID)
-		logger.Error("Failed to store fingerprints", slog.Any("error", err))
-		return fmt.Errorf("error storing fingerprint: %v", err)
+		logger.Error("Failed to save song", slog.String("wavFilePath", songFilePath), slog.Any("error", err))
+		return err
 	}
 
-	logger.Info(fmt.Sprintf("Fingerprint for %v by %v saved in DB successfully", songTitle, songArtist))
+	logger.Info(fmt.Sprintf("Fingerprint for %v by %v saved in DB successfully", song.Title, song.Artist))
 	return nil
 }
 
-func getYTID(trackCopy *Track) (string, error) {
+func getYTID(ctx context.Context, dbClient db.DBClient, trackCopy *Track) (string, error) {
 	logger := utils.GetLogger()
 	ytID, err := GetYoutubeId(*trackCopy)
 	if ytID == "" || err != nil {
@@ -258,7 +258,7 @@ func getYTID(trackCopy *Track) (string, error) {
 	}
 
 	// Check if YouTube ID exists
-	ytidExists, err := YtIDExists(ytID)
+	ytidExists, err := YtIDExists(ctx, dbClient, ytID)
 	if err != nil {
 		return "", fmt.Errorf("error checking YT ID existence: %v", err)
 	}
@@ -272,7 +272,7 @@ func getYTID(trackCopy *Track) (string, error) {
 			return "", err
 		}
 
-		ytidExists, err = YtIDExists(ytID)
+		ytidExists, err = YtIDExists(ctx, dbClient, ytID)
 		if err != nil {
 			return "", fmt.Errorf("error checking YT ID existence: %v", err)
 		}
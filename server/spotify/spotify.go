@@ -27,6 +27,8 @@ type Track struct {
 	Title, Artist, Album string
 	Artists              []string
 	Duration             int
+	ID                   string // Spotify track ID
+	ISRC                 string // only known for single tracks
 }
 
 const (
@@ -195,6 +197,7 @@ func TrackInfo(url string) (*Track, error) {
 	}
 
 	var result struct {
+		ID       string `json:"id"`
 		Name     string `json:"name"`
 		Duration int    `json:"duration_ms"`
 		Album    struct {
@@ -203,6 +206,9 @@ func TrackInfo(url string) (*Track, error) {
 		Artists []struct {
 			Name string `json:"name"`
 		} `json:"artists"`
+		ExternalIDs struct {
+			ISRC string `json:"isrc"`
+		} `json:"external_ids"`
 	}
 	if err := json.Unmarshal([]byte(jsonResponse), &result); err != nil {
 		return nil, err
@@ -219,6 +225,8 @@ func TrackInfo(url string) (*Track, error) {
 		Artists:  allArtists,
 		Album:    result.Album.Name,
 		Duration: result.Duration / 1000,
+		ID:       result.ID,
+		ISRC:     result.ExternalIDs.ISRC,
 	}).buildTrack(), nil
 }
 
@@ -405,6 +413,8 @@ func (t *Track) buildTrack() *Track {
 		Artists:  t.Artists,
 		Duration: t.Duration,
 		Album:    t.Album,
+		ID:       t.ID,
+		ISRC:     t.ISRC,
 	}
 
 	return track
@@ -421,6 +431,7 @@ func proccessItems(jsonResponse, resourceType string) []Track {
 	artistName := map[bool]string{true: "itemV2.data.artists.items.0.profile.name", false: "track.artists.items.0.profile.name"}[resourceType == "playlist"]
 	albumName := map[bool]string{true: "itemV2.data.albumOfTrack.name", false: "data.albumUnion.name"}[resourceType == "playlist"]
 	duration := map[bool]string{true: "itemV2.data.trackDuration.totalMilliseconds", false: "track.duration.totalMilliseconds"}[resourceType == "playlist"]
+	uri := map[bool]string{true: "itemV2.data.uri", false: "track.uri"}[resourceType == "playlist"]
 
 	var tracks []Track
 	items := gjson.Get(jsonResponse, itemList).Array()
@@ -432,6 +443,7 @@ func proccessItems(jsonResponse, resourceType string) []Track {
 			Title:    item.Get(songTitle).String(),
 			Artist:   item.Get(artistName).String(),
 			Duration: durationInSeconds,
+			ID:       strings.TrimPrefix(item.Get(uri).String(), "spotify:track:"),
 			Album:    map[bool]string{true: item.Get(albumName).String(), false: gjson.Get(jsonResponse, albumName).String()}[resourceType == "playlist"],
 		}
 		tracks = append(tracks, *track.buildTrack())
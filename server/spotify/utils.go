@@ -1,6 +1,7 @@
 package spotify
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/url"
@@ -39,14 +40,8 @@ func GetFileSize(file string) (int64, error) {
 	return size, nil
 }
 
-func SongKeyExists(key string) (bool, error) {
-	db, err := db.NewDBClient()
-	if err != nil {
-		return false, err
-	}
-	defer db.Close()
-
-	_, songExists, err := db.GetSongByKey(key)
+func SongKeyExists(ctx context.Context, dbClient db.DBClient, key string) (bool, error) {
+	_, songExists, err := dbClient.GetSongByKey(ctx, key)
 	if err != nil {
 		return false, err
 	}
@@ -54,14 +49,8 @@ func SongKeyExists(key string) (bool, error) {
 	return songExists, nil
 }
 
-func YtIDExists(ytID string) (bool, error) {
-	db, err := db.NewDBClient()
-	if err != nil {
-		return false, err
-	}
-	defer db.Close()
-
-	_, songExits, err := db.GetSongByYTID(ytID)
+func YtIDExists(ctx context.Context, dbClient db.DBClient, ytID string) (bool, error) {
+	_, songExits, err := dbClient.GetSongByYTID(ctx, ytID)
 	if err != nil {
 		return false, err
 	}
@@ -0,0 +1,75 @@
+package synth
+
+import (
+	"math"
+	"math/rand"
+)
+
+// majorScale holds the semitone steps of a major scale from its root.
+var majorScale = []int{0, 2, 4, 5, 7, 9, 11}
+
+// Song returns a pseudo-random piece of music determined by seed: a melody of
+// notes with harmonics over a bass line and sustained chords, at a tempo and
+// in a key drawn from the seed. Different seeds give songs that share
+// almost no fingerprints, so they can stand in for a library.
+func Song(seed int64, seconds float64, sampleRate int) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	n := numSamples(seconds, sampleRate)
+	x := make([]float64, n)
+
+	root := 48 + rng.Intn(12)            // MIDI note of the key, C3 to B3
+	beat := 60 / (90 + 60*rng.Float64()) // 90 to 150 BPM
+
+	// Melody: notes of one to four eighths, two octaves above the root.
+	for pos := 0.0; pos < seconds; {
+		length := beat / 2 * float64(1+rng.Intn(4))
+		step := majorScale[rng.Intn(len(majorScale))] + 12*rng.Intn(2)
+		addNote(x, midiFreq(root+24+step), pos, length, 0.5, sampleRate)
+		pos += length
+	}
+
+	// Bass and chords: one scale degree per bar of four beats.
+	for pos := 0.0; pos < seconds; pos += 4 * beat {
+		degree := rng.Intn(len(majorScale))
+		addNote(x, midiFreq(root-12+majorScale[degree]), pos, 4*beat, 0.3, sampleRate)
+		for _, third := range []int{0, 2, 4} {
+			step := majorScale[(degree+third)%len(majorScale)]
+			addNote(x, midiFreq(root+12+step), pos, 4*beat, 0.1, sampleRate)
+		}
+	}
+
+	return Normalize(x, 0.8)
+}
+
+// midiFreq returns the frequency of a MIDI note number.
+func midiFreq(note int) float64 {
+	return 440 * math.Pow(2, float64(note-69)/12)
+}
+
+// addNote adds a decaying note with three harmonics to x.
+func addNote(x []float64, freq, start, seconds, amplitude float64, sampleRate int) {
+	first := numSamples(start, sampleRate)
+	last := min(first+numSamples(seconds, sampleRate), len(x))
+	if first >= last {
+		return
+	}
+
+	// Each harmonic is a phasor rotated once per sample, and the decay a
+	// constant factor per sample, which avoids calling sin and exp per sample.
+	note := make([]float64, last-first)
+	decay := math.Exp(-3 / seconds / float64(sampleRate)) // ~26 dB over the note
+	for h, gain := range []float64{1, 0.5, 0.25} {
+		w := 2 * math.Pi * freq * float64(h+1) / float64(sampleRate)
+		cosW, sinW := math.Cos(w), math.Sin(w)
+		re, im := amplitude*gain, 0.0
+		for i := range note {
+			note[i] += im
+			re, im = (re*cosW-im*sinW)*decay, (re*sinW+im*cosW)*decay
+		}
+	}
+	fade(note, sampleRate)
+
+	for i, v := range note {
+		x[first+i] += v
+	}
+}
@@ -0,0 +1,165 @@
+// Package synth generates deterministic test signals: tones, chords, chirps,
+// noise beds and pseudo-random songs. Signals are mono float64 samples in
+// [-1, 1]; the same arguments always give the same samples, so tests can
+// index and query audio without shipping recordings.
+package synth
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"song-recognition/utils"
+	"song-recognition/wav"
+)
+
+// fadeSeconds is the length of the fades that keep tones from clicking.
+const fadeSeconds = 0.005
+
+// numSamples returns the number of samples in seconds of audio.
+func numSamples(seconds float64, sampleRate int) int {
+	return max(int(math.Round(seconds*float64(sampleRate))), 0)
+}
+
+// Tone returns a sine wave at freq Hz with the given amplitude, faded in and
+// out.
+func Tone(freq, seconds, amplitude float64, sampleRate int) []float64 {
+	return Chord([]float64{freq}, seconds, amplitude, sampleRate)
+}
+
+// Chord returns the sum of sine waves at freqs, each with amplitude divided by
+// the number of notes so the chord peaks at amplitude at most.
+func Chord(freqs []float64, seconds, amplitude float64, sampleRate int) []float64 {
+	x := make([]float64, numSamples(seconds, sampleRate))
+	if len(freqs) == 0 {
+		return x
+	}
+
+	a := amplitude / float64(len(freqs))
+	for _, freq := range freqs {
+		w := 2 * math.Pi * freq / float64(sampleRate)
+		for i := range x {
+			x[i] += a * math.Sin(w*float64(i))
+		}
+	}
+	fade(x, sampleRate)
+	return x
+}
+
+// ToneSequence plays freqs one after the other, each for noteSeconds. A
+// frequency of 0 is a rest.
+func ToneSequence(freqs []float64, noteSeconds, amplitude float64, sampleRate int) []float64 {
+	var x []float64
+	for _, freq := range freqs {
+		if freq <= 0 {
+			x = append(x, make([]float64, numSamples(noteSeconds, sampleRate))...)
+			continue
+		}
+		x = append(x, Tone(freq, noteSeconds, amplitude, sampleRate)...)
+	}
+	return x
+}
+
+// Chirp returns a sine sweep from startFreq to endFreq Hz. The frequency
+// changes exponentially, so every octave takes the same time.
+func Chirp(startFreq, endFreq, seconds, amplitude float64, sampleRate int) []float64 {
+	x := make([]float64, numSamples(seconds, sampleRate))
+	rate := math.Log(endFreq/startFreq) / seconds // growth of log frequency per second
+
+	var phase float64
+	for i := range x {
+		t := float64(i) / float64(sampleRate)
+		freq := startFreq
+		if rate != 0 {
+			freq = startFreq * math.Exp(rate*t)
+		}
+		x[i] = amplitude * math.Sin(phase)
+		phase += 2 * math.Pi * freq / float64(sampleRate)
+	}
+	fade(x, sampleRate)
+	return x
+}
+
+// WhiteNoise returns uniform white noise with the given peak amplitude.
+func WhiteNoise(seed int64, seconds, amplitude float64, sampleRate int) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	x := make([]float64, numSamples(seconds, sampleRate))
+	for i := range x {
+		x[i] = amplitude * (2*rng.Float64() - 1)
+	}
+	return x
+}
+
+// PinkNoise returns noise whose power falls by 3 dB per octave, like a room or
+// crowd, scaled to about the given peak amplitude. It uses Paul Kellet's
+// economy filter.
+func PinkNoise(seed int64, seconds, amplitude float64, sampleRate int) []float64 {
+	white := WhiteNoise(seed, seconds, 1, sampleRate)
+	x := make([]float64, len(white))
+
+	var b0, b1, b2 float64
+	for i, w := range white {
+		b0 = 0.99765*b0 + w*0.0990460
+		b1 = 0.96300*b1 + w*0.2965164
+		b2 = 0.57000*b2 + w*1.0526913
+		x[i] = (b0 + b1 + b2 + w*0.1848) * amplitude / 4
+	}
+	return x
+}
+
+// Mix adds signals sample by sample. The result is as long as the longest
+// signal and is not normalised.
+func Mix(signals ...[]float64) []float64 {
+	n := 0
+	for _, s := range signals {
+		n = max(n, len(s))
+	}
+
+	x := make([]float64, n)
+	for _, s := range signals {
+		for i, v := range s {
+			x[i] += v
+		}
+	}
+	return x
+}
+
+// Normalize scales x in place so its largest magnitude is peak, and returns
+// it.
+func Normalize(x []float64, peak float64) []float64 {
+	var m float64
+	for _, v := range x {
+		m = math.Max(m, math.Abs(v))
+	}
+	if m == 0 {
+		return x
+	}
+	for i := range x {
+		x[i] *= peak / m
+	}
+	return x
+}
+
+// WriteWAV writes samples to a mono 16-bit WAV file with wav.WriteWavFile.
+// Samples are clipped to [-1, 1].
+func WriteWAV(path string, samples []float64, sampleRate int) error {
+	clipped := make([]float64, len(samples))
+	for i, v := range samples {
+		clipped[i] = math.Max(-1, math.Min(1, v))
+	}
+
+	data, err := utils.FloatsToBytes(clipped, 16)
+	if err != nil {
+		return fmt.Errorf("failed to convert samples: %v", err)
+	}
+	return wav.WriteWavFile(path, data, sampleRate, 1, 16)
+}
+
+// fade applies raised-cosine fades to both ends of x.
+func fade(x []float64, sampleRate int) {
+	n := min(numSamples(fadeSeconds, sampleRate), len(x)/2)
+	for i := 0; i < n; i++ {
+		g := 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(n))
+		x[i] *= g
+		x[len(x)-1-i] *= g
+	}
+}
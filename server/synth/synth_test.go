@@ -0,0 +1,89 @@
+package synth
+
+import (
+	"math"
+	"path/filepath"
+	"song-recognition/wav"
+	"testing"
+)
+
+// zeroCrossings counts the sign changes of x.
+func zeroCrossings(x []float64) int {
+	n := 0
+	for i := 1; i < len(x); i++ {
+		if (x[i-1] < 0) != (x[i] < 0) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestToneFrequency(t *testing.T) {
+	x := Tone(440, 1, 0.5, 8000)
+	if len(x) != 8000 {
+		t.Fatalf("got %d samples, want 8000", len(x))
+	}
+	if n := zeroCrossings(x); n < 878 || n > 882 {
+		t.Errorf("%d zero crossings in 1 s, want ~880", n)
+	}
+	if x[0] != 0 || x[len(x)-1] != 0 {
+		t.Error("tone is not faded in and out")
+	}
+}
+
+func TestChirpSweepsOctaves(t *testing.T) {
+	const sampleRate = 16000
+	x := Chirp(200, 800, 2, 1, sampleRate)
+	first, second := x[:sampleRate], x[sampleRate:]
+	// Each second covers one octave: 200–400 Hz, then 400–800 Hz.
+	if n := zeroCrossings(first); math.Abs(float64(n)-2*288.5) > 10 {
+		t.Errorf("%d zero crossings in the first second, want ~577", n)
+	}
+	if n := zeroCrossings(second); math.Abs(float64(n)-2*577) > 10 {
+		t.Errorf("%d zero crossings in the second second, want ~1154", n)
+	}
+}
+
+func TestSongIsDeterministic(t *testing.T) {
+	a, b, c := Song(1, 5, 8000), Song(1, 5, 8000), Song(2, 5, 8000)
+	if len(a) != 40000 {
+		t.Fatalf("got %d samples, want 40000", len(a))
+	}
+	same := true
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("sample %d differs between runs with the same seed", i)
+		}
+		same = same && a[i] == c[i]
+	}
+	if same {
+		t.Error("different seeds gave the same song")
+	}
+	for _, v := range a {
+		if math.Abs(v) > 0.8+1e-9 {
+			t.Fatalf("sample %g exceeds the 0.8 peak", v)
+		}
+	}
+}
+
+func TestWriteWAVRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "noise.wav")
+	x := Mix(PinkNoise(3, 0.5, 0.5, 22050), Tone(1000, 0.25, 0.4, 22050))
+	if err := WriteWAV(path, x, 22050); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := wav.ReadWavInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.SampleRate != 22050 || info.Channels != 1 || len(info.LeftChannelSamples) != len(x) {
+		t.Fatalf("read %d Hz, %d channels, %d samples", info.SampleRate, info.Channels, len(info.LeftChannelSamples))
+	}
+	for i, v := range info.LeftChannelSamples {
+		// WriteWAV clips samples outside [-1, 1].
+		if want := math.Max(-1, math.Min(1, x[i])); math.Abs(v-want) > 1e-4 {
+			t.Fatalf("sample %d = %g, want %g", i, v, want)
+		}
+	}
+}
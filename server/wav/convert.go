@@ -2,6 +2,7 @@ package wav
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -91,3 +92,115 @@ func ReformatWAV(inputFilePath string, channels int) (reformatedFilePath string,
 
 	return outputFile, nil
 }
+
+// DecodeStream starts ffmpeg decoding source (a file, URL or named pipe) to
+// signed 16-bit little-endian PCM with the given rate and channels, and
+// returns its output as it is produced. Closing the stream stops ffmpeg.
+func DecodeStream(source string, sampleRate, channels int) (io.ReadCloser, error) {
+	cmd := decodeCommand(source, sampleRate, channels)
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ffmpeg output: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %v", err)
+	}
+
+	return &ffmpegStream{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// decodeCommand returns the ffmpeg command decoding source to signed 16-bit
+// little-endian PCM on its standard output.
+func decodeCommand(source string, sampleRate, channels int) *exec.Cmd {
+	if channels < 1 || channels > 2 {
+		channels = 1
+	}
+
+	return exec.Command(
+		"ffmpeg",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", source,
+		"-f", "s16le",
+		"-c:a", "pcm_s16le",
+		"-ar", fmt.Sprint(sampleRate),
+		"-ac", fmt.Sprint(channels),
+		"pipe:1",
+	)
+}
+
+type ffmpegStream struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (s *ffmpegStream) Close() error {
+	if s.cmd.ProcessState == nil {
+		s.cmd.Process.Kill()
+	}
+	s.ReadCloser.Close()
+	s.cmd.Wait()
+	return nil
+}
+
+// DecodeSamples decodes source to mono samples at sampleRate with ffmpeg,
+// without writing any file.
+func DecodeSamples(source string, sampleRate int) ([]float64, error) {
+	cmd := decodeCommand(source, sampleRate, 1)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	data, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %v: %v, output %v", source, err, stderr.String())
+	}
+
+	return WavBytesToSamples(data[:len(data)&^1])
+}
+
+// Reencode passes mono samples through a lossy codec ("mp3" or "opus") at
+// bitrateKbps with ffmpeg and returns the decoded result at sampleRate.
+func Reencode(samples []float64, sampleRate int, codec string, bitrateKbps int) ([]float64, error) {
+	encoders := map[string]struct{ encoder, ext string }{
+		"mp3":  {"libmp3lame", ".mp3"},
+		"opus": {"libopus", ".ogg"},
+	}
+	enc, ok := encoders[codec]
+	if !ok {
+		return nil, fmt.Errorf("unsupported codec %q (expected mp3 or opus)", codec)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "reencode")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	data, err := utils.FloatsToBytes(samples, 16)
+	if err != nil {
+		return nil, err
+	}
+	inputFile := filepath.Join(tmpDir, "input.wav")
+	if err := WriteWavFile(inputFile, data, sampleRate, 1, 16); err != nil {
+		return nil, err
+	}
+
+	encodedFile := filepath.Join(tmpDir, "encoded"+enc.ext)
+	cmd := exec.Command(
+		"ffmpeg",
+		"-y",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", inputFile,
+		"-c:a", enc.encoder,
+		"-b:a", fmt.Sprintf("%dk", bitrateKbps),
+		encodedFile,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to encode to %s: %v, output %v", codec, err, string(output))
+	}
+
+	return DecodeSamples(encodedFile, sampleRate)
+}
@@ -11,8 +11,13 @@ import (
 )
 
 // generateFingerprint takes audio data from the frontend and generates fingerprints
-// Arguments: [audioArray, sampleRate, channels]
-// Returns: { error: number, data: fingerprintArray or error message }
+// Arguments: [audioArray, sampleRate, channels, peakPicker?, hashing?]
+// peakPicker is optional and may be "bands" (default) or "lmx"; hashing may be
+// "pair" (default) or "triplet". Both must match the settings the server's
+// database was built with.
+// Returns: { error: number, data: fingerprintArray or error message, configVersion: string }
+// configVersion identifies the fingerprint config and must be sent to the
+// server with the fingerprint so it can reject incompatible queries.
 func generateFingerprint(this js.Value, args []js.Value) interface{} {
 	if len(args) < 3 {
 		return js.ValueOf(map[string]interface{}{
@@ -36,6 +41,31 @@ func generateFingerprint(this js.Value, args []js.Value) interface{} {
 		})
 	}
 
+	cfg := shazam.DefaultConfig()
+	if len(args) > 3 && args[3].Type() == js.TypeString {
+		switch picker := shazam.PeakPicker(args[3].String()); picker {
+		case shazam.PeakPickerBands:
+		case shazam.PeakPickerLMX:
+			cfg.Peaks = shazam.DefaultLMXPeakOptions()
+		default:
+			return js.ValueOf(map[string]interface{}{
+				"error": 2,
+				"data":  "Invalid peak picker; expected \"bands\" or \"lmx\"",
+			})
+		}
+	}
+
+	if len(args) > 4 && args[4].Type() == js.TypeString {
+		hashing, err := shazam.ParseHashScheme(args[4].String())
+		if err != nil {
+			return js.ValueOf(map[string]interface{}{
+				"error": 2,
+				"data":  "Invalid hashing scheme; expected \"pair\" or \"triplet\"",
+			})
+		}
+		cfg.Hashing = hashing
+	}
+
 	inputArray := args[0]
 	sampleRate := args[1].Int()
 
@@ -44,20 +74,21 @@ func generateFingerprint(this js.Value, args []js.Value) interface{} {
 		audioData[i] = inputArray.Index(i).Float()
 	}
 
-	fingerprint := make(map[uint32]models.Couple)
+	var fingerprint []models.Fingerprint
+	sampleID := utils.GenerateUniqueID()
 	var leftChannel, rightChannel []float64
 
 	if channels == 1 {
 		leftChannel = audioData
-		spectrogram, err := shazam.Spectrogram(audioData, sampleRate)
+		spectrogram, err := shazam.Spectrogram(audioData, sampleRate, cfg)
 		if err != nil {
 			return js.ValueOf(map[string]interface{}{
 				"error": 3,
 				"data":  "Error generating spectrogram: " + err.Error(),
 			})
 		}
-		peaks := shazam.ExtractPeaks(spectrogram, float64(len(audioData))/float64(sampleRate), sampleRate)
-		fingerprint = shazam.Fingerprint(peaks, utils.GenerateUniqueID())
+		peaks := shazam.ExtractPeaksWith(spectrogram, cfg)
+		fingerprint = shazam.Fingerprint(peaks, sampleID, cfg)
 	} else {
 		for i := 0; i < len(audioData); i += 2 {
 			leftChannel = append(leftChannel, audioData[i])
@@ -65,40 +96,41 @@ func generateFingerprint(this js.Value, args []js.Value) interface{} {
 		}
 
 		// LEFT
-		spectrogram, err := shazam.Spectrogram(leftChannel, sampleRate)
+		spectrogram, err := shazam.Spectrogram(leftChannel, sampleRate, cfg)
 		if err != nil {
 			return js.ValueOf(map[string]interface{}{
 				"error": 3,
 				"data":  "Error generating spectrogram: " + err.Error(),
 			})
 		}
-		peaks := shazam.ExtractPeaks(spectrogram, float64(len(leftChannel))/float64(sampleRate), sampleRate)
-		utils.ExtendMap(fingerprint, shazam.Fingerprint(peaks, utils.GenerateUniqueID()))
+		peaks := shazam.ExtractPeaksWith(spectrogram, cfg)
+		fingerprint = shazam.Fingerprint(peaks, sampleID, cfg)
 
 		// RIGHT
-		spectrogram, err = shazam.Spectrogram(rightChannel, sampleRate)
+		spectrogram, err = shazam.Spectrogram(rightChannel, sampleRate, cfg)
 		if err != nil {
 			return js.ValueOf(map[string]interface{}{
 				"error": 3,
 				"data":  "Error generating spectrogram: " + err.Error(),
 			})
 		}
-		peaks = shazam.ExtractPeaks(spectrogram, float64(len(rightChannel))/float64(sampleRate), sampleRate)
-		utils.ExtendMap(fingerprint, shazam.Fingerprint(peaks, utils.GenerateUniqueID()))
+		peaks = shazam.ExtractPeaksWith(spectrogram, cfg)
+		fingerprint = shazam.MergeFingerprints(fingerprint, shazam.Fingerprint(peaks, sampleID, cfg))
 	}
 
 	fingerprintArray := []interface{}{}
-	for address, couple := range fingerprint {
+	for _, fp := range fingerprint {
 		entry := map[string]interface{}{
-			"address":    address,
-			"anchorTime": couple.AnchorTimeMs,
+			"address":    fp.Address,
+			"anchorTime": fp.AnchorTimeMs,
 		}
 		fingerprintArray = append(fingerprintArray, entry)
 	}
 
 	return js.ValueOf(map[string]interface{}{
-		"error": 0,
-		"data":  fingerprintArray,
+		"error":         0,
+		"data":          fingerprintArray,
+		"configVersion": cfg.Version(),
 	})
 }
 